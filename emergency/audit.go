@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Actions enregistrées dans le journal d'audit.
+const (
+	auditForwarded = "forwarded"
+	auditRejected  = "rejected"
+	auditDropped   = "dropped"
+)
+
+// auditEntry est une ligne (JSON) du journal d'audit.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Topic   string    `json:"topic"`
+	Vehicle string    `json:"vehicle,omitempty"`
+	Type    string    `json:"type,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Payload string    `json:"payload"`
+}
+
+// auditLog est un journal append-only des messages médiés, un objet JSON par ligne.
+// Un auditLog nil n'enregistre rien.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file}, nil
+}
+
+func (a *auditLog) record(entry auditEntry) {
+	if a == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("[Audit] Could not marshal entry:", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		log.Println("[Audit] Could not write entry:", err)
+	}
+}
+
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// auditFilter sélectionne les entrées à afficher. Les champs vides ne filtrent pas.
+type auditFilter struct {
+	Vehicle string
+	Action  string
+	Type    string
+	Since   time.Time
+}
+
+func (f auditFilter) match(entry auditEntry) bool {
+	return (f.Vehicle == "" || f.Vehicle == entry.Vehicle) &&
+		(f.Action == "" || f.Action == entry.Action) &&
+		(f.Type == "" || f.Type == entry.Type) &&
+		!entry.Time.Before(f.Since)
+}
+
+// queryAudit écrit dans w les entrées du journal qui correspondent au filtre, puis un résumé par action.
+func queryAudit(path string, filter auditFilter, w io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	counts := map[string]int{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if !filter.match(entry) {
+			continue
+		}
+		counts[entry.Action]++
+		fmt.Fprintf(w, "%s\t%-9s\t%s\t%s\t%s\t%s\n", entry.Time.Format(time.RFC3339), entry.Action, entry.Vehicle, entry.Type, entry.Payload, entry.Reason)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	actions := make([]string, 0, len(counts))
+	for action := range counts {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		fmt.Fprintf(w, "# %s: %d\n", action, counts[action])
+	}
+	return nil
+}
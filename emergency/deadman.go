@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hyperdrive/remote/hyperdrive"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// controllerState est le dernier état connu d'un processus de contrôle (RemoteControl, pathfind).
+type controllerState struct {
+	vehicles []string  // véhicules annoncés dans le dernier heartbeat
+	lastSeen time.Time // réception du dernier heartbeat
+	alive    bool
+}
+
+// watchControllers s'abonne aux heartbeats et arrête les véhicules d'un contrôleur
+// resté silencieux plus longtemps que timeout, ou dont le Last Will a été publié.
+func (e *Emergency) watchControllers(timeout time.Duration) error {
+	if token := e.client.Subscribe(hyperdrive.HeartbeatTopic.Filter(), e.qos, func(client mqtt.Client, msg mqtt.Message) {
+		var data hyperdrive.HeartbeatPayload
+		if err := json.Unmarshal(msg.Payload(), &data); err != nil {
+			log.Println("[Deadman] Could not read heartbeat on", msg.Topic(), ":", err)
+			return
+		}
+
+		e.mu.Lock()
+		state, known := e.controllers[data.Controller]
+		if !known {
+			state = &controllerState{}
+			e.controllers[data.Controller] = state
+		}
+		wasAlive := state.alive
+		state.lastSeen = time.Now()
+		state.alive = data.Alive
+		if data.Alive || data.Clean {
+			state.vehicles = data.Vehicles
+		}
+		vehicles := slices.Clone(state.vehicles)
+		e.mu.Unlock()
+
+		switch {
+		case data.Clean:
+			log.Println("[Deadman] Controller", data.Controller, "shut down cleanly")
+		case !data.Alive && (wasAlive || !known):
+			log.Println("[Deadman] Got the last will of controller", data.Controller)
+			e.stopControllerVehicles(data.Controller, vehicles)
+		}
+
+		if !known || wasAlive != data.Alive {
+			e.notify()
+		}
+	}); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	go func() {
+		ticker := time.NewTicker(timeout / 4)
+		defer ticker.Stop()
+		for range ticker.C {
+			e.mu.Lock()
+			silent := map[string][]string{}
+			for id, state := range e.controllers {
+				if state.alive && time.Since(state.lastSeen) > timeout {
+					state.alive = false
+					silent[id] = slices.Clone(state.vehicles)
+				}
+			}
+			e.mu.Unlock()
+
+			for id, vehicles := range silent {
+				log.Println("[Deadman] Controller", id, "has been silent for more than", timeout)
+				e.stopControllerVehicles(id, vehicles)
+			}
+			if len(silent) > 0 {
+				e.notify()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// stopControllerVehicles publie le message d'arrêt pour les véhicules d'un contrôleur disparu.
+func (e *Emergency) stopControllerVehicles(controller string, vehicles []string) {
+	if len(vehicles) == 0 {
+		log.Println("[Deadman] Controller", controller, "did not own any vehicle, nothing to stop")
+		return
+	}
+
+	log.Println("[Deadman] Stopping", vehicles, "owned by controller", controller)
+	for _, vehicle := range vehicles {
+		e.stopVehicle(vehicle, "controller "+controller+" went silent")
+	}
+}
+
+// controllerSummary renvoie une ligne lisible par contrôleur, triée par ID.
+func (e *Emergency) controllerSummary() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	lines := make([]string, 0, len(e.controllers))
+	for id, state := range e.controllers {
+		status := "alive"
+		if !state.alive {
+			status = "SILENT"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s [%s]", id, status, strings.Join(state.vehicles, ", ")))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// aliveControllers renvoie les identifiants des contrôleurs vivants, p.ex. pour un transfert de véhicule.
+func (e *Emergency) aliveControllers() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ids := make([]string, 0, len(e.controllers))
+	for id, state := range e.controllers {
+		if state.alive {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,10 @@
+//go:build headless
+
+package main
+
+import "log"
+
+// runUI remplace l'UI dans un binaire construit avec -tags headless, qui ne contient pas Fyne.
+func runUI(em *Emergency, defaults mediatorConfig) {
+	log.Fatal("Emergency was built without UI (-tags headless), run it with -headless")
+}
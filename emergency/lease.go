@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hyperdrive/remote/hyperdrive"
+	"hyperdrive/remote/topic"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// watchClaims s'abonne aux demandes de bail des contrôleurs et accorde chaque véhicule à un
+// seul contrôleur à la fois. Les baux non renouvelés expirent.
+func (e *Emergency) watchClaims() error {
+	if token := e.client.Subscribe(hyperdrive.LeaseClaimTopic.Filter(), e.qos, e.claimHandler); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	go func() {
+		ticker := time.NewTicker(hyperdrive.LeaseRenewInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			e.mu.Lock()
+			var expired []string
+			for vehicle, lease := range e.leases {
+				if !lease.Active() {
+					expired = append(expired, vehicle)
+					delete(e.leases, vehicle)
+				}
+			}
+			e.mu.Unlock()
+
+			for _, vehicle := range expired {
+				log.Println("[Lease] Lease of", vehicle, "expired")
+				e.publishLease(vehicle)
+			}
+			if len(expired) > 0 {
+				e.notify()
+			}
+		}
+	}()
+	return nil
+}
+
+// claimHandler traite une demande (ou une libération, payload vide) de bail.
+func (e *Emergency) claimHandler(client mqtt.Client, msg mqtt.Message) {
+	values, ok := hyperdrive.LeaseClaimTopic.Match(msg.Topic())
+	if !ok {
+		return
+	}
+	vehicle, controller := values["vehicle"], values["controller"]
+
+	if len(msg.Payload()) == 0 {
+		e.mu.Lock()
+		lease, ok := e.leases[vehicle]
+		released := ok && lease.Owner == controller
+		if released {
+			delete(e.leases, vehicle)
+		}
+		e.mu.Unlock()
+
+		if released {
+			log.Println("[Lease] Controller", controller, "released", vehicle)
+			e.publishLease(vehicle)
+			e.notify()
+		}
+		return
+	}
+
+	// Une demande retenue rejouée à l'abonnement peut venir d'un contrôleur disparu: seules les
+	// demandes reçues en direct comptent, un contrôleur actif renouvelle la sienne chaque seconde.
+	// L'horodatage du contrôleur n'est pas utilisé, son horloge peut différer de la nôtre.
+	if msg.Retained() {
+		return
+	}
+	var claim hyperdrive.LeaseClaim
+	if err := json.Unmarshal(msg.Payload(), &claim); err != nil {
+		log.Println("[Lease] Could not read claim on", msg.Topic(), ":", err)
+		return
+	}
+	duration := time.Duration(claim.Duration) * time.Millisecond
+	if duration <= 0 || duration > hyperdrive.LeaseDuration {
+		duration = hyperdrive.LeaseDuration
+	}
+	claimed := time.Now()
+
+	e.mu.Lock()
+	lease, ok := e.leases[vehicle]
+	granted := !ok || !lease.Active() || lease.Owner == controller
+	newOwner := granted && lease.Owner != controller
+	if granted {
+		e.leases[vehicle] = hyperdrive.Lease{
+			Vehicle: vehicle,
+			Owner:   controller,
+			Expires: claimed.Add(duration).UnixMilli(),
+			Forced:  lease.Forced && lease.Owner == controller,
+		}
+	}
+	e.mu.Unlock()
+
+	if !granted {
+		log.Println("[Lease] Denied", vehicle, "to", controller, ", it is owned by", lease.Owner)
+		return
+	}
+	if newOwner {
+		log.Println("[Lease] Granted", vehicle, "to", controller)
+		e.notify()
+	}
+	e.publishLease(vehicle)
+}
+
+// forceLease donne le véhicule à un autre contrôleur, sur décision de l'opérateur.
+// Un contrôleur vide libère le véhicule.
+func (e *Emergency) forceLease(vehicle, controller string) {
+	e.mu.Lock()
+	previous := e.leases[vehicle].Owner
+	if controller == "" {
+		delete(e.leases, vehicle)
+	} else {
+		e.leases[vehicle] = hyperdrive.Lease{
+			Vehicle: vehicle,
+			Owner:   controller,
+			Expires: time.Now().Add(hyperdrive.LeaseDuration).UnixMilli(),
+			Forced:  true,
+		}
+	}
+	e.mu.Unlock()
+
+	log.Printf("[Lease] Operator transferred %s from %q to %q", vehicle, previous, controller)
+	e.publishLease(vehicle)
+	// Le précédent propriétaire ne doit pas reprendre le véhicule avec sa demande retenue.
+	if previous != "" && previous != controller {
+		e.client.Publish(hyperdrive.LeaseClaimTopic.Format(topic.Values{"vehicle": vehicle, "controller": previous}), e.qos, true, []byte{})
+	}
+	e.notify()
+}
+
+// publishLease publie (retained) le bail courant d'un véhicule.
+func (e *Emergency) publishLease(vehicle string) {
+	e.mu.Lock()
+	lease, ok := e.leases[vehicle]
+	e.mu.Unlock()
+
+	var data []byte
+	if ok {
+		var err error
+		if data, err = json.Marshal(lease); err != nil {
+			log.Println("[Lease] Could not marshal lease:", err)
+			return
+		}
+	} else {
+		// Un bail sans propriétaire (plutôt qu'un message vide) pour que les contrôleurs voient la libération.
+		data, _ = json.Marshal(hyperdrive.Lease{Vehicle: vehicle})
+	}
+
+	leaseTopic := hyperdrive.LeaseTopic.Format(topic.Values{"vehicle": vehicle})
+	if token := e.client.Publish(leaseTopic, e.qos, true, data); token.Wait() && token.Error() != nil {
+		log.Println("[Lease] Could not publish lease of", vehicle, ":", token.Error())
+	}
+}
+
+// checkOwner renvoie une erreur si le véhicule est loué à un autre contrôleur que celui qui envoie la commande.
+func (e *Emergency) checkOwner(vehicle, controller string) error {
+	e.mu.Lock()
+	lease, ok := e.leases[vehicle]
+	e.mu.Unlock()
+
+	if !ok || !lease.Active() || lease.Owner == controller {
+		return nil
+	}
+	if controller == "" {
+		return fmt.Errorf("vehicle owned by %s, command sent without controller", lease.Owner)
+	}
+	return fmt.Errorf("vehicle owned by %s, not %s", lease.Owner, controller)
+}
+
+// leaseOwner renvoie le propriétaire actuel du véhicule ("" s'il n'est pas loué).
+// Doit être appelée avec e.mu verrouillé.
+func (e *Emergency) leaseOwner(vehicle string) string {
+	if lease, ok := e.leases[vehicle]; ok && lease.Active() {
+		return lease.Owner
+	}
+	return ""
+}
+
+// extractController lit et retire le champ controller d'un payload avant de le relayer au véhicule.
+func extractController(payload []byte) (string, []byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return "", nil, err
+	}
+	raw, ok := fields["controller"]
+	if !ok {
+		return "", payload, nil
+	}
+
+	var controller string
+	if err := json.Unmarshal(raw, &controller); err != nil {
+		return "", nil, err
+	}
+	delete(fields, "controller")
+	stripped, err := json.Marshal(fields)
+	if err != nil {
+		return "", nil, err
+	}
+	return controller, stripped, nil
+}
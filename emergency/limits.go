@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hyperdrive/remote/hyperdrive"
+	"hyperdrive/remote/topic"
+	"log"
+	"os"
+	"slices"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/goccy/go-yaml"
+)
+
+// mediationMode définit ce que le médiateur fait des messages RemoteControl.
+type mediationMode int
+
+const (
+	forwardMode mediationMode = iota // les messages sont relayés tels quels
+	limitMode                        // velocity et acceleration sont limitées avant d'être relayées
+)
+
+func (m mediationMode) String() string {
+	switch m {
+	case limitMode:
+		return "limit"
+	default:
+		return "forward"
+	}
+}
+
+func parseMediationMode(s string) (mediationMode, error) {
+	switch s {
+	case "forward":
+		return forwardMode, nil
+	case "limit":
+		return limitMode, nil
+	}
+	return forwardMode, fmt.Errorf("unknown mediation mode %q (expected forward or limit)", s)
+}
+
+// speedLimit est une limite de vitesse. Une valeur de 0 signifie "pas de limite".
+type speedLimit struct {
+	MaxVelocity     float32 `yaml:"maxVelocity"`
+	MaxAcceleration float32 `yaml:"maxAcceleration"`
+}
+
+// slowZone limite la vitesse des véhicules qui se trouvent sur une des pièces.
+type slowZone struct {
+	Name        string  `yaml:"name"`
+	Pieces      []int   `yaml:"pieces"`
+	MaxVelocity float32 `yaml:"maxVelocity"`
+}
+
+// limitConfig est le contenu du fichier passé avec -limits.
+type limitConfig struct {
+	Global    speedLimit            `yaml:"global"`
+	Vehicles  map[string]speedLimit `yaml:"vehicles"`
+	SlowZones []slowZone            `yaml:"slowZones"`
+}
+
+func loadLimits(path string) (limitConfig, error) {
+	var config limitConfig
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return config, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// speedCommand est la dernière commande de vitesse demandée par le RemoteControl, avant limitation.
+type speedCommand struct {
+	topic   string
+	payload hyperdrive.SpeedPayload
+}
+
+type trackEventPayload struct {
+	Value struct {
+		TrackID       int `json:"trackID"`
+		TrackLocation int `json:"trackLocation"`
+	} `json:"value"`
+}
+
+// minLimit renvoie la plus petite des limites non nulles.
+func minLimit(values ...float32) float32 {
+	var result float32
+	for _, v := range values {
+		if v > 0 && (result == 0 || v < result) {
+			result = v
+		}
+	}
+	return result
+}
+
+// clamp limite la valeur absolue de v à max (0 = pas de limite).
+func clamp(v, max float32) float32 {
+	if max <= 0 {
+		return v
+	}
+	if v > max {
+		return max
+	}
+	if v < -max {
+		return -max
+	}
+	return v
+}
+
+// effectiveLimit combine la limite globale, celle du véhicule et celle de la zone où il se trouve.
+// Doit être appelée avec e.mu verrouillé.
+func (e *Emergency) effectiveLimit(vehicleID string) speedLimit {
+	vehicle := e.limits.Vehicles[vehicleID]
+	limit := speedLimit{
+		MaxVelocity:     minLimit(e.limits.Global.MaxVelocity, vehicle.MaxVelocity),
+		MaxAcceleration: minLimit(e.limits.Global.MaxAcceleration, vehicle.MaxAcceleration),
+	}
+
+	if piece, ok := e.positions[vehicleID]; ok {
+		for _, zone := range e.limits.SlowZones {
+			if slices.Contains(zone.Pieces, piece) {
+				limit.MaxVelocity = minLimit(limit.MaxVelocity, zone.MaxVelocity)
+			}
+		}
+	}
+	return limit
+}
+
+// currentLimit combine les règles clamp, le ralentissement du service d'occupation et, en mode
+// limit, les limites du fichier -limits.
+// Doit être appelée avec e.mu verrouillé.
+func (e *Emergency) currentLimit(vehicleID, payloadType string) speedLimit {
+	limit := e.ruleLimit(vehicleID, payloadType)
+	limit.MaxVelocity = minLimit(limit.MaxVelocity, e.holds[vehicleID].MaxVelocity)
+	if e.mode == limitMode {
+		configured := e.effectiveLimit(vehicleID)
+		limit.MaxVelocity = minLimit(limit.MaxVelocity, configured.MaxVelocity)
+		limit.MaxAcceleration = minLimit(limit.MaxAcceleration, configured.MaxAcceleration)
+	}
+	return limit
+}
+
+// rewritePayload applique aux payloads speed et lane les règles clamp, les holds du service
+// d'occupation et, lorsque le mode limit est actif, les limites configurées. Les autres payloads
+// sont relayés sans changement.
+func (e *Emergency) rewritePayload(vehicleID, payloadType, topic string, payload []byte) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var (
+		rewritten any
+		err       error
+	)
+	switch payloadType {
+	case "speed":
+		var data hyperdrive.SpeedPayload
+		if err = json.Unmarshal(payload, &data); err != nil {
+			break
+		}
+		e.lastSpeed[vehicleID] = speedCommand{topic: topic, payload: data}
+		limit := e.currentLimit(vehicleID, payloadType)
+		held := e.holds[vehicleID].Hold
+		if limit == (speedLimit{}) && !held {
+			return payload
+		}
+		data.Velocity = clamp(data.Velocity, limit.MaxVelocity)
+		data.Acceleration = clamp(data.Acceleration, limit.MaxAcceleration)
+		if held {
+			data.Velocity = 0 // le véhicule attend que la pièce réservée soit libre
+		}
+		rewritten = data
+
+	case "lane":
+		limit := e.currentLimit(vehicleID, payloadType)
+		if limit == (speedLimit{}) {
+			return payload
+		}
+		var data hyperdrive.LanePayload
+		if err = json.Unmarshal(payload, &data); err != nil {
+			break
+		}
+		data.Velocity = clamp(data.Velocity, limit.MaxVelocity)
+		data.Acceleration = clamp(data.Acceleration, limit.MaxAcceleration)
+		rewritten = data
+
+	default:
+		return payload
+	}
+
+	if err != nil {
+		log.Println("[Limit] Could not read", payloadType, "payload, forwarding it unchanged:", err)
+		return payload
+	}
+
+	data, err := json.Marshal(rewritten)
+	if err != nil {
+		log.Println("[Limit] Could not rewrite", payloadType, "payload, forwarding it unchanged:", err)
+		return payload
+	}
+	if string(data) != string(payload) {
+		log.Printf("[Limit] Rewrote %s for %s: %s -> %s", payloadType, vehicleID, payload, data)
+	}
+	return data
+}
+
+// setMediationMode change le mode de médiation et réapplique les limites à la dernière vitesse demandée.
+func (e *Emergency) setMediationMode(mode mediationMode) {
+	e.mu.Lock()
+	e.mode = mode
+	vehicles := make([]string, 0, len(e.lastSpeed))
+	for id := range e.lastSpeed {
+		vehicles = append(vehicles, id)
+	}
+	e.mu.Unlock()
+
+	log.Println("[Limit] Mediation mode is now", mode)
+	for _, id := range vehicles {
+		e.reapplySpeed(id)
+	}
+}
+
+// setGlobalMaxVelocity change la limite globale de vitesse.
+func (e *Emergency) setGlobalMaxVelocity(velocity float32) {
+	e.mu.Lock()
+	e.limits.Global.MaxVelocity = velocity
+	vehicles := make([]string, 0, len(e.lastSpeed))
+	for id := range e.lastSpeed {
+		vehicles = append(vehicles, id)
+	}
+	e.mu.Unlock()
+
+	for _, id := range vehicles {
+		e.reapplySpeed(id)
+	}
+}
+
+// reapplySpeed republie la dernière vitesse demandée avec les limites actuelles, p.ex. en entrant
+// ou en sortant d'une zone lente.
+func (e *Emergency) reapplySpeed(vehicleID string) {
+	if e.isVehicleStopped(vehicleID) {
+		return
+	}
+
+	e.mu.Lock()
+	command, ok := e.lastSpeed[vehicleID]
+	e.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	data, err := json.Marshal(command.payload)
+	if err != nil {
+		return
+	}
+	data = e.rewritePayload(vehicleID, "speed", command.topic, data)
+	if token := e.client.Publish(command.topic, e.qos, false, data); token.Wait() && token.Error() != nil {
+		log.Println("[Limit] Could not republish speed for", vehicleID, ":", token.Error())
+		return
+	}
+	e.setDriving(vehicleID, "speed", data)
+}
+
+// trackVehicle suit la position d'un véhicule pour appliquer les zones lentes.
+func (e *Emergency) trackVehicle(vehicleID string) {
+	e.mu.Lock()
+	trackTopic := e.config.VehicleTrackTopic.Format(topic.Values{"vehicle": vehicleID})
+	e.mu.Unlock()
+	if token := e.client.Subscribe(trackTopic, e.qos, func(client mqtt.Client, msg mqtt.Message) {
+		var data []trackEventPayload
+		if err := json.Unmarshal(msg.Payload(), &data); err != nil || len(data) == 0 {
+			return
+		}
+
+		e.mu.Lock()
+		_, known := e.vehicleList[vehicleID]
+		e.mu.Unlock()
+		if !known {
+			return // déconnecté par removeVehicle, l'événement était déjà en route
+		}
+		e.recordTrackEvent(vehicleID)
+
+		e.mu.Lock()
+		before := e.currentLimit(vehicleID, "speed")
+		e.positions[vehicleID] = data[0].Value.TrackID
+		after := e.currentLimit(vehicleID, "speed")
+		e.mu.Unlock()
+
+		if before != after {
+			log.Println("[Limit] Vehicle", vehicleID, "changed zone, max velocity is now", after.MaxVelocity)
+			e.reapplySpeed(vehicleID)
+		}
+		e.checkOccupancy(vehicleID)
+	}); token.Wait() && token.Error() != nil {
+		log.Println("[Limit] Could not subscribe to", trackTopic, ":", token.Error())
+	}
+}
@@ -3,18 +3,16 @@ package main
 import (
 	"encoding/json"
 	"flag"
-	"fmt"
 	"hyperdrive/remote/hyperdrive"
+	"hyperdrive/remote/topic"
 	"log"
+	"os"
+	"os/signal"
 	"slices"
+	"sync"
+	"syscall"
 	"time"
 
-	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/app"
-	"fyne.io/fyne/v2/container"
-	"fyne.io/fyne/v2/data/binding"
-	"fyne.io/fyne/v2/layout"
-	"fyne.io/fyne/v2/widget"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/google/uuid"
 )
@@ -26,11 +24,39 @@ const (
 
 // Configuration des variables pour le broker MQTT, l'ID client et le QoS.
 var (
-	brokerHost                = flag.String("broker", "10.42.0.1:1883", "MQTT broker URL")
-	clientIDFlag              = flag.String("id", "kevin-leo-emergency-control", "Client ID for Emergency (default: random UUID)")
-	qosFlag                   = flag.Int("qos", 1, "MQTT QoS")
-	vehicleSubscriptionFormat string
-	remoteInstructionsFormat  string
+	brokerHost                   = flag.String("broker", "10.42.0.1:1883", "MQTT broker URL")
+	clientIDFlag                 = flag.String("id", "kevin-leo-emergency-control", "Client ID for Emergency (default: random UUID)")
+	qosFlag                      = flag.Int("qos", 1, "MQTT QoS")
+	operatorFlag                 = flag.String("operator", os.Getenv("USER"), "Name of the operator, published with the emergency status")
+	heartbeatTimeoutFlag         = flag.Duration("heartbeat-timeout", hyperdrive.HeartbeatAliveTime, "Time after which a silent controller gets its vehicles stopped")
+	modeFlag                     = flag.String("mode", "forward", "Mediation mode: forward or limit")
+	rulesFlag                    = flag.String("rules", "", "YAML file with the safety rules evaluated on every mediated message and vehicle event")
+	limitsFlag                   = flag.String("limits", "", "YAML file with global and per-vehicle speed limits and slow zones")
+	maxVelocityFlag              = flag.Float64("max-velocity", 0, "Global max velocity in limit mode, overrides the limits file (0: no limit)")
+	maxAccelerationFlag          = flag.Float64("max-acceleration", 0, "Global max acceleration in limit mode, overrides the limits file (0: no limit)")
+	auditFlag                    = flag.String("audit", "emergency-audit.jsonl", "Append-only audit log of the mediated messages (empty: no audit)")
+	auditQueryFlag               = flag.Bool("audit-query", false, "Print the audit log entries matching the -audit-* filters and exit")
+	auditVehicleFlag             = flag.String("audit-vehicle", "", "Only show audit entries for this vehicle")
+	auditActionFlag              = flag.String("audit-action", "", "Only show audit entries with this action (forwarded, rejected, dropped)")
+	auditTypeFlag                = flag.String("audit-type", "", "Only show audit entries with this payload type")
+	auditSinceFlag               = flag.Duration("audit-since", 0, "Only show audit entries younger than this (0: all)")
+	resumeFlag                   = flag.String("resume", "stay", "What Continue does: stay (cars stay stopped), reduced (resume at -resume-velocity) or restore (last lane and speed, with -resume-ramp)")
+	resumeVelocityFlag           = flag.Float64("resume-velocity", 300, "Velocity used by the reduced resume mode")
+	resumeRampFlag               = flag.Duration("resume-ramp", 2*time.Second, "Duration of the speed ramp of the restore resume mode (0: no ramp)")
+	stopLightsFlag               = flag.Bool("stop-lights", false, "Switch the lights of stopped cars to a red flashing preset")
+	stopDecelerationFlag         = flag.Float64("stop-deceleration", 600, "Acceleration of the stop message, for a controlled deceleration")
+	stopSettleFlag               = flag.Duration("stop-settle", 1500*time.Millisecond, "Time given to the cars to stop before checking they did")
+	stopWindowFlag               = flag.Duration("stop-window", time.Second, "A stopped car must not send track events during this time, otherwise the stop escalates")
+	bufferExpiryFlag             = flag.Duration("buffer-expiry", 5*time.Second, "Messages waiting for a vehicle subscription are dropped after this time (0: never)")
+	headlessFlag                 = flag.Bool("headless", false, "Run without UI, mediating directly with the topics from the flags or -config")
+	configFlag                   = flag.String("config", "", "YAML file with the mediator topics and HTTP address")
+	vehicleIntentTopicFlag       = flag.String("vehicle-intent-topic", defaultMediatorConfig().VehicleIntentTopic.String(), "Topic template (where {vehicle} is the car id) of the car intents")
+	remoteInstructionsTopicFlag  = flag.String("remote-instructions-topic", defaultMediatorConfig().RemoteInstructionsTopic.String(), "Topic template of the RemoteControl instructions, with {vehicle} and the subscription {type}")
+	remoteRootFlag               = flag.String("remote-root", defaultMediatorConfig().RemoteRoot, "RemoteControl root topic")
+	vehicleTrackTopicFlag        = flag.String("vehicle-track-topic", defaultMediatorConfig().VehicleTrackTopic.String(), "Topic template (where {vehicle} is the car id) of the car track events")
+	vehicleSubscriptionTopicFlag = flag.String("vehicle-subscription-topic", defaultMediatorConfig().VehicleSubscriptionTopic.String(), "Topic template, with {vehicle} and the subscription {type}, where the cars confirm their subscriptions")
+	httpFlag                     = flag.String("http", defaultMediatorConfig().HTTPAddr, "Address of the HTTP/WebSocket stop, continue and status endpoints (empty: disabled)")
+	httpTokenFlag                = flag.String("http-token", os.Getenv("EMERGENCY_HTTP_TOKEN"), "Token required by the HTTP/WebSocket endpoints, needed to listen on other hosts than localhost")
 )
 
 // Definition de la structure Intent pour les messages publiés aux véhicules et hôtes.
@@ -50,40 +76,158 @@ type Emergency struct {
 	id          string              // Client ID
 	qos         byte                // QoS level
 	stop        bool                // Indique si le mode d'arrêt d'urgence est actif
-	vehicleList map[string][]string // Liste des topics abonnés (non utilisé dans cette version)
+	vehicleList map[string][]string // Types de messages médiés par véhicule
+	stopped     map[string]bool     // Véhicules arrêtés individuellement
+
+	mode      mediationMode                       // forward ou limit
+	limits    limitConfig                         // Limites appliquées en mode limit
+	rules     ruleSet                             // Règles de sécurité évaluées sur chaque message et événement
+	lastSpeed map[string]speedCommand             // Dernière vitesse demandée par véhicule, avant limitation
+	positions map[string]int                      // Dernière pièce de piste vue par véhicule
+	holds     map[string]hyperdrive.OccupancyHold // Véhicules retenus ou ralentis par le service d'occupation
+
+	operator string                     // Opérateur publié avec l'état
+	status   hyperdrive.EmergencyStatus // Dernier état publié (retained)
+	audit    *auditLog                  // Journal des messages relayés, rejetés ou ignorés
+	config   mediatorConfig             // Topics utilisés par le médiateur
+
+	lastCommands map[string]map[string]lastCommand // Dernière commande relayée par véhicule et par type
+	resume       resumeConfig                      // Reprise sur Continue et feux d'arrêt
+
+	stopCfg        stopConfig                     // Arrêt progressif et vérification
+	lastTrackEvent map[string]time.Time           // Dernier événement de piste par véhicule
+	driving        map[string]time.Time           // Depuis quand chaque véhicule doit rouler, pour la règle delocalized
+	stopChecks     map[string]string              // Résultat de la vérification d'arrêt par véhicule
+	queues         map[string]chan pendingMessage // Messages en attente d'abonnement, par véhicule
+	bufferExpiry   time.Duration                  // Âge après lequel un message en attente est ignoré
+
+	mu          sync.Mutex                  // protège tout l'état ci-dessus et controllers
+	controllers map[string]*controllerState // Contrôleurs surveillés par le dead-man switch
+	leases      map[string]hyperdrive.Lease // Bail accordé par véhicule
+	listeners   []func()                    // Appelés quand l'état, les véhicules ou les contrôleurs changent
 }
 
 // NewEmergency crée une nouvelle instance d'Emergency.
-func NewEmergency(client mqtt.Client, id string, qos byte) *Emergency {
+func NewEmergency(client mqtt.Client, id string, qos byte, operator string) *Emergency {
 	return &Emergency{ // Initialisation de la structure Emergency
-		client: client, // Assignation du client MQTT
-		id:     id,     // Assignation de l'ID client
-		qos:    qos,    // Assignation du niveau de QoS
-		stop:   false,
+		client:   client, // Assignation du client MQTT
+		id:       id,     // Assignation de l'ID client
+		qos:      qos,    // Assignation du niveau de QoS
+		stop:     false,
+		operator: operator, // Assignation de l'opérateur
+
+		vehicleList: map[string][]string{},
+		stopped:     map[string]bool{},
+		lastSpeed:   map[string]speedCommand{},
+		positions:   map[string]int{},
+		holds:       map[string]hyperdrive.OccupancyHold{},
+		controllers: map[string]*controllerState{},
+		leases:      map[string]hyperdrive.Lease{},
+		queues:      map[string]chan pendingMessage{},
+
+		lastCommands: map[string]map[string]lastCommand{},
+
+		lastTrackEvent: map[string]time.Time{},
+		driving:        map[string]time.Time{},
+		stopChecks:     map[string]string{},
+	}
+}
+
+// addListener enregistre une fonction appelée à chaque changement d'état (UI, WebSocket).
+func (e *Emergency) addListener(listener func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, listener)
+}
+
+// notify prévient tous les listeners d'un changement.
+func (e *Emergency) notify() {
+	e.mu.Lock()
+	listeners := slices.Clone(e.listeners)
+	e.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener()
+	}
+}
+
+// setStop active ou désactive l'arrêt d'urgence général et publie le nouvel état.
+func (e *Emergency) setStop(stop bool, reason string) {
+	e.mu.Lock()
+	mode := e.resume.mode
+	e.mu.Unlock()
+	e.applyStop(stop, reason, mode)
+}
+
+// applyStop est setStop avec un mode de reprise choisi pour ce Continue.
+func (e *Emergency) applyStop(stop bool, reason string, mode resumeMode) {
+	e.mu.Lock()
+	wasStopped := e.stop
+	e.stop = stop
+	// Véhicules concernés: ceux qui ne sont pas aussi arrêtés individuellement.
+	var vehicles []string
+	for id := range e.vehicleList {
+		if !e.stopped[id] {
+			vehicles = append(vehicles, id)
+		}
+	}
+	e.mu.Unlock()
+	e.publishStopMessage()
+	e.publishStatus(reason)
+
+	switch {
+	case stop && !wasStopped:
+		e.clearDriving(vehicles)
+		e.showStopLights(vehicles)
+		go e.verifyStop(vehicles)
+	case !stop && wasStopped:
+		for _, id := range vehicles {
+			e.setStopCheck(id, "")
+		}
+		e.clearDriving(vehicles)
+		e.resumeVehicles(vehicles, mode)
 	}
 }
 
 // handleStopMessage : gestionnaire de messages pour Emergency/U/E/stop
-func (e *Emergency) publishStopMessage(client mqtt.Client) {
-	// Si le mode d'arrêt est activé, publier immédiatement un intent speed=0 à tous les véhicules
-	if !e.stop {
+func (e *Emergency) publishStopMessage() {
+	e.mu.Lock()
+	stop := e.stop
+	e.mu.Unlock()
+
+	// Les véhicules sont abonnés au topic d'arrêt: il n'est pas retenu, sinon l'effacer leur enverrait
+	// un message vide. L'état retenu est celui du topic de status, et provisionVehicle renvoie
+	// l'arrêt aux véhicules qui arrivent pendant un arrêt.
+	if !stop {
 		return
 	}
 
-	intent := hyperdrive.SpeedPayload{
-		Velocity:     0,
-		Acceleration: 1000,
-	}
+	// Si le mode d'arrêt est activé, publier immédiatement un intent speed=0 à tous les véhicules
 	log.Println("Emergency: publishing immediate speed=0 to all vehicles")
+	if err := e.sendStop(stopTopic); err != nil {
+		log.Println("[Emergency] Got error while sending stop:", err)
+	}
+}
+
+// sendStop publie l'intent speed=0 sur le topic donné.
+func (e *Emergency) sendStop(topic string) error {
+	e.mu.Lock()
+	deceleration := e.stopCfg.deceleration
+	e.mu.Unlock()
 
-	data, err := json.Marshal(intent)
+	// Première étape de l'arrêt: une décélération contrôlée, vérifiée ensuite par verifyStop.
+	data, err := json.Marshal(hyperdrive.SpeedPayload{
+		Velocity:     0,
+		Acceleration: deceleration,
+	})
 	if err != nil {
-		return
+		return err
 	}
 
-	if token := client.Publish(stopTopic, 1, false, data); token.Wait() && token.Error() != nil {
-		log.Println("[Emergency] Got error while sending stop:", token.Error())
+	if token := e.client.Publish(topic, 1, false, data); token.Wait() && token.Error() != nil {
+		return token.Error()
 	}
+	return nil
 }
 
 // mapRemoteTopicToMediate crée le topic mediate pour un topic RemoteControl donné
@@ -94,9 +238,30 @@ func mapRemoteTopicToMediate(remoteTopic string) string {
 // Fonction principale qui permet de configurer le client MQTT, de s'abonner aux topics nécessaires et de gérer la boucle principale.
 func main() {
 	flag.Parse()
+
+	if *auditQueryFlag {
+		filter := auditFilter{
+			Vehicle: *auditVehicleFlag,
+			Action:  *auditActionFlag,
+			Type:    *auditTypeFlag,
+		}
+		if *auditSinceFlag > 0 {
+			filter.Since = time.Now().Add(-*auditSinceFlag)
+		}
+		if err := queryAudit(*auditFlag, filter, os.Stdout); err != nil {
+			log.Fatal("Could not query the audit log: ", err)
+		}
+		return
+	}
+
 	log.Println("Got", *brokerHost, "as the broker url")
 	log.Println("Got", *clientIDFlag, "as id")
 	log.Println("Got", *qosFlag, "as quality of service")
+	log.Println("Got", *heartbeatTimeoutFlag, "as heartbeat timeout")
+	// Un délai plus court que l'intervalle des heartbeats arrêterait les véhicules de tous les contrôleurs.
+	if *heartbeatTimeoutFlag <= hyperdrive.HeartbeatInterval {
+		log.Fatalf("-heartbeat-timeout must be longer than the heartbeat interval (%v), got %v", hyperdrive.HeartbeatInterval, *heartbeatTimeoutFlag)
+	}
 
 	id := *clientIDFlag
 	if id == "" {
@@ -121,137 +286,119 @@ func main() {
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
 		log.Fatalf("Could not connect to broker: %v", token.Error())
 	}
-	em := NewEmergency(client, id, qos)
-
-	// Create app
-	isStopped := binding.NewBool()
-
-	w := app.New().NewWindow("Emergency")
-	w.Resize(fyne.NewSize(350, 180))
-	isStopped.Set(false)
-
-	vehicleIntentTopicFormatEntry := widget.NewEntry()
-	vehicleIntentTopicFormatEntry.SetText("Anki/Vehicles/U/%s/I")
-	remoteVehicleInstructionsTopicEntry := widget.NewEntry()
-	remoteVehicleInstructionsTopicEntry.SetText("RemoteControl/U/E/vehicles/%8s/%s")
-	remoteRootTopicEntry := widget.NewEntry()
-	remoteRootTopicEntry.SetText("RemoteControl/#")
-
-	form := &widget.Form{
-		Items: []*widget.FormItem{
-			{
-				Text:   "Topic format (where %s is the car id) of the car intents:",
-				Widget: vehicleIntentTopicFormatEntry,
-			},
-			{
-				Text:   "First %s for the vehicle, second for the subscription type",
-				Widget: remoteVehicleInstructionsTopicEntry,
-			},
-			{
-				Text:   "RemoteControl root topic",
-				Widget: remoteRootTopicEntry,
-			},
-		},
-		OnSubmit: func() {
-			vehicleSubscriptionFormat = vehicleIntentTopicFormatEntry.Text
-			remoteInstructionsFormat = remoteVehicleInstructionsTopicEntry.Text
-
-			// Souscrire aux événements des véhicules RemoteControl
-			if tok := client.Subscribe(remoteRootTopicEntry.Text, 1, func(client mqtt.Client, msg mqtt.Message) {
-				log.Println("Got message from", msg.Topic(), "mirroring to", mapRemoteTopicToMediate(msg.Topic()))
-				if em.stop == true {
-					log.Printf("Emergency: STOP active, ignoring remote message on %s", msg.Topic())
-					return
-				}
-
-				var vehicleID string
-				var payloadType string
-				n, err := fmt.Sscanf(msg.Topic(), remoteInstructionsFormat, &vehicleID, &payloadType)
-				log.Println("Got vehicle", vehicleID, "for the payload type", payloadType)
-				if n == 2 && err != nil {
-					log.Fatalf("The format provided: %s is not correct: %v", remoteInstructionsFormat, err)
-				}
-
-				mediateTopic := mapRemoteTopicToMediate(msg.Topic())
-
-				// Initialize the map if not already.
-				if em.vehicleList == nil {
-					em.vehicleList = map[string][]string{}
-				}
-
-				subscriptionType, exists := em.vehicleList[vehicleID]
-
-				// If the car does not exist, or if the passed type is not in the list
-				if !exists || !slices.Contains(subscriptionType, payloadType) {
-					// If the car does not exist, add it to the list with the payload type.
-					if !exists {
-						em.vehicleList[vehicleID] = []string{payloadType}
-
-						// Give it the stop topic directly upon creation
-						err = hyperdrive.SyncSubscription(client, "speedSubscription", fmt.Sprintf(vehicleSubscriptionFormat, vehicleID), stopTopic, true)
-						if err != nil {
-							log.Println("Failed to subscribe the vehicle to the emergency stop:", err)
-						}
-						log.Println("Successfully sent Stop subscription", stopTopic, "to", fmt.Sprintf(vehicleSubscriptionFormat, vehicleID))
-						time.Sleep(1000 * time.Millisecond) // enusure subscription gets registerd before sending the next
-					}
-
-					// if the car exists, but the payload type is still unknown, then add the payload type.
-					if !slices.Contains(subscriptionType, payloadType) {
-						em.vehicleList[vehicleID] = append(em.vehicleList[vehicleID], payloadType)
-					}
-
-					// Subscribe to the suscription type that was sent
-					err := hyperdrive.SyncSubscription(client, payloadType+"Subscription", fmt.Sprintf(vehicleSubscriptionFormat, vehicleID), mediateTopic, true)
-					if err != nil {
-						log.Println("Failed to subscribe the vehicle the emergency remote:", err)
-					}
-					log.Println("Successfully sent subscription of", mediateTopic, "to", fmt.Sprintf(vehicleSubscriptionFormat, vehicleID))
-					time.Sleep(1000 * time.Millisecond) // enusure subscription gets registerd before sending the next
-				}
-
-				if token := client.Publish(mediateTopic, 1, false, msg.Payload()); token.Error() != nil {
-					log.Fatal("Something terrible happened while mirroring remote: failed to publish:", token.Error())
-				}
-
-				log.Printf("Emergency: forwarded %s -> %s", msg.Topic(), mediateTopic)
-
-			}); tok.Wait() && tok.Error() != nil {
-				log.Fatalf("Subscribe to remote vehicles failed: %v", tok.Error())
-			}
+	em := NewEmergency(client, id, qos, *operatorFlag)
+	em.restoreStatus(time.Second)
 
-			statusLabel := widget.NewLabelWithData(binding.BoolToString(isStopped))
-
-			stopButton := widget.NewButton("Stop", func() {
-				// Log the action to the console (optional)
-				println("Stop requested: setting state to true")
-				em.stop = true
-				em.publishStopMessage(client)
-				isStopped.Set(true)
-			})
-
-			continueButton := widget.NewButton("Continue", func() {
-				// Log the action to the console (optional)
-				println("Continue requested: setting state to false")
-				em.stop = false
-				em.publishStopMessage(client)
-				isStopped.Set(false)
-			})
-
-			buttonContainer := container.NewGridWithColumns(2, stopButton, continueButton)
-
-			content := container.NewVBox(
-				statusLabel,
-				layout.NewSpacer(), // Pushes the label up a bit
-				buttonContainer,
-			)
-
-			// replace the form by the cars
-			w.SetContent(content)
-		},
+	mode, err := parseMediationMode(*modeFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	em.mode = mode
+	em.bufferExpiry = *bufferExpiryFlag
+	em.stopCfg = stopConfig{
+		deceleration: float32(*stopDecelerationFlag),
+		settle:       *stopSettleFlag,
+		window:       *stopWindowFlag,
+	}
+	resume, err := parseResumeMode(*resumeFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	em.resume = resumeConfig{
+		mode:       resume,
+		velocity:   float32(*resumeVelocityFlag),
+		ramp:       *resumeRampFlag,
+		stopLights: *stopLightsFlag,
+	}
+	if *limitsFlag != "" {
+		em.limits, err = loadLimits(*limitsFlag)
+		if err != nil {
+			log.Fatal("Could not load the limits: ", err)
+		}
+	}
+	if *rulesFlag != "" {
+		em.rules, err = loadRules(*rulesFlag)
+		if err != nil {
+			log.Fatal("Could not load the safety rules: ", err)
+		}
+		log.Println("Loaded", len(em.rules.Rules), "safety rules from", *rulesFlag)
+	}
+	go em.watchDelocalized()
+	if *maxVelocityFlag > 0 {
+		em.limits.Global.MaxVelocity = float32(*maxVelocityFlag)
+	}
+	if *maxAccelerationFlag > 0 {
+		em.limits.Global.MaxAcceleration = float32(*maxAccelerationFlag)
+	}
+	log.Printf("Mediation mode %s with limits %+v", em.mode, em.limits)
+	if *auditFlag != "" {
+		em.audit, err = openAuditLog(*auditFlag)
+		if err != nil {
+			log.Fatal("Could not open the audit log: ", err)
+		}
+		defer em.audit.Close()
 	}
 
-	w.SetContent(form)
+	if err := em.watchControllers(*heartbeatTimeoutFlag); err != nil {
+		log.Fatalf("Subscribe to controller heartbeats failed: %v", err)
+	}
+	if err := em.watchClaims(); err != nil {
+		log.Fatalf("Subscribe to vehicle claims failed: %v", err)
+	}
+	if err := em.watchOccupancy(); err != nil {
+		log.Fatalf("Subscribe to occupancy holds failed: %v", err)
+	}
+
+	config := defaultMediatorConfig()
+	if *configFlag != "" {
+		config, err = loadMediatorConfig(*configFlag)
+		if err != nil {
+			log.Fatal("Could not load the configuration: ", err)
+		}
+	}
+	// Les flags passés explicitement ont priorité sur le fichier de configuration.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "vehicle-intent-topic":
+			config.VehicleIntentTopic = topic.Template(*vehicleIntentTopicFlag)
+		case "remote-instructions-topic":
+			config.RemoteInstructionsTopic = topic.Template(*remoteInstructionsTopicFlag)
+		case "remote-root":
+			config.RemoteRoot = *remoteRootFlag
+		case "vehicle-track-topic":
+			config.VehicleTrackTopic = topic.Template(*vehicleTrackTopicFlag)
+		case "vehicle-subscription-topic":
+			config.VehicleSubscriptionTopic = topic.Template(*vehicleSubscriptionTopicFlag)
+		case "http":
+			config.HTTPAddr = *httpFlag
+		}
+	})
+	if *httpTokenFlag != "" {
+		config.HTTPToken = *httpTokenFlag
+	}
+
+	if !*headlessFlag {
+		runUI(em, config)
+		return
+	}
+
+	// Mode headless: pas de formulaire, la médiation démarre directement.
+	log.Printf("Running headless with %+v", config.redacted())
+	if err := em.startMediation(config); err != nil {
+		log.Fatalf("Subscribe to remote vehicles failed: %v", err)
+	}
+	if config.HTTPAddr == "" {
+		log.Println("No HTTP address given, the stop can only be triggered over MQTT")
+	} else {
+		go func() {
+			if err := em.serveHTTP(config.HTTPAddr, config.HTTPToken); err != nil {
+				log.Fatal("HTTP server stopped: ", err)
+			}
+		}()
+	}
 
-	w.ShowAndRun()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+	log.Println("Emergency: shutting down")
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"hyperdrive/remote/hyperdrive"
+	"hyperdrive/remote/topic"
+	"log"
+	"os"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/goccy/go-yaml"
+)
+
+// mediatorConfig contient les topics utilisés par le médiateur. Elle vient soit du formulaire
+// de l'UI, soit des flags et du fichier -config en mode headless.
+type mediatorConfig struct {
+	VehicleIntentTopic       topic.Template `yaml:"vehicleIntentTopic"`       // {vehicle} est l'ID du véhicule
+	RemoteInstructionsTopic  topic.Template `yaml:"remoteInstructionsTopic"`  // {vehicle} et {type} du message
+	RemoteRoot               string         `yaml:"remoteRoot"`               // filtre des messages RemoteControl à médier
+	VehicleTrackTopic        topic.Template `yaml:"vehicleTrackTopic"`        // {vehicle} est l'ID du véhicule
+	VehicleSubscriptionTopic topic.Template `yaml:"vehicleSubscriptionTopic"` // {vehicle} et {type} d'abonnement confirmé par le véhicule
+	HTTPAddr                 string         `yaml:"http"`                     // adresse des endpoints stop/continue/status (vide: désactivés)
+	HTTPToken                string         `yaml:"httpToken"`                // jeton exigé par les endpoints (vide: localhost seulement)
+}
+
+func defaultMediatorConfig() mediatorConfig {
+	return mediatorConfig{
+		VehicleIntentTopic:       hyperdrive.VehicleIntentTopic,
+		RemoteInstructionsTopic:  "RemoteControl/U/E/vehicles/{vehicle}/{type}",
+		RemoteRoot:               "RemoteControl/#",
+		VehicleTrackTopic:        hyperdrive.VehicleTrackTopic,
+		VehicleSubscriptionTopic: hyperdrive.VehicleSubscriptionTopic,
+		HTTPAddr:                 hyperdrive.DefaultHTTPHost + ":8080",
+	}
+}
+
+// redacted retourne une copie de la config sans le jeton HTTP, pour les logs.
+func (c mediatorConfig) redacted() mediatorConfig {
+	if c.HTTPToken != "" {
+		c.HTTPToken = "<masqué>"
+	}
+	return c
+}
+
+// validate vérifie que les topics contiennent les placeholders dont le médiateur a besoin.
+func (c mediatorConfig) validate() error {
+	for _, err := range []error{
+		c.VehicleIntentTopic.Validate("vehicle"),
+		c.RemoteInstructionsTopic.Validate("vehicle", "type"),
+		c.VehicleTrackTopic.Validate("vehicle"),
+		c.VehicleSubscriptionTopic.Validate("vehicle", "type"),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadMediatorConfig lit un fichier YAML par-dessus la configuration par défaut.
+func loadMediatorConfig(path string) (mediatorConfig, error) {
+	config := defaultMediatorConfig()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return config, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// startMediation s'abonne aux messages RemoteControl et commence à les relayer vers les véhicules.
+func (e *Emergency) startMediation(config mediatorConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.config = config
+	e.mu.Unlock()
+
+	// Souscrire aux événements des véhicules RemoteControl
+	if token := e.client.Subscribe(config.RemoteRoot, 1, e.mediate); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	log.Println("Emergency: mediating", config.RemoteRoot)
+	return nil
+}
+
+// mediate relaie un message RemoteControl vers le topic mediate correspondant, après validation.
+func (e *Emergency) mediate(client mqtt.Client, msg mqtt.Message) {
+	log.Println("Got message from", msg.Topic(), "mirroring to", mapRemoteTopicToMediate(msg.Topic()))
+	e.mu.Lock()
+	config := e.config
+	e.mu.Unlock()
+
+	values, ok := config.RemoteInstructionsTopic.Match(msg.Topic())
+	vehicleID, payloadType := values["vehicle"], values["type"]
+	log.Println("Got vehicle", vehicleID, "for the payload type", payloadType)
+	entry := auditEntry{Topic: msg.Topic(), Vehicle: vehicleID, Type: payloadType, Payload: string(msg.Payload())}
+	if !ok {
+		log.Printf("Emergency: %s does not match %s, rejecting it", msg.Topic(), config.RemoteInstructionsTopic)
+		entry.Action, entry.Reason = auditRejected, fmt.Sprintf("topic does not match %s", config.RemoteInstructionsTopic)
+		e.audit.record(entry)
+		return
+	}
+
+	// Le champ controller identifie l'expéditeur, il n'est pas relayé au véhicule.
+	controller, payload, err := extractController(msg.Payload())
+	if err != nil {
+		log.Printf("Emergency: rejecting malformed %s payload on %s: %v", payloadType, msg.Topic(), err)
+		entry.Action, entry.Reason = auditRejected, "malformed payload: "+err.Error()
+		e.audit.record(entry)
+		return
+	}
+
+	if err := validatePayload(payloadType, payload); err != nil {
+		log.Printf("Emergency: rejecting invalid %s payload on %s: %v", payloadType, msg.Topic(), err)
+		entry.Action, entry.Reason = auditRejected, err.Error()
+		e.audit.record(entry)
+		return
+	}
+
+	mediateTopic := mapRemoteTopicToMediate(msg.Topic())
+
+	if e.isVehicleStopped(vehicleID) {
+		log.Printf("Emergency: vehicle %s is stopped, ignoring remote message on %s", vehicleID, msg.Topic())
+		entry.Action, entry.Reason = auditDropped, "vehicle stopped"
+		e.audit.record(entry)
+		return
+	}
+
+	if err := e.checkOwner(vehicleID, controller); err != nil {
+		log.Printf("Emergency: rejecting %s on %s: %v", payloadType, msg.Topic(), err)
+		entry.Action, entry.Reason = auditRejected, err.Error()
+		e.audit.record(entry)
+		return
+	}
+
+	// L'abonnement du véhicule peut prendre du temps, le worker du véhicule s'en charge
+	// pour ne pas bloquer le callback paho.
+	e.enqueue(vehicleID, pendingMessage{
+		payloadType: payloadType,
+		topic:       mediateTopic,
+		payload:     payload,
+		entry:       entry,
+		received:    time.Now(),
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"hyperdrive/remote/hyperdrive"
+	"log"
+)
+
+// watchOccupancy applique les holds du service d'occupation: un véhicule retenu est arrêté,
+// un véhicule ralenti voit sa vitesse limitée jusqu'à ce que la pièce réservée soit libre.
+func (e *Emergency) watchOccupancy() error {
+	return hyperdrive.WatchOccupancyHolds(e.client, "", func(hold hyperdrive.OccupancyHold) {
+		e.mu.Lock()
+		previous := e.holds[hold.Vehicle]
+		if hold.Active() {
+			e.holds[hold.Vehicle] = hold
+		} else {
+			delete(e.holds, hold.Vehicle)
+		}
+		e.mu.Unlock()
+
+		if previous == hold || (!previous.Active() && !hold.Active()) {
+			return
+		}
+		log.Println("[Occupancy]", hold.Vehicle, holdText(hold))
+		e.reapplySpeed(hold.Vehicle)
+		e.notify()
+	})
+}
+
+// holdText décrit un hold pour les logs et la liste des véhicules.
+func holdText(hold hyperdrive.OccupancyHold) string {
+	switch {
+	case hold.Hold:
+		return fmt.Sprintf("held by %s on piece %d", hold.Holder, hold.Piece)
+	case hold.MaxVelocity > 0:
+		return fmt.Sprintf("slowed to %.0f for piece %d, reserved by %s", hold.MaxVelocity, hold.Piece, hold.Holder)
+	default:
+		return "released"
+	}
+}
+
+// occupancyText renvoie la description d'un hold actif, ou "" si le véhicule n'est pas retenu.
+func occupancyText(hold hyperdrive.OccupancyHold) string {
+	if !hold.Active() {
+		return ""
+	}
+	return holdText(hold)
+}
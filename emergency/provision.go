@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"hyperdrive/remote/hyperdrive"
+	"hyperdrive/remote/topic"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	provisionTimeout = 2 * time.Second // attente maximale de la confirmation d'un abonnement par le véhicule
+	queueSize        = 64              // messages en attente par véhicule
+)
+
+// pendingMessage est un message validé qui attend que le véhicule soit abonné au topic mediate.
+type pendingMessage struct {
+	payloadType string
+	topic       string // topic mediate
+	payload     []byte // payload validé, sans le champ controller
+	entry       auditEntry
+	received    time.Time
+
+	origin       string // raison d'une commande du médiateur lui-même (vide: commande d'un contrôleur)
+	whileStopped bool   // relayée même si le véhicule est arrêté
+}
+
+// enqueue passe le message au worker du véhicule, qui le démarre au premier message.
+// Les messages d'un véhicule sont ainsi relayés dans l'ordre, même pendant un abonnement.
+func (e *Emergency) enqueue(vehicleID string, msg pendingMessage) {
+	e.mu.Lock()
+	queue, ok := e.queues[vehicleID]
+	if !ok {
+		queue = make(chan pendingMessage, queueSize)
+		e.queues[vehicleID] = queue
+		go e.vehicleWorker(vehicleID, queue)
+	}
+	// Envoyé sous le verrou: une fois retirée de e.queues par removeVehicle, la queue ne reçoit plus rien.
+	full := false
+	select {
+	case queue <- msg:
+	default:
+		full = true
+	}
+	e.mu.Unlock()
+
+	if full {
+		e.drop(vehicleID, msg, "provisioning queue full")
+	}
+}
+
+// drop ignore un message en attente et l'inscrit dans le journal.
+func (e *Emergency) drop(vehicleID string, msg pendingMessage, reason string) {
+	log.Printf("Emergency: dropping %s for %s: %s", msg.payloadType, vehicleID, reason)
+	msg.entry.Action, msg.entry.Reason = auditDropped, reason
+	e.audit.record(msg.entry)
+}
+
+// vehicleWorker abonne le véhicule aux topics nécessaires puis relaie ses messages, dans l'ordre.
+// Il s'arrête quand son contrôleur déconnecte le véhicule.
+func (e *Emergency) vehicleWorker(vehicleID string, queue chan pendingMessage) {
+	for msg := range queue {
+		if e.expired(msg) {
+			e.drop(vehicleID, msg, "expired before the subscription was confirmed")
+			continue
+		}
+
+		newVehicle, newType := e.registerSubscription(vehicleID, msg.payloadType)
+		if newVehicle {
+			e.provisionVehicle(vehicleID)
+		}
+		if newType {
+			e.provisionType(vehicleID, msg)
+			e.notify()
+		}
+
+		// Le véhicule a pu être arrêté ou le message expirer pendant l'abonnement.
+		if e.expired(msg) {
+			e.drop(vehicleID, msg, "expired before the subscription was confirmed")
+			continue
+		}
+		if !msg.whileStopped && e.isVehicleStopped(vehicleID) {
+			e.drop(vehicleID, msg, "vehicle stopped")
+			continue
+		}
+		if !e.applyMessageRules(vehicleID, msg) {
+			continue
+		}
+		e.forward(vehicleID, msg)
+
+		if msg.origin == "" && isDisconnect(msg) {
+			e.removeVehicle(vehicleID)
+			// Les messages arrivés entre-temps vont au worker suivant, qui abonne de nouveau le véhicule.
+			for {
+				select {
+				case msg := <-queue:
+					e.enqueue(vehicleID, msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// isDisconnect indique si le message déconnecte le véhicule.
+func isDisconnect(msg pendingMessage) bool {
+	if msg.payloadType != "connect" {
+		return false
+	}
+	var connect hyperdrive.ConnectPayload
+	return json.Unmarshal(msg.payload, &connect) == nil && !connect.Value
+}
+
+func (e *Emergency) expired(msg pendingMessage) bool {
+	return e.bufferExpiry > 0 && time.Since(msg.received) > e.bufferExpiry
+}
+
+func (e *Emergency) vehicleIntentTopic(vehicleID string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.config.VehicleIntentTopic.Format(topic.Values{"vehicle": vehicleID})
+}
+
+// subscribe abonne le véhicule à un topic et attend qu'il confirme l'abonnement sur son topic de
+// status. Sans confirmation après provisionTimeout, les messages sont quand même relayés.
+func (e *Emergency) subscribe(vehicleID, subscriptionType, subscribedTopic string) {
+	e.mu.Lock()
+	statusTopic := e.config.VehicleSubscriptionTopic.Format(topic.Values{"vehicle": vehicleID, "type": subscriptionType})
+	e.mu.Unlock()
+
+	vehicleIntentTopic := e.vehicleIntentTopic(vehicleID)
+	err := hyperdrive.ConfirmSubscription(e.client, subscriptionType, vehicleIntentTopic, statusTopic, subscribedTopic, true, provisionTimeout)
+	if err != nil {
+		log.Println("Vehicle", vehicleID, "did not confirm the subscription to", subscribedTopic, ":", err)
+		return
+	}
+	log.Println("Vehicle", vehicleID, "confirmed the subscription to", subscribedTopic)
+}
+
+// provisionVehicle abonne un nouveau véhicule aux topics d'arrêt et suit sa position.
+func (e *Emergency) provisionVehicle(vehicleID string) {
+	e.trackVehicle(vehicleID)
+
+	// If the car does not exist, give it the stop topics directly upon creation
+	e.subscribe(vehicleID, "speedSubscription", stopTopic)
+	ownStopTopic := vehicleStopTopic.Format(topic.Values{"vehicle": vehicleID})
+	e.subscribe(vehicleID, "speedSubscription", ownStopTopic)
+
+	// Le message d'arrêt n'est pas retenu: un véhicule qui arrive pendant un arrêt le reçoit ici.
+	if e.isVehicleStopped(vehicleID) {
+		if err := e.sendStop(ownStopTopic); err != nil {
+			log.Println("[Emergency] Got error while sending stop to", vehicleID, ":", err)
+		}
+	}
+}
+
+// provisionType abonne le véhicule au topic mediate d'un nouveau type de message.
+func (e *Emergency) provisionType(vehicleID string, msg pendingMessage) {
+	// Subscribe to the suscription type that was sent
+	e.subscribe(vehicleID, msg.payloadType+"Subscription", msg.topic)
+}
+
+// forward applique les limites et publie le message sur le topic mediate.
+func (e *Emergency) forward(vehicleID string, msg pendingMessage) {
+	payload := e.rewritePayload(vehicleID, msg.payloadType, msg.topic, msg.payload)
+	if token := e.client.Publish(msg.topic, 1, false, payload); token.Error() != nil {
+		log.Fatal("Something terrible happened while mirroring remote: failed to publish:", token.Error())
+	}
+
+	log.Printf("Emergency: forwarded %s -> %s", msg.entry.Topic, msg.topic)
+	e.setDriving(vehicleID, msg.payloadType, payload)
+	if msg.origin == "" {
+		e.recordCommand(vehicleID, msg.payloadType, msg.payload)
+	}
+
+	entry := msg.entry
+	entry.Action, entry.Payload, entry.Reason = auditForwarded, string(payload), msg.origin
+	if string(payload) != string(msg.payload) {
+		entry.Reason = strings.TrimPrefix(entry.Reason+", clamped from "+string(msg.payload), ", ")
+	}
+	e.audit.record(entry)
+}
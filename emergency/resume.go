@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hyperdrive/remote/hyperdrive"
+	"hyperdrive/remote/topic"
+	"log"
+	"math"
+	"time"
+)
+
+// resumeMode définit ce que le médiateur renvoie aux véhicules quand l'arrêt est levé.
+type resumeMode int
+
+const (
+	stayStopped  resumeMode = iota // les véhicules restent arrêtés jusqu'à la prochaine commande
+	reducedSpeed                   // les véhicules repartent à une vitesse réduite
+	restoreState                   // la dernière voie et la dernière vitesse sont rétablies, avec une rampe
+)
+
+func (m resumeMode) String() string {
+	switch m {
+	case reducedSpeed:
+		return "reduced"
+	case restoreState:
+		return "restore"
+	default:
+		return "stay"
+	}
+}
+
+func parseResumeMode(s string) (resumeMode, error) {
+	switch s {
+	case "stay":
+		return stayStopped, nil
+	case "reduced":
+		return reducedSpeed, nil
+	case "restore":
+		return restoreState, nil
+	}
+	return stayStopped, fmt.Errorf("unknown resume mode %q (expected stay, reduced or restore)", s)
+}
+
+const rampStep = 200 * time.Millisecond
+
+// resumeConfig regroupe les options appliquées sur Continue et Stop.
+type resumeConfig struct {
+	mode       resumeMode
+	velocity   float32       // vitesse en mode reduced
+	ramp       time.Duration // durée de la rampe en mode restore (0: vitesse rétablie directement)
+	stopLights bool          // feux rouges clignotants pendant l'arrêt
+}
+
+// lastCommand est la dernière commande relayée pour un véhicule et un type de message.
+type lastCommand struct {
+	payload []byte
+	at      time.Time
+}
+
+// stopLightsPreset fait clignoter les feux rouges d'un véhicule arrêté.
+var stopLightsPreset = hyperdrive.LightPayload{
+	FrontGreen:  hyperdrive.LightEffect{Effect: "off"},
+	FrontRed:    hyperdrive.LightEffect{Effect: "flash", Start: 0, End: 15, Frequency: 10},
+	Tail:        hyperdrive.LightEffect{Effect: "flash", Start: 0, End: 15, Frequency: 10},
+	EngineRed:   hyperdrive.LightEffect{Effect: "flash", Start: 0, End: 15, Frequency: 10},
+	EngineGreen: hyperdrive.LightEffect{Effect: "off"},
+	EngineBlue:  hyperdrive.LightEffect{Effect: "off"},
+}
+
+// clearLightsPreset éteint les feux du preset d'arrêt, pour les véhicules sans commande de feux connue.
+var clearLightsPreset = hyperdrive.LightPayload{
+	FrontRed:  hyperdrive.LightEffect{Effect: "off"},
+	Tail:      hyperdrive.LightEffect{Effect: "off"},
+	EngineRed: hyperdrive.LightEffect{Effect: "off"},
+}
+
+// mediateTopic renvoie le topic mediate d'un véhicule pour un type de message.
+func (e *Emergency) mediateTopic(vehicleID, payloadType string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return mapRemoteTopicToMediate(e.config.RemoteInstructionsTopic.Format(topic.Values{"vehicle": vehicleID, "type": payloadType}))
+}
+
+// recordCommand mémorise une commande relayée, pour pouvoir la rétablir sur Continue.
+func (e *Emergency) recordCommand(vehicleID, payloadType string, payload []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lastCommands[vehicleID] == nil {
+		e.lastCommands[vehicleID] = map[string]lastCommand{}
+	}
+	e.lastCommands[vehicleID][payloadType] = lastCommand{payload: payload, at: time.Now()}
+}
+
+// sendInternal passe une commande du médiateur (et non d'un contrôleur) au worker du véhicule.
+func (e *Emergency) sendInternal(vehicleID, payloadType string, payload any, origin string) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("[Resume] Could not marshal", payloadType, "for", vehicleID, ":", err)
+		return
+	}
+	mediateTopic := e.mediateTopic(vehicleID, payloadType)
+	e.enqueue(vehicleID, pendingMessage{
+		payloadType: payloadType,
+		topic:       mediateTopic,
+		payload:     data,
+		entry:       auditEntry{Topic: mediateTopic, Vehicle: vehicleID, Type: payloadType, Payload: string(data)},
+		received:    time.Now(),
+		origin:      origin,
+		// Les feux peuvent changer pendant l'arrêt, pas la vitesse ni la voie.
+		whileStopped: payloadType == "lights",
+	})
+}
+
+// showStopLights allume le preset d'arrêt sur les véhicules donnés, si l'option est active.
+func (e *Emergency) showStopLights(vehicles []string) {
+	e.mu.Lock()
+	enabled := e.resume.stopLights
+	e.mu.Unlock()
+	if !enabled {
+		return
+	}
+	for _, id := range vehicles {
+		e.sendInternal(id, "lights", stopLightsPreset, "emergency stop lights")
+	}
+}
+
+// resumeVehicles applique le mode de reprise aux véhicules dont l'arrêt vient d'être levé.
+func (e *Emergency) resumeVehicles(vehicles []string, mode resumeMode) {
+	e.mu.Lock()
+	config := e.resume
+	e.mu.Unlock()
+
+	for _, id := range vehicles {
+		e.mu.Lock()
+		speedCmd, hasSpeed := e.lastCommands[id]["speed"]
+		laneCmd, hasLane := e.lastCommands[id]["lane"]
+		lightsCmd, hasLights := e.lastCommands[id]["lights"]
+		e.mu.Unlock()
+
+		if config.stopLights {
+			if hasLights {
+				e.sendInternal(id, "lights", json.RawMessage(lightsCmd.payload), "restore lights")
+			} else {
+				e.sendInternal(id, "lights", clearLightsPreset, "clear stop lights")
+			}
+		}
+
+		if !hasSpeed {
+			continue
+		}
+		var speed hyperdrive.SpeedPayload
+		if err := json.Unmarshal(speedCmd.payload, &speed); err != nil || speed.Velocity == 0 {
+			continue
+		}
+
+		switch mode {
+		case reducedSpeed:
+			velocity := float32(math.Copysign(float64(min(config.velocity, float32(math.Abs(float64(speed.Velocity))))), float64(speed.Velocity)))
+			log.Println("[Resume] Resuming", id, "at reduced velocity", velocity)
+			e.sendInternal(id, "speed", hyperdrive.SpeedPayload{Velocity: velocity, Acceleration: speed.Acceleration}, "resume at reduced speed")
+		case restoreState:
+			if hasLane {
+				e.sendInternal(id, "lane", json.RawMessage(laneCmd.payload), "restore lane")
+			}
+			log.Println("[Resume] Restoring", id, "to velocity", speed.Velocity, "over", config.ramp)
+			go e.rampSpeed(id, speed, speedCmd.at, config.ramp)
+		}
+	}
+}
+
+// rampSpeed ramène progressivement le véhicule à la vitesse commandée. La rampe s'interrompt si
+// le véhicule est de nouveau arrêté ou si son contrôleur envoie une nouvelle vitesse.
+func (e *Emergency) rampSpeed(vehicleID string, target hyperdrive.SpeedPayload, commandedAt time.Time, ramp time.Duration) {
+	steps := max(1, int(ramp/rampStep))
+	for i := 1; i <= steps; i++ {
+		if i > 1 {
+			time.Sleep(rampStep)
+		}
+
+		e.mu.Lock()
+		superseded := !e.lastCommands[vehicleID]["speed"].at.Equal(commandedAt)
+		e.mu.Unlock()
+		if superseded || e.isVehicleStopped(vehicleID) {
+			log.Println("[Resume] Ramp of", vehicleID, "interrupted")
+			return
+		}
+
+		step := target
+		step.Velocity = target.Velocity * float32(i) / float32(steps)
+		e.sendInternal(vehicleID, "speed", step, fmt.Sprintf("restore speed, ramp step %d/%d", i, steps))
+	}
+}
+
+// setResumeMode change le mode de reprise par défaut (UI).
+func (e *Emergency) setResumeMode(mode resumeMode) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.resume.mode = mode
+}
+
+// setStopLights active ou désactive le preset de feux d'arrêt (UI).
+func (e *Emergency) setStopLights(enabled bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.resume.stopLights = enabled
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hyperdrive/remote/hyperdrive"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Actions possibles d'une règle de sécurité.
+const (
+	ruleClamp       = "clamp"       // limite velocity et acceleration du message
+	ruleDrop        = "drop"        // ignore le message
+	ruleStopVehicle = "stopVehicle" // arrête le véhicule concerné
+	ruleStopAll     = "stopAll"     // arrête toute la piste
+)
+
+// Événements des véhicules sur lesquels une règle peut porter.
+const (
+	eventDelocalized = "delocalized" // le véhicule roule mais n'envoie plus d'événements de piste
+	eventOccupancy   = "occupancy"   // trop de véhicules sur les pièces de la règle
+)
+
+// ruleCondition décrit quand une règle s'applique. Les champs vides ne restreignent rien.
+type ruleCondition struct {
+	Message     string        `yaml:"message"`     // type de payload médié (speed, lane, ...)
+	Event       string        `yaml:"event"`       // delocalized ou occupancy
+	Pieces      []int         `yaml:"pieces"`      // pièces où se trouve le véhicule
+	PieceType   string        `yaml:"pieceType"`   // type de pièce défini dans pieceTypes
+	For         time.Duration `yaml:"for"`         // durée pour delocalized
+	MaxVehicles int           `yaml:"maxVehicles"` // nombre de véhicules admis pour occupancy
+}
+
+type safetyRule struct {
+	Name            string        `yaml:"name"`
+	When            ruleCondition `yaml:"when"`
+	Action          string        `yaml:"action"`
+	MaxVelocity     float32       `yaml:"maxVelocity"`     // pour clamp
+	MaxAcceleration float32       `yaml:"maxAcceleration"` // pour clamp
+}
+
+// ruleSet est le contenu du fichier passé avec -rules.
+type ruleSet struct {
+	PieceTypes map[string][]int `yaml:"pieceTypes"`
+	Rules      []safetyRule     `yaml:"rules"`
+}
+
+func loadRules(path string) (ruleSet, error) {
+	var rules ruleSet
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return rules, err
+	}
+	if err := yaml.Unmarshal(b, &rules); err != nil {
+		return rules, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	if err := rules.validate(); err != nil {
+		return rules, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// validate refuse les règles qui ne pourraient jamais s'appliquer comme prévu.
+func (s ruleSet) validate() error {
+	for i, r := range s.Rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		if r.When.PieceType != "" && s.PieceTypes[r.When.PieceType] == nil {
+			return fmt.Errorf("rule %s: unknown piece type %q", name, r.When.PieceType)
+		}
+
+		switch r.When.Event {
+		case "":
+		case eventDelocalized:
+			if r.When.For <= 0 {
+				return fmt.Errorf("rule %s: %s needs a positive duration in for", name, eventDelocalized)
+			}
+		case eventOccupancy:
+			if len(r.When.Pieces) == 0 && r.When.PieceType == "" {
+				return fmt.Errorf("rule %s: %s needs pieces or a pieceType", name, eventOccupancy)
+			}
+		default:
+			return fmt.Errorf("rule %s: unknown event %q (expected %s or %s)", name, r.When.Event, eventDelocalized, eventOccupancy)
+		}
+		if r.When.Event != "" && r.When.Message != "" {
+			return fmt.Errorf("rule %s: a rule applies either to a message or to an event", name)
+		}
+
+		switch r.Action {
+		case ruleClamp:
+			if r.When.Event != "" {
+				return fmt.Errorf("rule %s: %s only applies to messages", name, ruleClamp)
+			}
+			if r.MaxVelocity <= 0 && r.MaxAcceleration <= 0 {
+				return fmt.Errorf("rule %s: %s needs maxVelocity or maxAcceleration", name, ruleClamp)
+			}
+		case ruleDrop:
+			if r.When.Event != "" {
+				return fmt.Errorf("rule %s: %s only applies to messages", name, ruleDrop)
+			}
+		case ruleStopVehicle, ruleStopAll:
+		default:
+			return fmt.Errorf("rule %s: unknown action %q (expected %s, %s, %s or %s)", name, r.Action, ruleClamp, ruleDrop, ruleStopVehicle, ruleStopAll)
+		}
+	}
+	return nil
+}
+
+// onPieces indique si la pièce correspond aux conditions de pièces de la règle.
+// Doit être appelée avec e.mu verrouillé.
+func (e *Emergency) onPieces(c ruleCondition, piece int, known bool) bool {
+	if len(c.Pieces) == 0 && c.PieceType == "" {
+		return true
+	}
+	if !known {
+		return false
+	}
+	return slices.Contains(c.Pieces, piece) || slices.Contains(e.rules.PieceTypes[c.PieceType], piece)
+}
+
+// matchingRules renvoie les règles de messages qui s'appliquent à un message du véhicule.
+// Doit être appelée avec e.mu verrouillé.
+func (e *Emergency) matchingRules(vehicleID, payloadType string) []safetyRule {
+	piece, known := e.positions[vehicleID]
+	var rules []safetyRule
+	for _, r := range e.rules.Rules {
+		if r.When.Event != "" || (r.When.Message != "" && r.When.Message != payloadType) {
+			continue
+		}
+		if e.onPieces(r.When, piece, known) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// ruleLimit combine les règles clamp qui s'appliquent actuellement au véhicule.
+// Doit être appelée avec e.mu verrouillé.
+func (e *Emergency) ruleLimit(vehicleID, payloadType string) speedLimit {
+	var limit speedLimit
+	for _, r := range e.matchingRules(vehicleID, payloadType) {
+		if r.Action == ruleClamp {
+			limit.MaxVelocity = minLimit(limit.MaxVelocity, r.MaxVelocity)
+			limit.MaxAcceleration = minLimit(limit.MaxAcceleration, r.MaxAcceleration)
+		}
+	}
+	return limit
+}
+
+// applyMessageRules évalue les règles drop, stopVehicle et stopAll sur un message médié.
+// Elle renvoie faux si le message ne doit pas être relayé. Les règles clamp sont appliquées par rewritePayload.
+func (e *Emergency) applyMessageRules(vehicleID string, msg pendingMessage) bool {
+	e.mu.Lock()
+	rules := e.matchingRules(vehicleID, msg.payloadType)
+	e.mu.Unlock()
+
+	for _, r := range rules {
+		reason := fmt.Sprintf("rule %s", r.Name)
+		switch r.Action {
+		case ruleDrop:
+			e.drop(vehicleID, msg, reason)
+			return false
+		case ruleStopVehicle:
+			e.drop(vehicleID, msg, reason)
+			e.stopVehicle(vehicleID, reason)
+			return false
+		case ruleStopAll:
+			e.drop(vehicleID, msg, reason)
+			e.setStop(true, reason)
+			return false
+		}
+	}
+	return true
+}
+
+// applyEventAction applique l'action d'une règle d'événement au véhicule donné.
+func (e *Emergency) applyEventAction(r safetyRule, vehicleID, detail string) {
+	reason := fmt.Sprintf("rule %s: %s", r.Name, detail)
+	log.Println("[Rules]", reason)
+	switch r.Action {
+	case ruleStopVehicle:
+		e.stopVehicle(vehicleID, reason)
+	case ruleStopAll:
+		e.setStop(true, reason)
+	}
+}
+
+// checkOccupancy évalue les règles occupancy quand un véhicule change de pièce.
+func (e *Emergency) checkOccupancy(vehicleID string) {
+	if e.isVehicleStopped(vehicleID) {
+		return
+	}
+
+	type violation struct {
+		rule     safetyRule
+		vehicles []string
+	}
+	var violations []violation
+
+	e.mu.Lock()
+	piece, known := e.positions[vehicleID]
+	for _, r := range e.rules.Rules {
+		if r.When.Event != eventOccupancy || !e.onPieces(r.When, piece, known) {
+			continue
+		}
+		var vehicles []string
+		for id, p := range e.positions {
+			if e.onPieces(r.When, p, true) {
+				vehicles = append(vehicles, id)
+			}
+		}
+		if len(vehicles) > max(1, r.When.MaxVehicles) {
+			slices.Sort(vehicles)
+			violations = append(violations, violation{rule: r, vehicles: vehicles})
+		}
+	}
+	e.mu.Unlock()
+
+	for _, v := range violations {
+		e.applyEventAction(v.rule, vehicleID, fmt.Sprintf("%v on the same pieces", v.vehicles))
+	}
+}
+
+// setDriving met à jour la référence de la règle delocalized avec une vitesse réellement envoyée
+// au véhicule, après limites et holds: il doit rouler à partir de maintenant, ou plus du tout.
+func (e *Emergency) setDriving(vehicleID, payloadType string, payload []byte) {
+	if payloadType != "speed" {
+		return
+	}
+	var speed hyperdrive.SpeedPayload
+	if err := json.Unmarshal(payload, &speed); err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if speed.Velocity == 0 {
+		delete(e.driving, vehicleID)
+	} else {
+		e.driving[vehicleID] = time.Now()
+	}
+}
+
+// clearDriving oublie la référence de la règle delocalized des véhicules arrêtés ou repris: ils
+// ne doivent rouler qu'après la prochaine vitesse envoyée, par un contrôleur ou par la reprise.
+func (e *Emergency) clearDriving(vehicles []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, id := range vehicles {
+		delete(e.driving, id)
+	}
+}
+
+// watchDelocalized vérifie régulièrement les règles delocalized: un véhicule qui devrait rouler
+// mais n'envoie plus d'événements de piste depuis For est considéré comme délocalisé. Les
+// véhicules arrêtés ou retenus par le service d'occupation ne doivent pas rouler; à la fin du
+// hold, reapplySpeed repart de zéro.
+func (e *Emergency) watchDelocalized() {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		type violation struct {
+			rule    safetyRule
+			vehicle string
+			silent  time.Duration
+		}
+		var violations []violation
+
+		e.mu.Lock()
+		for id, since := range e.driving {
+			if e.stop || e.stopped[id] || e.holds[id].Hold {
+				continue
+			}
+			// Le véhicule roule depuis la dernière vitesse envoyée ou le dernier événement de piste.
+			if last, ok := e.lastTrackEvent[id]; ok && last.After(since) {
+				since = last
+			}
+			silent := time.Since(since)
+
+			piece, known := e.positions[id]
+			for _, r := range e.rules.Rules {
+				if r.When.Event == eventDelocalized && silent > r.When.For && e.onPieces(r.When, piece, known) {
+					violations = append(violations, violation{rule: r, vehicle: id, silent: silent})
+				}
+			}
+		}
+		e.mu.Unlock()
+
+		for _, v := range violations {
+			if !e.isVehicleStopped(v.vehicle) {
+				e.applyEventAction(v.rule, v.vehicle, fmt.Sprintf("%s delocalized for %s", v.vehicle, v.silent.Round(100*time.Millisecond)))
+			}
+		}
+	}
+}
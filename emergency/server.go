@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"hyperdrive/remote/hyperdrive"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// statusResponse est renvoyé par GET /status et poussé sur le WebSocket à chaque changement.
+type statusResponse struct {
+	Status      hyperdrive.EmergencyStatus `json:"status"`
+	Vehicles    []vehicleStatus            `json:"vehicles"`
+	Controllers []string                   `json:"controllers"`
+}
+
+// wsCommand est un message envoyé par un client WebSocket.
+type wsCommand struct {
+	Action     string `json:"action"` // stop, continue, stopVehicle, resumeVehicle, transferVehicle
+	Vehicle    string `json:"vehicle,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Controller string `json:"controller,omitempty"` // nouveau propriétaire pour transferVehicle
+	Resume     string `json:"resume,omitempty"`     // mode de reprise pour continue et resumeVehicle (vide: celui par défaut)
+}
+
+// Seule la page servie par Emergency peut ouvrir le WebSocket, pas celle d'un autre site.
+var upgrader = websocket.Upgrader{
+	CheckOrigin: hyperdrive.SameOrigin,
+}
+
+func (e *Emergency) currentStatus() statusResponse {
+	e.mu.Lock()
+	status := e.status
+	e.mu.Unlock()
+
+	return statusResponse{
+		Status:      status,
+		Vehicles:    e.vehicleSummary(),
+		Controllers: e.controllerSummary(),
+	}
+}
+
+// remoteReason ajoute l'origine de la requête à la raison donnée, pour l'état publié.
+func remoteReason(reason, fallback string, r *http.Request) string {
+	if reason == "" {
+		reason = fallback
+	}
+	return reason + " (via " + r.RemoteAddr + ")"
+}
+
+// resumeModeOf renvoie le mode de reprise demandé, ou celui par défaut si aucun n'est donné.
+func (e *Emergency) resumeModeOf(s string) (resumeMode, error) {
+	if s == "" {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.resume.mode, nil
+	}
+	return parseResumeMode(s)
+}
+
+// serveHTTP expose stop, continue et status sur addr, ainsi qu'une page avec un gros bouton rouge.
+// Avec un jeton, chaque requête doit le porter (?token= pour la page et le WebSocket); sans jeton,
+// addr doit être local.
+func (e *Emergency) serveHTTP(addr, token string) error {
+	if err := hyperdrive.CheckHTTPAddr(addr, token); err != nil {
+		return err
+	}
+
+	var (
+		mu      sync.Mutex
+		clients = map[chan statusResponse]bool{}
+	)
+
+	// Pousser le nouvel état à chaque client WebSocket.
+	e.addListener(func() {
+		status := e.currentStatus()
+		mu.Lock()
+		defer mu.Unlock()
+		for ch := range clients {
+			select {
+			case ch <- status:
+			default: // client trop lent, il recevra le prochain état
+			}
+		}
+	})
+
+	writeStatus := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(e.currentStatus()); err != nil {
+			log.Println("[HTTP] Could not write status:", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(stopPage))
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /stop", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("[HTTP] Stop requested by", r.RemoteAddr)
+		e.setStop(true, remoteReason(r.FormValue("reason"), "emergency stop", r))
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /continue", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("[HTTP] Continue requested by", r.RemoteAddr)
+		mode, err := e.resumeModeOf(r.FormValue("resume"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e.applyStop(false, remoteReason(r.FormValue("reason"), "continue", r), mode)
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /vehicles/{id}/stop", func(w http.ResponseWriter, r *http.Request) {
+		e.stopVehicle(r.PathValue("id"), remoteReason(r.FormValue("reason"), "vehicle stop", r))
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /vehicles/{id}/resume", func(w http.ResponseWriter, r *http.Request) {
+		mode, err := e.resumeModeOf(r.FormValue("resume"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e.resumeVehicleWith(r.PathValue("id"), mode)
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /vehicles/{id}/owner", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("[HTTP] Transfer of", r.PathValue("id"), "requested by", r.RemoteAddr)
+		e.forceLease(r.PathValue("id"), r.FormValue("controller"))
+		writeStatus(w)
+	})
+	mux.HandleFunc("GET /ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Println("[HTTP] WebSocket upgrade failed:", err)
+			return
+		}
+		defer conn.Close()
+
+		updates := make(chan statusResponse, 8)
+		mu.Lock()
+		clients[updates] = true
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			delete(clients, updates)
+			mu.Unlock()
+		}()
+
+		// Les commandes arrivent sur une goroutine séparée, les états sont envoyés ici.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				var command wsCommand
+				if err := conn.ReadJSON(&command); err != nil {
+					return
+				}
+				log.Println("[HTTP] WebSocket command from", r.RemoteAddr, ":", command)
+				switch command.Action {
+				case "stop":
+					e.setStop(true, remoteReason(command.Reason, "emergency stop", r))
+				case "continue", "resumeVehicle":
+					mode, err := e.resumeModeOf(command.Resume)
+					if err != nil {
+						log.Println("[HTTP] Invalid WebSocket command:", err)
+						continue
+					}
+					if command.Action == "continue" {
+						e.applyStop(false, remoteReason(command.Reason, "continue", r), mode)
+					} else {
+						e.resumeVehicleWith(command.Vehicle, mode)
+					}
+				case "stopVehicle":
+					e.stopVehicle(command.Vehicle, remoteReason(command.Reason, "vehicle stop", r))
+				case "transferVehicle":
+					e.forceLease(command.Vehicle, command.Controller)
+				default:
+					log.Println("[HTTP] Unknown WebSocket action:", command.Action)
+				}
+			}
+		}()
+
+		if err := conn.WriteJSON(e.currentStatus()); err != nil {
+			return
+		}
+		for {
+			select {
+			case <-done:
+				return
+			case status := <-updates:
+				if err := conn.WriteJSON(status); err != nil {
+					return
+				}
+			}
+		}
+	})
+
+	log.Println("[HTTP] Serving stop, continue and status on", addr)
+	return http.ListenAndServe(addr, hyperdrive.GuardHTTP(mux, token))
+}
+
+// stopPage est la page servie sur /, utilisable depuis n'importe quel téléphone du réseau avec
+// l'adresse http://<pi>:8080/?token=<jeton>. Le jeton de l'adresse est repris pour le WebSocket.
+const stopPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Emergency</title>
+<style>
+  body { font-family: sans-serif; text-align: center; margin: 1em; }
+  button { width: 90%; font-size: 2em; margin: 0.3em 0; padding: 1em 0; border-radius: 0.5em; border: none; color: white; }
+  #stop { background: #d00; height: 40vh; }
+  #continue { background: #080; }
+  #status { font-size: 1.2em; margin: 1em 0; }
+</style>
+</head>
+<body>
+<div id="status">connecting...</div>
+<input id="reason" placeholder="Reason" style="width: 90%; font-size: 1.2em">
+<button id="stop">STOP</button>
+<button id="continue">Continue</button>
+<script>
+  const status = document.getElementById("status");
+  const reason = document.getElementById("reason");
+  const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws" + location.search);
+  ws.onmessage = (event) => {
+    const data = JSON.parse(event.data);
+    const s = data.status;
+    const failed = data.vehicles.filter((v) => v.stopCheck === "FAILED TO STOP").map((v) => v.id);
+    status.textContent = (s.stopped
+      ? "STOPPED by " + s.operator + ": " + s.reason
+      : "Running" + (s.stoppedVehicles ? " (stopped: " + s.stoppedVehicles.join(", ") + ")" : ""))
+      + (failed.length ? " - FAILED TO STOP: " + failed.join(", ") : "");
+    status.style.color = s.stopped ? "#d00" : "#080";
+  };
+  ws.onclose = () => { status.textContent = "disconnected, reload the page"; status.style.color = "gray"; };
+  document.getElementById("stop").onclick = () => ws.send(JSON.stringify({action: "stop", reason: reason.value}));
+  document.getElementById("continue").onclick = () => ws.send(JSON.stringify({action: "continue", reason: reason.value}));
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"hyperdrive/remote/hyperdrive"
+	"log"
+	"slices"
+	"time"
+)
+
+// Résultats de la vérification d'arrêt, affichés dans la liste des véhicules.
+const (
+	stopChecking     = "checking stop"
+	stopConfirmed    = "stop confirmed"
+	stopUnverified   = "stop unverified (no track events)"
+	stopEscalated    = "stopped after direct intent"
+	stopDisconnected = "disconnected to stop it"
+	stopFailed       = "FAILED TO STOP"
+)
+
+// stopConfig règle l'arrêt progressif et sa vérification.
+type stopConfig struct {
+	deceleration float32       // accélération du message d'arrêt, pour une décélération contrôlée
+	settle       time.Duration // temps laissé aux véhicules pour s'arrêter
+	window       time.Duration // durée pendant laquelle un véhicule arrêté ne doit plus envoyer d'événements de piste
+}
+
+// recordTrackEvent note qu'un véhicule a changé de pièce, donc qu'il roule.
+func (e *Emergency) recordTrackEvent(vehicleID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastTrackEvent[vehicleID] = time.Now()
+}
+
+// isMoving indique si le véhicule a envoyé un événement de piste depuis since.
+// known est faux si le véhicule n'a jamais envoyé d'événement de piste.
+func (e *Emergency) isMoving(vehicleID string, since time.Time) (moving bool, known bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	last, known := e.lastTrackEvent[vehicleID]
+	return known && last.After(since), known
+}
+
+func (e *Emergency) setStopCheck(vehicleID, check string) {
+	e.mu.Lock()
+	if check == "" {
+		delete(e.stopChecks, vehicleID)
+	} else {
+		e.stopChecks[vehicleID] = check
+	}
+	e.mu.Unlock()
+	e.notify()
+}
+
+// stopFailures renvoie les véhicules qui n'ont pas pu être arrêtés.
+func (e *Emergency) stopFailures() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var failed []string
+	for id, check := range e.stopChecks {
+		if check == stopFailed {
+			failed = append(failed, id)
+		}
+	}
+	return failed
+}
+
+// sendIntent publie une commande directement sur le topic intent du véhicule, sans passer par le médiateur.
+func (e *Emergency) sendIntent(vehicleID, intentType string, payload any) {
+	data, err := json.Marshal(hyperdrive.Intent{Type: intentType, Payload: payload})
+	if err != nil {
+		log.Println("[Stop] Could not marshal", intentType, "intent:", err)
+		return
+	}
+	if token := e.client.Publish(e.vehicleIntentTopic(vehicleID), 1, false, data); token.Wait() && token.Error() != nil {
+		log.Println("[Stop] Could not send", intentType, "intent to", vehicleID, ":", token.Error())
+	}
+}
+
+// waitStopped attend que les véhicules se soient arrêtés puis renvoie ceux qui roulent encore.
+// Les véhicules dont l'arrêt a été levé entre-temps sont ignorés.
+func (e *Emergency) waitStopped(vehicles []string) []string {
+	e.mu.Lock()
+	config := e.stopCfg
+	e.mu.Unlock()
+
+	time.Sleep(config.settle)
+	since := time.Now()
+	time.Sleep(config.window)
+
+	var moving []string
+	for _, id := range vehicles {
+		if !e.isVehicleStopped(id) {
+			e.setStopCheck(id, "")
+			continue
+		}
+		switch isMoving, known := e.isMoving(id, since); {
+		case isMoving:
+			moving = append(moving, id)
+		case !known:
+			e.setStopCheck(id, stopUnverified)
+		}
+	}
+	return moving
+}
+
+// verifyStop vérifie que les véhicules se sont arrêtés après le message d'arrêt, et escalade sinon:
+// d'abord une commande de vitesse directement sur le topic intent, puis une déconnexion.
+func (e *Emergency) verifyStop(vehicles []string) {
+	for _, id := range vehicles {
+		e.setStopCheck(id, stopChecking)
+	}
+
+	moving := e.waitStopped(vehicles)
+	for _, id := range vehicles {
+		e.mu.Lock()
+		check := e.stopChecks[id]
+		e.mu.Unlock()
+		if check == stopChecking && !slices.Contains(moving, id) {
+			e.setStopCheck(id, stopConfirmed)
+		}
+	}
+	if len(moving) == 0 {
+		return
+	}
+
+	log.Println("[Stop] Still moving after the stop, sending the stop directly to", moving)
+	for _, id := range moving {
+		e.sendIntent(id, "speed", hyperdrive.SpeedPayload{Velocity: 0, Acceleration: 1000})
+	}
+	stillMoving := e.waitStopped(moving)
+	for _, id := range moving {
+		if e.isVehicleStopped(id) && !slices.Contains(stillMoving, id) {
+			e.setStopCheck(id, stopEscalated)
+		}
+	}
+	if len(stillMoving) == 0 {
+		return
+	}
+
+	log.Println("[Stop] Still moving after the direct stop, disconnecting", stillMoving)
+	for _, id := range stillMoving {
+		e.sendIntent(id, "connect", hyperdrive.ConnectPayload{Value: false})
+	}
+	failed := e.waitStopped(stillMoving)
+	for _, id := range stillMoving {
+		switch {
+		case slices.Contains(failed, id):
+			log.Println("[Stop] Vehicle", id, "FAILED TO STOP")
+			e.setStopCheck(id, stopFailed)
+		case e.isVehicleStopped(id):
+			e.setStopCheck(id, stopDisconnected)
+		}
+	}
+}
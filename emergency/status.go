@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hyperdrive/remote/hyperdrive"
+	"log"
+	"sort"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// publishStatus publie l'état de l'arrêt d'urgence en retained, pour qu'il survive à un
+// redémarrage et que les clients arrivés plus tard le voient.
+func (e *Emergency) publishStatus(reason string) {
+	e.mu.Lock()
+	status := hyperdrive.EmergencyStatus{
+		Stopped:   e.stop,
+		Reason:    reason,
+		Operator:  e.operator,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if e.stop && e.status.Stopped {
+		// La piste était déjà arrêtée: on garde quand et pourquoi.
+		status.Reason, status.Operator, status.Timestamp = e.status.Reason, e.status.Operator, e.status.Timestamp
+	}
+	for id := range e.stopped {
+		status.StoppedVehicles = append(status.StoppedVehicles, id)
+	}
+	sort.Strings(status.StoppedVehicles)
+	e.status = status
+	e.mu.Unlock()
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		log.Println("[Status] Could not marshal the emergency status:", err)
+		return
+	}
+	if token := e.client.Publish(hyperdrive.EmergencyStatusTopic, e.qos, true, data); token.Wait() && token.Error() != nil {
+		log.Println("[Status] Could not publish the emergency status:", token.Error())
+	}
+	e.notify()
+}
+
+// restoreStatus reprend l'état retenu par le broker lors du démarrage.
+// Sans état retenu après timeout, la piste est considérée comme en marche.
+func (e *Emergency) restoreStatus(timeout time.Duration) {
+	statusCh := make(chan hyperdrive.EmergencyStatus, 1)
+	token := e.client.Subscribe(hyperdrive.EmergencyStatusTopic, e.qos, func(client mqtt.Client, msg mqtt.Message) {
+		var status hyperdrive.EmergencyStatus
+		if err := json.Unmarshal(msg.Payload(), &status); err != nil {
+			log.Println("[Status] Could not read the retained emergency status:", err)
+			return
+		}
+		select {
+		case statusCh <- status:
+		default:
+		}
+	})
+	if token.Wait() && token.Error() != nil {
+		log.Println("[Status] Could not subscribe to", hyperdrive.EmergencyStatusTopic, ":", token.Error())
+		return
+	}
+	defer e.client.Unsubscribe(hyperdrive.EmergencyStatusTopic)
+
+	select {
+	case status := <-statusCh:
+		e.mu.Lock()
+		e.stop = status.Stopped
+		for _, id := range status.StoppedVehicles {
+			e.stopped[id] = true
+		}
+		e.status = status
+		e.mu.Unlock()
+		log.Printf("[Status] Restored emergency status: %+v", status)
+	case <-time.After(timeout):
+		log.Println("[Status] No retained emergency status, starting with the track running")
+	}
+}
+
+// statusText renvoie une description lisible de l'état courant.
+func (e *Emergency) statusText() string {
+	e.mu.Lock()
+	status := e.status
+	e.mu.Unlock()
+
+	if status.Timestamp == 0 {
+		return "Running"
+	}
+
+	since := status.Since().Format("15:04:05")
+	switch {
+	case status.Stopped:
+		return fmt.Sprintf("STOPPED since %s by %s: %s", since, status.Operator, status.Reason)
+	case len(status.StoppedVehicles) > 0:
+		return fmt.Sprintf("Running, %d vehicle(s) stopped since %s by %s: %s", len(status.StoppedVehicles), since, status.Operator, status.Reason)
+	default:
+		return fmt.Sprintf("Running since %s (%s, %s)", since, status.Operator, status.Reason)
+	}
+}
@@ -0,0 +1,289 @@
+//go:build !headless
+
+package main
+
+import (
+	"fmt"
+	"hyperdrive/remote/topic"
+	"log"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+)
+
+// runUI affiche le formulaire de configuration, puis les contrôles d'arrêt une fois la médiation démarrée.
+func runUI(em *Emergency, defaults mediatorConfig) {
+	// Create app
+	isStopped := binding.NewBool()
+
+	// Surveiller les contrôleurs dès le démarrage, même avant que le formulaire soit rempli.
+	controllers := binding.NewStringList()
+	controllers.Set(em.controllerSummary())
+	em.addListener(func() {
+		controllers.Set(em.controllerSummary())
+	})
+
+	w := app.New().NewWindow("Emergency")
+	w.Resize(fyne.NewSize(450, 500))
+	isStopped.Set(em.stop)
+
+	vehicleIntentTopicFormatEntry := widget.NewEntry()
+	vehicleIntentTopicFormatEntry.SetText(defaults.VehicleIntentTopic.String())
+	vehicleIntentTopicFormatEntry.Validator = templateValidator("vehicle")
+	remoteVehicleInstructionsTopicEntry := widget.NewEntry()
+	remoteVehicleInstructionsTopicEntry.SetText(defaults.RemoteInstructionsTopic.String())
+	remoteVehicleInstructionsTopicEntry.Validator = templateValidator("vehicle", "type")
+	remoteRootTopicEntry := widget.NewEntry()
+	remoteRootTopicEntry.SetText(defaults.RemoteRoot)
+	vehicleTrackTopicFormatEntry := widget.NewEntry()
+	vehicleTrackTopicFormatEntry.SetText(defaults.VehicleTrackTopic.String())
+	vehicleTrackTopicFormatEntry.Validator = templateValidator("vehicle")
+	httpAddrEntry := widget.NewEntry()
+	httpAddrEntry.SetText(defaults.HTTPAddr)
+
+	form := &widget.Form{
+		Items: []*widget.FormItem{
+			{
+				Text:   "Topic template (where {vehicle} is the car id) of the car intents:",
+				Widget: vehicleIntentTopicFormatEntry,
+			},
+			{
+				Text:   "RemoteControl instructions, with {vehicle} and the subscription {type}",
+				Widget: remoteVehicleInstructionsTopicEntry,
+			},
+			{
+				Text:   "RemoteControl root topic",
+				Widget: remoteRootTopicEntry,
+			},
+			{
+				Text:   "Topic template (where {vehicle} is the car id) of the car track events:",
+				Widget: vehicleTrackTopicFormatEntry,
+			},
+			{
+				Text:   "Address of the HTTP stop endpoints (empty: disabled)",
+				Widget: httpAddrEntry,
+			},
+		},
+		OnSubmit: func() {
+			config := mediatorConfig{
+				VehicleIntentTopic:       topic.Template(vehicleIntentTopicFormatEntry.Text),
+				RemoteInstructionsTopic:  topic.Template(remoteVehicleInstructionsTopicEntry.Text),
+				RemoteRoot:               remoteRootTopicEntry.Text,
+				VehicleTrackTopic:        topic.Template(vehicleTrackTopicFormatEntry.Text),
+				VehicleSubscriptionTopic: defaults.VehicleSubscriptionTopic,
+				HTTPAddr:                 httpAddrEntry.Text,
+				HTTPToken:                defaults.HTTPToken,
+			}
+			if err := em.startMediation(config); err != nil {
+				log.Fatalf("Subscribe to remote vehicles failed: %v", err)
+			}
+			if config.HTTPAddr != "" {
+				go func() {
+					if err := em.serveHTTP(config.HTTPAddr, config.HTTPToken); err != nil {
+						log.Println("HTTP server stopped:", err)
+					}
+				}()
+			}
+
+			statusLabel := widget.NewLabelWithData(binding.BoolToString(isStopped))
+			statusText := binding.NewString()
+			statusText.Set(em.statusText())
+			statusTextLabel := widget.NewLabelWithData(statusText)
+			statusTextLabel.Wrapping = fyne.TextWrapWord
+			failuresLabel := widget.NewLabel("")
+			failuresLabel.Importance = widget.DangerImportance
+			failuresLabel.Hide()
+
+			reasonEntry := widget.NewEntry()
+			reasonEntry.SetPlaceHolder("Reason (published with the stop)")
+
+			stopButton := widget.NewButton("Stop", func() {
+				// Log the action to the console (optional)
+				println("Stop requested: setting state to true")
+				reason := reasonEntry.Text
+				if reason == "" {
+					reason = "emergency stop"
+				}
+				em.setStop(true, reason)
+			})
+
+			continueButton := widget.NewButton("Continue", func() {
+				// Log the action to the console (optional)
+				println("Continue requested: setting state to false")
+				em.setStop(false, "continue")
+				reasonEntry.SetText("")
+			})
+
+			buttonContainer := container.NewGridWithColumns(2, stopButton, continueButton)
+
+			// Réglages courants, écrits aussi par les goroutines HTTP, règles et MQTT
+			em.mu.Lock()
+			currentMode := em.mode
+			currentMaxVelocity := em.limits.Global.MaxVelocity
+			resume := em.resume
+			em.mu.Unlock()
+
+			// Mode de médiation et limite globale, modifiables pendant la session
+			modeSelect := widget.NewRadioGroup([]string{forwardMode.String(), limitMode.String()}, func(selected string) {
+				mode, err := parseMediationMode(selected)
+				if err != nil {
+					return
+				}
+				em.setMediationMode(mode)
+			})
+			modeSelect.Horizontal = true
+			modeSelect.SetSelected(currentMode.String())
+
+			maxVelocity := binding.NewFloat()
+			maxVelocity.Set(float64(currentMaxVelocity))
+			maxVelocitySlider := widget.NewSliderWithData(0, 1000, maxVelocity)
+			maxVelocitySlider.OnChangeEnded = func(v float64) {
+				em.setGlobalMaxVelocity(float32(v))
+			}
+			// Ce que Continue renvoie aux véhicules, et les feux pendant l'arrêt
+			resumeSelect := widget.NewSelect([]string{stayStopped.String(), reducedSpeed.String(), restoreState.String()}, func(selected string) {
+				mode, err := parseResumeMode(selected)
+				if err != nil {
+					return
+				}
+				em.setResumeMode(mode)
+			})
+			resumeSelect.SetSelected(resume.mode.String())
+			stopLightsCheck := widget.NewCheck("Red flashing lights while stopped", em.setStopLights)
+			stopLightsCheck.SetChecked(resume.stopLights)
+
+			limitForm := container.New(layout.NewFormLayout(),
+				widget.NewLabel("On continue:"), container.NewHBox(resumeSelect, stopLightsCheck),
+				widget.NewLabel("Mode:"), modeSelect,
+				widget.NewLabel("Max velocity:"),
+				container.NewBorder(nil, nil, nil,
+					widget.NewLabelWithData(binding.FloatToStringWithFormat(maxVelocity, "%.0f")),
+					maxVelocitySlider,
+				),
+			)
+
+			controllerList := widget.NewListWithData(controllers,
+				func() fyne.CanvasObject { return widget.NewLabel("") },
+				func(item binding.DataItem, o fyne.CanvasObject) {
+					o.(*widget.Label).Bind(item.(binding.String))
+				},
+			)
+
+			vehicles := em.vehicleSummary()
+			var vehicleList *widget.List
+			vehicleList = widget.NewList(
+				func() int { return len(vehicles) },
+				func() fyne.CanvasObject {
+					return container.NewBorder(nil, nil, nil,
+						container.NewHBox(widget.NewButton("Stop", nil), widget.NewButton("Resume", nil), widget.NewButton("Transfer", nil)),
+						widget.NewLabel(""),
+					)
+				},
+				func(i widget.ListItemID, o fyne.CanvasObject) {
+					vehicle := vehicles[i]
+					row := o.(*fyne.Container)
+					buttons := row.Objects[1].(*fyne.Container)
+
+					state := "running"
+					if vehicle.Stopped {
+						state = "STOPPED"
+					}
+					if vehicle.StopCheck != "" {
+						state += " (" + vehicle.StopCheck + ")"
+					}
+					if vehicle.Occupancy != "" {
+						state += ", " + vehicle.Occupancy
+					}
+					owner := vehicle.Owner
+					if owner == "" {
+						owner = "no owner"
+					}
+					row.Objects[0].(*widget.Label).SetText(fmt.Sprintf("%s [%s] %s, %s", vehicle.ID, strings.Join(vehicle.Types, ", "), state, owner))
+					buttons.Objects[0].(*widget.Button).OnTapped = func() { em.stopVehicle(vehicle.ID, "stopped from the vehicle list") }
+					buttons.Objects[1].(*widget.Button).OnTapped = func() { em.resumeVehicle(vehicle.ID) }
+					buttons.Objects[2].(*widget.Button).OnTapped = func() { showTransferDialog(em, vehicle.ID, w) }
+				},
+			)
+
+			// L'état peut aussi changer par le dead-man switch ou les endpoints HTTP.
+			em.addListener(func() {
+				em.mu.Lock()
+				stop := em.stop
+				em.mu.Unlock()
+				isStopped.Set(stop)
+				statusText.Set(em.statusText())
+				failures := em.stopFailures()
+				fyne.Do(func() {
+					vehicles = em.vehicleSummary()
+					vehicleList.Refresh()
+					if len(failures) == 0 {
+						failuresLabel.Hide()
+						return
+					}
+					sort.Strings(failures)
+					failuresLabel.SetText("Failed to stop: " + strings.Join(failures, ", "))
+					failuresLabel.Show()
+				})
+			})
+
+			content := container.NewBorder(
+				container.NewVBox(
+					statusLabel,
+					statusTextLabel,
+					failuresLabel,
+					layout.NewSpacer(), // Pushes the label up a bit
+					reasonEntry,
+					buttonContainer,
+					widget.NewSeparator(),
+					limitForm,
+				),
+				nil, nil, nil,
+				container.NewVSplit(
+					container.NewBorder(widget.NewLabel("Vehicles:"), nil, nil, nil, vehicleList),
+					container.NewBorder(widget.NewLabel("Controllers:"), nil, nil, nil, controllerList),
+				),
+			)
+
+			// replace the form by the cars
+			w.SetContent(content)
+		},
+	}
+
+	w.SetContent(form)
+
+	w.ShowAndRun()
+}
+
+// releaseOption est l'entrée du dialogue de transfert qui libère le véhicule.
+const releaseOption = "(release)"
+
+// showTransferDialog laisse l'opérateur donner un véhicule à un autre contrôleur.
+func showTransferDialog(em *Emergency, vehicleID string, w fyne.Window) {
+	controllerSelect := widget.NewSelect(append(em.aliveControllers(), releaseOption), nil)
+	dialog.ShowForm("Transfer "+vehicleID, "Transfer", "Cancel",
+		[]*widget.FormItem{widget.NewFormItem("New owner", controllerSelect)},
+		func(confirmed bool) {
+			if !confirmed || controllerSelect.Selected == "" {
+				return
+			}
+			controller := controllerSelect.Selected
+			if controller == releaseOption {
+				controller = ""
+			}
+			em.forceLease(vehicleID, controller)
+		}, w)
+}
+
+// templateValidator vérifie un template de topic saisi dans le formulaire.
+func templateValidator(required ...string) fyne.StringValidator {
+	return func(s string) error {
+		return topic.Template(s).Validate(required...)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Schémas des payloads acceptés par le médiateur. Les champs sont des pointeurs
+// pour distinguer un champ absent d'une valeur nulle.
+type speedSchema struct {
+	Velocity     *float32 `json:"velocity"`
+	Acceleration *float32 `json:"acceleration"`
+}
+
+type laneSchema struct {
+	Velocity         *float32 `json:"velocity"`
+	Acceleration     *float32 `json:"acceleration"`
+	Offset           *float32 `json:"offset"`
+	OffsetFromCenter *float32 `json:"offsetFromCenter"`
+}
+
+type valueSchema struct {
+	Value *bool `json:"value"`
+}
+
+type lightEffectSchema struct {
+	Effect    *string `json:"effect"`
+	Start     *int    `json:"start"`
+	End       *int    `json:"end"`
+	Frequency *int    `json:"frequency"`
+}
+
+type lightsSchema struct {
+	FrontGreen  *lightEffectSchema `json:"frontGreen"`
+	FrontRed    *lightEffectSchema `json:"frontRed"`
+	Tail        *lightEffectSchema `json:"tail"`
+	EngineRed   *lightEffectSchema `json:"engineRed"`
+	EngineGreen *lightEffectSchema `json:"engineGreen"`
+	EngineBlue  *lightEffectSchema `json:"engineBlue"`
+}
+
+var lightEffects = []string{"off", "steady", "fade", "pulse", "flash", "strobe"}
+
+// decodeStrict décode payload dans v en refusant les champs inconnus et les données en trop.
+func decodeStrict(payload []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("malformed payload: %w", err)
+	}
+	if dec.More() {
+		return fmt.Errorf("malformed payload: trailing data")
+	}
+	return nil
+}
+
+// checkRange vérifie qu'une valeur optionnelle se trouve dans [min, max].
+func checkRange[T int | float32](name string, v *T, min, max T) error {
+	if v != nil && (*v < min || *v > max) {
+		return fmt.Errorf("%s %v out of range [%v, %v]", name, *v, min, max)
+	}
+	return nil
+}
+
+func checkLightEffect(name string, effect *lightEffectSchema) error {
+	if effect == nil {
+		return nil
+	}
+	// Le RemoteControl envoie un effet vide pour les lumières qui n'ont pas été choisies.
+	if effect.Effect != nil && *effect.Effect != "" && !slices.Contains(lightEffects, *effect.Effect) {
+		return fmt.Errorf("%s: unknown effect %q", name, *effect.Effect)
+	}
+	for _, err := range []error{
+		checkRange(name+".start", effect.Start, 0, 15),
+		checkRange(name+".end", effect.End, 0, 15),
+		checkRange(name+".frequency", effect.Frequency, 0, 255),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePayload vérifie un payload RemoteControl par rapport au schéma de son type.
+// Les plages sont celles documentées dans le package hyperdrive.
+func validatePayload(payloadType string, payload []byte) error {
+	switch payloadType {
+	case "speed":
+		var data speedSchema
+		if err := decodeStrict(payload, &data); err != nil {
+			return err
+		}
+		if data.Velocity == nil {
+			return fmt.Errorf("missing velocity")
+		}
+		if err := checkRange("velocity", data.Velocity, -100, 1000); err != nil {
+			return err
+		}
+		return checkRange("acceleration", data.Acceleration, 0, 2000)
+
+	case "lane":
+		var data laneSchema
+		if err := decodeStrict(payload, &data); err != nil {
+			return err
+		}
+		for _, err := range []error{
+			checkRange("velocity", data.Velocity, 0, 1000),
+			checkRange("acceleration", data.Acceleration, 0, 2000),
+			checkRange("offset", data.Offset, -100, 100),
+			checkRange("offsetFromCenter", data.OffsetFromCenter, -100, 100),
+		} {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "cancelLane", "connect":
+		var data valueSchema
+		if err := decodeStrict(payload, &data); err != nil {
+			return err
+		}
+		if data.Value == nil {
+			return fmt.Errorf("missing value")
+		}
+		return nil
+
+	case "lights":
+		var data lightsSchema
+		if err := decodeStrict(payload, &data); err != nil {
+			return err
+		}
+		for _, err := range []error{
+			checkLightEffect("frontGreen", data.FrontGreen),
+			checkLightEffect("frontRed", data.FrontRed),
+			checkLightEffect("tail", data.Tail),
+			checkLightEffect("engineRed", data.EngineRed),
+			checkLightEffect("engineGreen", data.EngineGreen),
+			checkLightEffect("engineBlue", data.EngineBlue),
+		} {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown payload type %q", payloadType)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"hyperdrive/remote/topic"
+	"log"
+	"slices"
+	"sort"
+)
+
+const (
+	vehicleStopTopic topic.Template = "Emergency/U/E/stop/{vehicle}"
+)
+
+// vehicleStatus est une vue d'un véhicule connu du médiateur, utilisée par l'UI.
+type vehicleStatus struct {
+	ID      string   `json:"id"`
+	Types   []string `json:"types"` // types de messages médiés (speed, lane, ...)
+	Stopped bool     `json:"stopped"`
+	Owner   string   `json:"owner,omitempty"` // contrôleur qui détient le bail
+	// StopCheck est le résultat de la vérification d'arrêt (confirmé, escaladé, échec).
+	StopCheck string `json:"stopCheck,omitempty"`
+	// Occupancy décrit le hold du service d'occupation (retenu ou ralenti), s'il y en a un.
+	Occupancy string `json:"occupancy,omitempty"`
+}
+
+// registerSubscription ajoute le type de message à la liste du véhicule.
+// newVehicle et newType indiquent ce qui doit encore être abonné chez le véhicule.
+func (e *Emergency) registerSubscription(vehicleID, payloadType string) (newVehicle bool, newType bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Initialize the map if not already.
+	if e.vehicleList == nil {
+		e.vehicleList = map[string][]string{}
+	}
+
+	subscriptionType, exists := e.vehicleList[vehicleID]
+	if !exists {
+		e.vehicleList[vehicleID] = []string{payloadType}
+		return true, true
+	}
+	if !slices.Contains(subscriptionType, payloadType) {
+		e.vehicleList[vehicleID] = append(subscriptionType, payloadType)
+		return false, true
+	}
+	return false, false
+}
+
+// removeVehicle oublie un véhicule déconnecté par son contrôleur: son worker s'arrête, sa position
+// ne compte plus et il sera de nouveau abonné à son prochain message. Un arrêt individuel reste
+// actif, il lui sera renvoyé à son retour.
+func (e *Emergency) removeVehicle(vehicleID string) {
+	e.mu.Lock()
+	delete(e.queues, vehicleID)
+	delete(e.vehicleList, vehicleID)
+	delete(e.lastCommands, vehicleID)
+	delete(e.lastSpeed, vehicleID)
+	delete(e.positions, vehicleID)
+	delete(e.lastTrackEvent, vehicleID)
+	delete(e.driving, vehicleID)
+	delete(e.stopChecks, vehicleID)
+	trackTopic := e.config.VehicleTrackTopic.Format(topic.Values{"vehicle": vehicleID})
+	e.mu.Unlock()
+
+	e.client.Unsubscribe(trackTopic)
+	log.Println("Emergency: vehicle", vehicleID, "was disconnected, forgetting it")
+	e.notify()
+}
+
+// isVehicleStopped indique si le véhicule est arrêté, individuellement ou par l'arrêt général.
+func (e *Emergency) isVehicleStopped(vehicleID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stop || e.stopped[vehicleID]
+}
+
+// stopVehicle arrête un seul véhicule et ignore ses messages jusqu'à resumeVehicle.
+func (e *Emergency) stopVehicle(vehicleID string, reason string) {
+	e.mu.Lock()
+	wasStopped := e.stop || e.stopped[vehicleID]
+	e.stopped[vehicleID] = true
+	e.mu.Unlock()
+
+	log.Println("Emergency: publishing immediate speed=0 to vehicle", vehicleID)
+	if err := e.sendStop(vehicleStopTopic.Format(topic.Values{"vehicle": vehicleID})); err != nil {
+		log.Println("[Emergency] Got error while sending stop to", vehicleID, ":", err)
+	}
+	e.publishStatus(reason)
+	if !wasStopped {
+		e.clearDriving([]string{vehicleID})
+		e.showStopLights([]string{vehicleID})
+		go e.verifyStop([]string{vehicleID})
+	}
+	e.notify()
+}
+
+// resumeVehicle laisse de nouveau passer les messages d'un véhicule.
+func (e *Emergency) resumeVehicle(vehicleID string) {
+	e.mu.Lock()
+	mode := e.resume.mode
+	e.mu.Unlock()
+	e.resumeVehicleWith(vehicleID, mode)
+}
+
+// resumeVehicleWith est resumeVehicle avec un mode de reprise choisi pour ce véhicule.
+func (e *Emergency) resumeVehicleWith(vehicleID string, mode resumeMode) {
+	e.mu.Lock()
+	wasStopped := e.stopped[vehicleID]
+	delete(e.stopped, vehicleID)
+	stillStopped := e.stop
+	e.mu.Unlock()
+
+	log.Println("Emergency: resuming vehicle", vehicleID)
+	e.publishStatus("resumed " + vehicleID)
+	if !stillStopped {
+		e.setStopCheck(vehicleID, "")
+	}
+	if wasStopped && !stillStopped {
+		e.clearDriving([]string{vehicleID})
+		e.resumeVehicles([]string{vehicleID}, mode)
+	}
+	e.notify()
+}
+
+// vehicleSummary renvoie l'état de chaque véhicule connu, trié par ID.
+func (e *Emergency) vehicleSummary() []vehicleStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	list := make([]vehicleStatus, 0, len(e.vehicleList))
+	for id, types := range e.vehicleList {
+		list = append(list, vehicleStatus{
+			ID:      id,
+			Types:   slices.Clone(types),
+			Stopped: e.stop || e.stopped[id],
+			Owner:   e.leaseOwner(id),
+
+			StopCheck: e.stopChecks[id],
+			Occupancy: occupancyText(e.holds[id]),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
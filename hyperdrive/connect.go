@@ -2,31 +2,33 @@ package hyperdrive
 
 import (
 	"encoding/json"
-	"fmt"
+	"hyperdrive/remote/topic"
 	"log"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 type ConnectPayload struct {
-	Value bool `json:"value"` // {true|false} # Default: false
+	Value      bool   `json:"value"` // {true|false} # Default: false
+	Controller string `json:"controller,omitempty"`
 }
 
 const (
-	ConnectTopic = "RemoteControl/U/E/vehicles/%s/connect"
+	ConnectTopic topic.Template = "RemoteControl/U/E/vehicles/{vehicle}/connect"
 )
 
 // Send a connect payload to list of targets
-func connect(client mqtt.Client, value bool, target string) error {
+func connect(client mqtt.Client, value bool, target string, controller string) error {
 	payload, err := json.Marshal(ConnectPayload{
-		Value: value,
+		Value:      value,
+		Controller: controller,
 	})
 
 	if err != nil {
 		return err
 	}
 
-	connectTopic := fmt.Sprintf(ConnectTopic, target)
+	connectTopic := ConnectTopic.Format(topic.Values{"vehicle": target})
 	log.Println("[Connect] Sending", string(payload), "on", connectTopic)
 
 	if token := client.Publish(connectTopic, 1, false, payload); token.Wait() && token.Error() != nil {
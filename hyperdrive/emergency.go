@@ -0,0 +1,48 @@
+package hyperdrive
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// EmergencyStatusTopic carries the retained emergency state published by the Emergency app.
+	EmergencyStatusTopic = "Emergency/U/E/status"
+)
+
+// EmergencyStatus is the state of the emergency stop, with who stopped the track and why.
+type EmergencyStatus struct {
+	Stopped         bool     `json:"stopped"`
+	StoppedVehicles []string `json:"stoppedVehicles,omitempty"` // vehicles stopped individually
+	Reason          string   `json:"reason"`
+	Operator        string   `json:"operator"`
+	Timestamp       int64    `json:"timestamp"` // unix milliseconds
+}
+
+// Since returns the time at which the status was published.
+func (s EmergencyStatus) Since() time.Time {
+	return time.UnixMilli(s.Timestamp)
+}
+
+// WatchEmergencyStatus calls onStatus with the retained status, then with every update.
+func WatchEmergencyStatus(client mqtt.Client, onStatus func(EmergencyStatus)) error {
+	token := client.Subscribe(EmergencyStatusTopic, 1, func(c mqtt.Client, m mqtt.Message) {
+		if len(m.Payload()) == 0 {
+			return // retained status was cleared
+		}
+
+		var status EmergencyStatus
+		if err := json.Unmarshal(m.Payload(), &status); err != nil {
+			log.Println("[Emergency] Could not read the emergency status:", err)
+			return
+		}
+		onStatus(status)
+	})
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package hyperdrive
+
+/*
+Heartbeat (dead-man switch):
+
+Every controlling process (RemoteControl, pathfind) periodically publishes a
+heartbeat listing the vehicles it drives, and registers a Last Will on the same
+topic. If the process crashes, the broker publishes the will and the Emergency
+app can stop the vehicles of the controller that went silent.
+
+The heartbeat also claims and renews the lease of every vehicle it drives (see lease.go).
+*/
+
+import (
+	"encoding/json"
+	"hyperdrive/remote/topic"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const HeartbeatTopic topic.Template = "Emergency/U/E/heartbeat/{controller}"
+
+const (
+	HeartbeatInterval  = 500 * time.Millisecond
+	HeartbeatAliveTime = 2 * time.Second // default time after which a silent controller is considered dead
+)
+
+type HeartbeatPayload struct {
+	Controller string   `json:"controller"`
+	Alive      bool     `json:"alive"`
+	Clean      bool     `json:"clean,omitempty"` // set when the controller shut down on purpose
+	Vehicles   []string `json:"vehicles"`
+	Timestamp  int64    `json:"timestamp"` // unix milliseconds
+}
+
+// SetHeartbeatWill registers the Last Will of a controller. It must be called
+// on the client options before connecting.
+func SetHeartbeatWill(opts *mqtt.ClientOptions, controller string) {
+	payload, err := json.Marshal(HeartbeatPayload{
+		Controller: controller,
+		Alive:      false,
+	})
+	if err != nil {
+		log.Println("[Heartbeat] Could not marshal the last will:", err)
+		return
+	}
+	opts.SetWill(HeartbeatTopic.Format(topic.Values{"controller": controller}), string(payload), 1, false)
+}
+
+// Heartbeat publishes the liveness of a controller together with the vehicles it owns.
+type Heartbeat struct {
+	client     mqtt.Client
+	controller string
+	interval   time.Duration
+
+	mu             sync.Mutex
+	vehicles       []string
+	leases         map[string]Lease // last lease seen per vehicle
+	leaseListeners []func(Lease)
+	stop           chan struct{}
+}
+
+func NewHeartbeat(client mqtt.Client, controller string, interval time.Duration) *Heartbeat {
+	return &Heartbeat{
+		client:     client,
+		controller: controller,
+		interval:   interval,
+		leases:     map[string]Lease{},
+		stop:       make(chan struct{}),
+	}
+}
+
+// Controller returns the ID of the controller, as sent in its commands.
+func (h *Heartbeat) Controller() string {
+	return h.controller
+}
+
+// OnLease registers a function called with every lease update published by the Emergency mediator.
+func (h *Heartbeat) OnLease(listener func(Lease)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leaseListeners = append(h.leaseListeners, listener)
+}
+
+// AddVehicle marks a vehicle as driven by this controller and claims its lease.
+func (h *Heartbeat) AddVehicle(vehicle string) {
+	h.mu.Lock()
+	if !slices.Contains(h.vehicles, vehicle) {
+		h.vehicles = append(h.vehicles, vehicle)
+	}
+	h.mu.Unlock()
+
+	if err := h.claim(vehicle); err != nil {
+		log.Println("[Lease] Could not claim", vehicle, ":", err)
+	}
+}
+
+// RemoveVehicle stops announcing a vehicle, e.g. once it got disconnected, and releases its lease.
+func (h *Heartbeat) RemoveVehicle(vehicle string) {
+	h.mu.Lock()
+	h.vehicles = slices.DeleteFunc(h.vehicles, func(v string) bool { return v == vehicle })
+	h.mu.Unlock()
+
+	if err := h.release(vehicle); err != nil {
+		log.Println("[Lease] Could not release", vehicle, ":", err)
+	}
+}
+
+// claim publishes (retained) the claim of the controller on a vehicle.
+func (h *Heartbeat) claim(vehicle string) error {
+	payload, err := json.Marshal(LeaseClaim{
+		Controller: h.controller,
+		Vehicle:    vehicle,
+		Duration:   LeaseDuration.Milliseconds(),
+		Timestamp:  time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if token := h.client.Publish(LeaseClaimTopic.Format(topic.Values{"vehicle": vehicle, "controller": h.controller}), 1, true, payload); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+// release clears the retained claim of the controller on a vehicle.
+func (h *Heartbeat) release(vehicle string) error {
+	if token := h.client.Publish(LeaseClaimTopic.Format(topic.Values{"vehicle": vehicle, "controller": h.controller}), 1, true, []byte{}); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+// leaseHandler keeps track of the leases. If a vehicle got force-transferred to
+// another controller, this controller stops claiming and announcing it.
+func (h *Heartbeat) leaseHandler(c mqtt.Client, m mqtt.Message) {
+	if len(m.Payload()) == 0 {
+		return // lease cleared
+	}
+
+	var lease Lease
+	if err := json.Unmarshal(m.Payload(), &lease); err != nil {
+		log.Println("[Lease] Could not read lease on", m.Topic(), ":", err)
+		return
+	}
+
+	h.mu.Lock()
+	h.leases[lease.Vehicle] = lease
+	transferred := lease.Forced && lease.Owner != h.controller && lease.Active() && slices.Contains(h.vehicles, lease.Vehicle)
+	listeners := slices.Clone(h.leaseListeners)
+	h.mu.Unlock()
+
+	if transferred {
+		log.Println("[Lease] Vehicle", lease.Vehicle, "was transferred to", lease.Owner)
+		h.RemoveVehicle(lease.Vehicle)
+	}
+	for _, listener := range listeners {
+		listener(lease)
+	}
+}
+
+func (h *Heartbeat) publish(alive bool, clean bool) error {
+	h.mu.Lock()
+	payload, err := json.Marshal(HeartbeatPayload{
+		Controller: h.controller,
+		Alive:      alive,
+		Clean:      clean,
+		Vehicles:   slices.Clone(h.vehicles),
+		Timestamp:  time.Now().UnixMilli(),
+	})
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if token := h.client.Publish(HeartbeatTopic.Format(topic.Values{"controller": h.controller}), 1, false, payload); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+// Start publishes a heartbeat every interval and renews the vehicle leases in the background.
+func (h *Heartbeat) Start() {
+	if token := h.client.Subscribe(LeaseTopic.Filter(), 1, h.leaseHandler); token.Wait() && token.Error() != nil {
+		log.Println("[Lease] Could not subscribe to", LeaseTopic.Filter(), ":", token.Error())
+	}
+
+	go func() {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+		renew := time.NewTicker(LeaseRenewInterval)
+		defer renew.Stop()
+
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				if err := h.publish(true, false); err != nil {
+					log.Println("[Heartbeat] Could not send heartbeat:", err)
+				}
+			case <-renew.C:
+				h.mu.Lock()
+				vehicles := slices.Clone(h.vehicles)
+				h.mu.Unlock()
+				for _, vehicle := range vehicles {
+					if err := h.claim(vehicle); err != nil {
+						log.Println("[Lease] Could not renew", vehicle, ":", err)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends the heartbeat, releases the leases and announces a clean shutdown,
+// so that it is not mistaken for a crash.
+func (h *Heartbeat) Stop() {
+	close(h.stop)
+
+	h.mu.Lock()
+	vehicles := slices.Clone(h.vehicles)
+	h.mu.Unlock()
+	for _, vehicle := range vehicles {
+		if err := h.release(vehicle); err != nil {
+			log.Println("[Lease] Could not release", vehicle, ":", err)
+		}
+	}
+
+	if err := h.publish(false, true); err != nil {
+		log.Println("[Heartbeat] Could not send the final heartbeat:", err)
+	}
+}
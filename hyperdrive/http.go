@@ -0,0 +1,72 @@
+package hyperdrive
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultHTTPHost is the host the HTTP control endpoints listen on by default: only this machine.
+const DefaultHTTPHost = "localhost"
+
+// CheckHTTPAddr returns an error when addr listens on other hosts than this machine without a
+// token, since anyone on the network could then drive the cars.
+func CheckHTTPAddr(addr, token string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if token != "" || host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%s is reachable from other hosts, it needs a token", addr)
+}
+
+// SameOrigin reports whether a request comes from a page of the server itself, or from a client
+// that is not a browser (no Origin header).
+func SameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// GuardHTTP rejects the requests made by the pages of other sites and, with a token, the requests
+// without it. The token is given as "Authorization: Bearer <token>" or, for browsers and
+// WebSockets, as the token query parameter.
+func GuardHTTP(handler http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !SameOrigin(r) {
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+		if err := checkToken(r, token); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func checkToken(r *http.Request, token string) error {
+	if token == "" {
+		return nil
+	}
+	given := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		given = bearer
+	}
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		return errors.New("missing or invalid token")
+	}
+	return nil
+}
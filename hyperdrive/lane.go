@@ -2,15 +2,15 @@ package hyperdrive
 
 import (
 	"encoding/json"
-	"fmt"
+	"hyperdrive/remote/topic"
 	"log"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 const (
-	LaneTopic       = "RemoteControl/U/E/vehicles/%s/lane"
-	CancelLaneTopic = "RemoteControl/U/E/vehicles/%s/cancelLane"
+	LaneTopic       topic.Template = "RemoteControl/U/E/vehicles/{vehicle}/lane"
+	CancelLaneTopic topic.Template = "RemoteControl/U/E/vehicles/{vehicle}/cancelLane"
 )
 
 // LanePayload correspond à la structure LaneIntentStatus
@@ -19,27 +19,30 @@ type LanePayload struct {
 	Acceleration     float32 `json:"acceleration"`     // {0...2000}
 	Offset           float32 `json:"offset"`           // {-100...100}
 	OffsetFromCenter float32 `json:"offsetFromCenter"` // {-100...100}
+	Controller       string  `json:"controller,omitempty"`
 }
 
 // CancelLanePayload correspond à la structure CancelLaneIntentStatus
 type CancelLanePayload struct {
-	Value bool `json:"value"` // {true|false}
+	Value      bool   `json:"value"` // {true|false}
+	Controller string `json:"controller,omitempty"`
 }
 
 // lane envoie une commande de changement de piste.
 // Le changement est implicite dans les valeurs de OffsetFromCenter ou Offset.
-func lane(client mqtt.Client, velocity float32, acceleration float32, offsetFromCenter float32, offset float32, target string) error {
+func lane(client mqtt.Client, velocity float32, acceleration float32, offsetFromCenter float32, offset float32, target string, controller string) error {
 	payload, err := json.Marshal(LanePayload{
 		Velocity:         velocity,
 		Acceleration:     acceleration,
 		OffsetFromCenter: offsetFromCenter,
 		Offset:           offset,
+		Controller:       controller,
 	})
 	if err != nil {
 		return err
 	}
 
-	laneTopic := fmt.Sprintf(LaneTopic, target)
+	laneTopic := LaneTopic.Format(topic.Values{"vehicle": target})
 	log.Println("[Lane] Sending", string(payload), "on", laneTopic)
 
 	if token := client.Publish(laneTopic, 1, false, payload); token.Wait() && token.Error() != nil {
@@ -50,15 +53,16 @@ func lane(client mqtt.Client, velocity float32, acceleration float32, offsetFrom
 }
 
 // cancelLane envoie un message pour annuler le changement de piste en cours.
-func cancelLane(client mqtt.Client, target string) error {
+func cancelLane(client mqtt.Client, target string, controller string) error {
 	payload, err := json.Marshal(CancelLanePayload{
-		Value: true, // Pour annuler, on envoie généralement true
+		Value:      true, // Pour annuler, on envoie généralement true
+		Controller: controller,
 	})
 	if err != nil {
 		return err
 	}
 
-	cancelLaneTopic := fmt.Sprintf(CancelLaneTopic, target)
+	cancelLaneTopic := CancelLaneTopic.Format(topic.Values{"vehicle": target})
 	log.Println("[Lane] Sending cancel payload on", cancelLaneTopic)
 
 	if token := client.Publish(cancelLaneTopic, 1, false, payload); token.Wait() && token.Error() != nil {
@@ -0,0 +1,51 @@
+package hyperdrive
+
+/*
+Vehicle ownership (leases):
+
+A controller claims a vehicle by publishing a retained claim on its own claim
+topic, and renews it periodically. The Emergency mediator grants a lease to one
+controller at a time and publishes it (retained) on the lease topic of the
+vehicle. The lease runs from the time the mediator receives the claim, and the
+retained claims replayed when it subscribes are ignored: only the renewals of a
+running controller grant a lease. Commands from controllers that do not own the lease are rejected.
+An operator can force-transfer a lease; the previous owner then stops claiming
+the vehicle.
+*/
+
+import (
+	"hyperdrive/remote/topic"
+	"time"
+)
+
+const (
+	LeaseClaimTopic topic.Template = "Emergency/U/E/claims/{vehicle}/{controller}"
+	LeaseTopic      topic.Template = "Emergency/U/E/lease/{vehicle}"
+)
+
+const (
+	LeaseDuration      = 5 * time.Second // a lease expires if it is not renewed within this time
+	LeaseRenewInterval = time.Second
+)
+
+// LeaseClaim is published (retained) by a controller that wants to drive a vehicle.
+// An empty retained message on the claim topic releases the vehicle.
+type LeaseClaim struct {
+	Controller string `json:"controller"`
+	Vehicle    string `json:"vehicle"`
+	Duration   int64  `json:"duration"`  // requested lease duration in milliseconds
+	Timestamp  int64  `json:"timestamp"` // unix milliseconds, informative: the lease runs from the time Emergency receives the claim
+}
+
+// Lease is published (retained) by the Emergency mediator for each owned vehicle.
+type Lease struct {
+	Vehicle string `json:"vehicle"`
+	Owner   string `json:"owner"`
+	Expires int64  `json:"expires"` // unix milliseconds
+	Forced  bool   `json:"forced,omitempty"`
+}
+
+// Active tells whether the lease is still valid.
+func (l Lease) Active() bool {
+	return l.Owner != "" && time.Now().UnixMilli() < l.Expires
+}
@@ -2,14 +2,14 @@ package hyperdrive
 
 import (
 	"encoding/json"
-	"fmt"
+	"hyperdrive/remote/topic"
 	"log"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 const (
-	LightsTopic = "RemoteControl/U/E/vehicles/%s/lights"
+	LightsTopic topic.Template = "RemoteControl/U/E/vehicles/{vehicle}/lights"
 )
 
 type LightEffect struct {
@@ -26,15 +26,17 @@ type LightPayload struct {
 	EngineRed   LightEffect `json:"engineRed"`
 	EngineGreen LightEffect `json:"engineGreen"`
 	EngineBlue  LightEffect `json:"engineBlue"`
+	Controller  string      `json:"controller,omitempty"`
 }
 
-func lights(client mqtt.Client, params LightPayload, target string) error {
+func lights(client mqtt.Client, params LightPayload, target string, controller string) error {
+	params.Controller = controller
 	payload, err := json.Marshal(params)
 	if err != nil {
 		return err
 	}
 
-	lightsTopic := fmt.Sprintf(LightsTopic, target)
+	lightsTopic := LightsTopic.Format(topic.Values{"vehicle": target})
 	log.Println("[Speed] Sending", string(payload), "on", lightsTopic)
 
 	if token := client.Publish(lightsTopic, 1, false, payload); token.Wait() && token.Error() != nil {
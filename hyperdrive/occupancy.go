@@ -0,0 +1,81 @@
+package hyperdrive
+
+import (
+	"encoding/json"
+	"hyperdrive/remote/topic"
+	"log"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// OccupancyTopic carries the retained occupancy map published by the occupancy service.
+	OccupancyTopic = "Occupancy/U/E/map"
+	// OccupancyHoldTopic carries the retained hold of a vehicle that must wait for a reserved piece.
+	OccupancyHoldTopic topic.Template = "Occupancy/U/E/hold/{vehicle}"
+)
+
+// VehiclePosition is the last known position of a vehicle on the track.
+type VehiclePosition struct {
+	Vehicle string `json:"vehicle"`
+	Piece   int    `json:"piece"`
+	Node    string `json:"node"`           // graph node, e.g. "13.curve.outer"
+	Next    int    `json:"next,omitempty"` // predicted next piece (0: unknown)
+	Updated int64  `json:"updated"`        // unix milliseconds
+}
+
+// Reservation gives a reserved piece (crossing, intersection) to one vehicle.
+type Reservation struct {
+	Piece    int      `json:"piece"`
+	Vehicle  string   `json:"vehicle"`
+	Occupied bool     `json:"occupied"`          // the vehicle is on the piece, not only approaching it
+	Waiting  []string `json:"waiting,omitempty"` // vehicles held or slowed for this piece
+}
+
+// OccupancyMap is the live view of which vehicle is where.
+type OccupancyMap struct {
+	Vehicles     []VehiclePosition `json:"vehicles"`
+	Reservations []Reservation     `json:"reservations"`
+	Timestamp    int64             `json:"timestamp"` // unix milliseconds
+}
+
+// OccupancyHold tells a vehicle to stop (Hold) or to slow down (MaxVelocity) before a reserved
+// piece. A hold without Hold and MaxVelocity releases the vehicle.
+type OccupancyHold struct {
+	Vehicle     string  `json:"vehicle"`
+	Hold        bool    `json:"hold"`
+	MaxVelocity float32 `json:"maxVelocity,omitempty"`
+	Piece       int     `json:"piece,omitempty"`  // reserved piece the vehicle waits for
+	Holder      string  `json:"holder,omitempty"` // vehicle that owns the reservation
+}
+
+// Active tells whether the vehicle is held or slowed.
+func (h OccupancyHold) Active() bool {
+	return h.Hold || h.MaxVelocity > 0
+}
+
+// WatchOccupancyHolds calls onHold with the retained hold of every vehicle, then with every update.
+// vehicle restricts the subscription to one vehicle; an empty vehicle watches all of them.
+func WatchOccupancyHolds(client mqtt.Client, vehicle string, onHold func(OccupancyHold)) error {
+	filter := OccupancyHoldTopic.Filter()
+	if vehicle != "" {
+		filter = OccupancyHoldTopic.Format(topic.Values{"vehicle": vehicle})
+	}
+
+	token := client.Subscribe(filter, 1, func(c mqtt.Client, m mqtt.Message) {
+		if len(m.Payload()) == 0 {
+			return // retained hold was cleared
+		}
+
+		var hold OccupancyHold
+		if err := json.Unmarshal(m.Payload(), &hold); err != nil {
+			log.Println("[Occupancy] Could not read the hold:", err)
+			return
+		}
+		onHold(hold)
+	})
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
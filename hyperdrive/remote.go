@@ -8,11 +8,21 @@ The Anki service cans subscribe to any of its branches and will mirror it.
 */
 
 import (
+	"hyperdrive/remote/topic"
 	"log"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+// Topics of the Anki vehicles.
+const (
+	VehicleIntentTopic topic.Template = "Anki/Vehicles/U/{vehicle}/I"
+	VehicleTrackTopic  topic.Template = "Anki/Vehicles/U/{vehicle}/E/track"
+	// VehicleSubscriptionTopic carries the topics a vehicle is subscribed to, per subscription {type}
+	// (speedSubscription, laneSubscription...).
+	VehicleSubscriptionTopic topic.Template = "Anki/Vehicles/U/{vehicle}/S/DIT/{type}"
+)
+
 type Remote struct {
 	Client mqtt.Client
 }
@@ -36,7 +46,7 @@ type SubscriptionPayload struct {
 	Subscribe bool   `json:"subscribe"` // {true|false} # Default: false
 }
 
-func InitializeRemote(client mqtt.Client, vehicleDiscoverTopic string, vehicleSubscriptionTopicFormat string) ([]string, error) {
+func InitializeRemote(client mqtt.Client, vehicleDiscoverTopic string, vehicleIntentTopic topic.Template) ([]string, error) {
 	// start By discovering available vehicles
 	vehicleMap, err := Discover(client, vehicleDiscoverTopic)
 	if err != nil {
@@ -2,31 +2,33 @@ package hyperdrive
 
 import (
 	"encoding/json"
-	"fmt"
+	"hyperdrive/remote/topic"
 	"log"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 const (
-	SpeedTopic = "RemoteControl/U/E/vehicles/%s/speed"
+	SpeedTopic topic.Template = "RemoteControl/U/E/vehicles/{vehicle}/speed"
 )
 
 type SpeedPayload struct {
-	Velocity     float32 `json:"velocity"`     // {-100...1000} # Default: 0
-	Acceleration float32 `json:"acceleration"` // {0...2000} # Default: 0
+	Velocity     float32 `json:"velocity"`             // {-100...1000} # Default: 0
+	Acceleration float32 `json:"acceleration"`         // {0...2000} # Default: 0
+	Controller   string  `json:"controller,omitempty"` // sending controller, removed by the Emergency mediator
 }
 
-func speed(client mqtt.Client, velocity float32, acceleration float32, target string) error {
+func speed(client mqtt.Client, velocity float32, acceleration float32, target string, controller string) error {
 	payload, err := json.Marshal(SpeedPayload{
 		Velocity:     velocity,
 		Acceleration: acceleration,
+		Controller:   controller,
 	})
 	if err != nil {
 		return err
 	}
 
-	speedTopic := fmt.Sprintf(SpeedTopic, target)
+	speedTopic := SpeedTopic.Format(topic.Values{"vehicle": target})
 	log.Println("[Speed] Sending", string(payload), "on", speedTopic)
 
 	if token := client.Publish(speedTopic, 1, false, payload); token.Wait() && token.Error() != nil {
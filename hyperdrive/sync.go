@@ -2,6 +2,9 @@ package hyperdrive
 
 import (
 	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
@@ -16,6 +19,12 @@ type Subscription struct {
 	Subscribe bool   `json:"subscribe"`
 }
 
+// SubscriptionStatus is published by a vehicle on its subscription status topic, for each
+// subscription type: the topics it is subscribed to.
+type SubscriptionStatus struct {
+	Value []string `json:"value"`
+}
+
 // client: a mosquitto client
 // subscriptionType: connect|lights|...
 // subscriptionTargetTopic: topic where to publish the subscription
@@ -40,3 +49,36 @@ func SyncSubscription(client mqtt.Client, subscriptionType string, subscriptionT
 
 	return nil
 }
+
+// ConfirmSubscription sends a subscription like SyncSubscription, then waits until the vehicle lists
+// the topic in the status it publishes on statusTopic (or no longer lists it, to unsubscribe).
+// It returns an error if the vehicle did not confirm within timeout.
+func ConfirmSubscription(client mqtt.Client, subscriptionType string, subscriptionTargetTopic string, statusTopic string, topic string, subscribe bool, timeout time.Duration) error {
+	confirmed := make(chan struct{}, 1)
+	token := client.Subscribe(statusTopic, 1, func(c mqtt.Client, m mqtt.Message) {
+		var status SubscriptionStatus
+		if err := json.Unmarshal(m.Payload(), &status); err != nil {
+			return
+		}
+		if slices.Contains(status.Value, topic) == subscribe {
+			select {
+			case confirmed <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	defer client.Unsubscribe(statusTopic)
+
+	if err := SyncSubscription(client, subscriptionType, subscriptionTargetTopic, topic, subscribe); err != nil {
+		return err
+	}
+	select {
+	case <-confirmed:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("no confirmation on %s within %s", statusTopic, timeout)
+	}
+}
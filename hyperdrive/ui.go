@@ -1,6 +1,10 @@
+//go:build !headless
+
 package hyperdrive
 
 import (
+	"fmt"
+	"hyperdrive/remote/topic"
 	"log"
 	"strings"
 	"time"
@@ -14,7 +18,7 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
-func carCard(client mqtt.Client, target string) fyne.CanvasObject {
+func carCard(client mqtt.Client, heartbeat *Heartbeat, target string) fyne.CanvasObject {
 	var isConnected bool = false
 	var lightPayload = LightPayload{}
 
@@ -40,10 +44,12 @@ func carCard(client mqtt.Client, target string) fyne.CanvasObject {
 		isConnected = !isConnected
 		if isConnected {
 			connectButton.SetText("Disconnect")
-			connect(client, true, target)
+			connect(client, true, target, heartbeat.Controller())
+			heartbeat.AddVehicle(target)
 		} else {
 			connectButton.SetText("Connect")
-			connect(client, false, target)
+			connect(client, false, target, heartbeat.Controller())
+			heartbeat.RemoveVehicle(target)
 		}
 	})
 
@@ -66,7 +72,7 @@ func carCard(client mqtt.Client, target string) fyne.CanvasObject {
 	speedApplyButton := widget.NewButton("Apply", func() {
 		v, _ := velocityBinding.Get()
 		a, _ := accelerationBinding.Get()
-		err := speed(client, float32(v), float32(a), target)
+		err := speed(client, float32(v), float32(a), target, heartbeat.Controller())
 		if err != nil {
 			log.Println("[UI] Could not send speed payload correctly:", err)
 		}
@@ -91,7 +97,7 @@ func carCard(client mqtt.Client, target string) fyne.CanvasObject {
 		o, _ := laneOffsetBinding.Get()
 
 		// Remarque : Utiliser la nouvelle fonction lane(client, target, offsetValue)
-		err := lane(client, float32(v), float32(a), offset, float32(o), target)
+		err := lane(client, float32(v), float32(a), offset, float32(o), target, heartbeat.Controller())
 		if err != nil {
 			log.Println("[UI] Could not send lane payload correctly:", err)
 		}
@@ -118,7 +124,7 @@ func carCard(client mqtt.Client, target string) fyne.CanvasObject {
 	})
 
 	laneCancelButton := widget.NewButton("Cancel", func() {
-		err := cancelLane(client, target)
+		err := cancelLane(client, target, heartbeat.Controller())
 		if err != nil {
 			log.Println("[UI] Could not send lane cancel payload correctly:", err)
 		}
@@ -195,15 +201,37 @@ func carCard(client mqtt.Client, target string) fyne.CanvasObject {
 			lightPayload.EngineBlue = effect
 		}
 
-		err := lights(client, lightPayload, target)
+		err := lights(client, lightPayload, target, heartbeat.Controller())
 		if err != nil {
 			log.Println("[UI] Could not send lights payload correctly:", err)
 		}
 	})
 
+	// --- Ownership ---
+	// The Emergency mediator rejects our commands while another controller owns the car.
+	ownerLabel := widget.NewLabel("Owner: none")
+	heartbeat.OnLease(func(l Lease) {
+		if l.Vehicle != target {
+			return
+		}
+		owner := l.Owner
+		switch {
+		case !l.Active():
+			owner = "none"
+		case owner == heartbeat.Controller():
+			owner = "this remote"
+		case l.Forced:
+			owner += " (transferred by the operator)"
+		}
+		fyne.Do(func() {
+			ownerLabel.SetText("Owner: " + owner)
+		})
+	})
+
 	// --- Assemble Card ---
 	cardContent := container.NewVBox(
 		container.NewCenter(connectButton),
+		container.NewCenter(ownerLabel),
 		widget.NewSeparator(),
 		movementForm,
 		container.NewCenter(speedApplyButton),
@@ -218,12 +246,46 @@ func carCard(client mqtt.Client, target string) fyne.CanvasObject {
 	return widget.NewCard(target, "", cardContent)
 }
 
-func initialPrompt(window fyne.Window, client mqtt.Client) fyne.CanvasObject {
+// EmergencyBanner shows the state published by the Emergency app, so that everyone knows when and
+// why the track is stopped. It stays hidden while the track is running.
+func EmergencyBanner(client mqtt.Client) fyne.CanvasObject {
+	label := widget.NewLabel("")
+	label.Importance = widget.DangerImportance
+	label.TextStyle = fyne.TextStyle{Bold: true}
+	label.Wrapping = fyne.TextWrapWord
+	label.Hide()
+
+	err := WatchEmergencyStatus(client, func(status EmergencyStatus) {
+		since := status.Since().Format("15:04:05")
+		fyne.Do(func() {
+			switch {
+			case status.Stopped:
+				label.SetText(fmt.Sprintf("TRACK STOPPED since %s by %s: %s", since, status.Operator, status.Reason))
+				label.Show()
+			case len(status.StoppedVehicles) > 0:
+				label.SetText(fmt.Sprintf("Stopped since %s by %s: %s (%s)", since, status.Operator, strings.Join(status.StoppedVehicles, ", "), status.Reason))
+				label.Show()
+			default:
+				label.Hide()
+			}
+		})
+	})
+	if err != nil {
+		log.Println("[UI] Could not watch the emergency status:", err)
+	}
+
+	return label
+}
+
+func initialPrompt(window fyne.Window, client mqtt.Client, heartbeat *Heartbeat, banner fyne.CanvasObject) fyne.CanvasObject {
 
 	hostIntentTopicEntry := widget.NewEntry()
 	hostIntentTopicEntry.SetText("Anki/Hosts/U/I")
 	vehicleIntentTopicFormatEntry := widget.NewEntry()
-	vehicleIntentTopicFormatEntry.SetText("Anki/Vehicles/U/%s/I")
+	vehicleIntentTopicFormatEntry.SetText(VehicleIntentTopic.String())
+	vehicleIntentTopicFormatEntry.Validator = func(s string) error {
+		return topic.Template(s).Validate("vehicle")
+	}
 	hostDiscoverVehicleTopicEntry := widget.NewEntry()
 	hostDiscoverVehicleTopicEntry.SetText("Anki/Hosts/U/hyperdrive/E/vehicle/discovered/#")
 
@@ -234,7 +296,7 @@ func initialPrompt(window fyne.Window, client mqtt.Client) fyne.CanvasObject {
 				Widget: hostIntentTopicEntry,
 			},
 			{
-				Text:   "Topic format (where %s is the car id) of the car intents:",
+				Text:   "Topic template (where {vehicle} is the car id) of the car intents:",
 				Widget: vehicleIntentTopicFormatEntry,
 			},
 			{
@@ -253,7 +315,7 @@ func initialPrompt(window fyne.Window, client mqtt.Client) fyne.CanvasObject {
 			// Wait half a second to be sure that the subscription went trhough.
 			time.Sleep(500 * time.Millisecond)
 
-			vehicleList, err := InitializeRemote(client, hostDiscoverVehicleTopicEntry.Text, vehicleIntentTopicFormatEntry.Text)
+			vehicleList, err := InitializeRemote(client, hostDiscoverVehicleTopicEntry.Text, topic.Template(vehicleIntentTopicFormatEntry.Text))
 			if err != nil {
 				log.Fatal("Could not initialize the remote:", err)
 			}
@@ -261,7 +323,7 @@ func initialPrompt(window fyne.Window, client mqtt.Client) fyne.CanvasObject {
 			// Build a list of card widgets, one for each car
 			carCards := []fyne.CanvasObject{}
 			for _, car := range vehicleList {
-				carCards = append(carCards, carCard(client, car))
+				carCards = append(carCards, carCard(client, heartbeat, car))
 			}
 
 			// Place all car cards in a VBox, which is then put in a VScroll
@@ -270,11 +332,12 @@ func initialPrompt(window fyne.Window, client mqtt.Client) fyne.CanvasObject {
 			)
 
 			// replace the form by the cars
-			window.SetContent(content)
+			window.SetContent(container.NewBorder(banner, nil, nil, nil, content))
 
 			window.SetOnClosed(func() {
 				for _, car := range vehicleList {
-					connect(client, false, car) // disconnect each car when closing the app.
+					connect(client, false, car, heartbeat.Controller()) // disconnect each car when closing the app.
+					heartbeat.RemoveVehicle(car)
 				}
 			})
 		},
@@ -285,14 +348,15 @@ func initialPrompt(window fyne.Window, client mqtt.Client) fyne.CanvasObject {
 
 // App is the main Fyne application entry point.
 // This replaces the original App() function.
-func App(client mqtt.Client) {
+func App(client mqtt.Client, heartbeat *Heartbeat) {
 	a := app.New()
 	w := a.NewWindow("Hyperdrive RemoteControl")
 
 	// First, show a form where the user has to insert the different topics
 	// This makes it decoupled (?)
-	form := initialPrompt(w, client)
-	w.SetContent(form)
+	banner := EmergencyBanner(client)
+	form := initialPrompt(w, client, heartbeat, banner)
+	w.SetContent(container.NewBorder(banner, nil, nil, nil, form))
 	w.Resize(fyne.NewSize(450, 700))
 	w.ShowAndRun()
 }
@@ -1,3 +1,5 @@
+//go:build !headless
+
 package main
 
 // Remote-Control for: Discovery (true/false) ✅
@@ -25,7 +27,9 @@ func main() {
 	// Make a new client to send the necessary topic, since it is decoupled
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(rpiIp + ":" + strconv.Itoa(mqttPort))
-	opts.SetClientID(uuid.NewString())
+	controllerID := uuid.NewString()
+	opts.SetClientID(controllerID)
+	hyperdrive.SetHeartbeatWill(opts, controllerID)
 
 	// Connect to the broker and initialize the client
 	client := mqtt.NewClient(opts)
@@ -34,5 +38,10 @@ func main() {
 	}
 	log.Println("Connected to mosquitto broker on", rpiIp+":"+strconv.Itoa(mqttPort))
 
-	hyperdrive.App(client)
+	// Tell the Emergency app we are alive, so that it can stop our cars if we crash.
+	heartbeat := hyperdrive.NewHeartbeat(client, controllerID, hyperdrive.HeartbeatInterval)
+	heartbeat.Start()
+	defer heartbeat.Stop()
+
+	hyperdrive.App(client, heartbeat)
 }
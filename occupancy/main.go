@@ -0,0 +1,114 @@
+// Command occupancy publishes the live occupancy map of the track and reserves the crossing
+// and the intersections: a vehicle approaching a reserved piece is slowed down, and held
+// while another vehicle drives on it.
+package main
+
+import (
+	"flag"
+	"hyperdrive/remote/pathfind/path"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+)
+
+var (
+	brokerHost       = flag.String("broker", "10.42.0.1:1883", "MQTT broker URL")
+	clientIDFlag     = flag.String("id", "", "Client ID of the occupancy service (default: random UUID)")
+	reserveFlag      = flag.String("reserve", "", "Comma separated pieces reserved by one vehicle at a time (default: the crossings and intersections of the track)")
+	slowVelocityFlag = flag.Float64("slow-velocity", 250, "Max velocity of a vehicle approaching a piece that another vehicle is approaching")
+	staleFlag        = flag.Duration("stale", 30*time.Second, "Vehicles without track events for this long are removed from the map, unless they are held")
+	layoutsFlag      = flag.String("layouts", "assets/layouts.yml", "File listing the track layouts")
+	layoutFlag       = flag.String("layout", "", "Name of the track layout (default: the default layout of -layouts)")
+)
+
+// defaultReserved returns the crossing and the intersection pieces of the track.
+func defaultReserved(track *path.Track) []int {
+	pieces := track.Crossings()
+	for _, p := range track.Config.Layout {
+		if p.Shape == "intersection" {
+			pieces = append(pieces, p.ID)
+		}
+	}
+	slices.Sort(pieces)
+	return pieces
+}
+
+func parseReserved(s string) ([]int, error) {
+	var pieces []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		piece, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, piece)
+	}
+	return pieces, nil
+}
+
+func main() {
+	flag.Parse()
+
+	track, err := path.LoadLayout(*layoutsFlag, *layoutFlag)
+	if err != nil {
+		log.Fatal("Could not load the track: ", err)
+	}
+	log.Println("Occupancy: using the track layout", track.Name)
+
+	reserved, err := parseReserved(*reserveFlag)
+	if err != nil {
+		log.Fatal("Invalid -reserve: ", err)
+	}
+	if len(reserved) == 0 {
+		reserved = defaultReserved(track)
+	}
+
+	id := *clientIDFlag
+	if id == "" {
+		id = "Occupancy-" + uuid.NewString()
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(*brokerHost)
+	opts.SetClientID(id)
+	opts.AutoReconnect = true
+	opts.ConnectTimeout = 5 * time.Second
+	opts.OnConnectionLost = func(c mqtt.Client, err error) {
+		log.Printf("MQTT connection lost: %v", err)
+	}
+	opts.OnConnect = func(c mqtt.Client) {
+		log.Printf("MQTT connected (client id=%s)", id)
+	}
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		log.Fatalf("Could not connect to broker: %v", token.Error())
+	}
+
+	adjacency, err := track.Graph.AdjacencyMap()
+	if err != nil {
+		log.Fatal("Unable to generate Adjacency map: ", err)
+	}
+
+	service := NewService(client, track, adjacency, reserved, float32(*slowVelocityFlag), *staleFlag)
+	if err := service.Start(); err != nil {
+		log.Fatal("Could not subscribe to the track events: ", err)
+	}
+	log.Println("Occupancy: reserving pieces", reserved)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+	log.Println("Occupancy: shutting down")
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"hyperdrive/remote/hyperdrive"
+	"hyperdrive/remote/pathfind/instruct"
+	"hyperdrive/remote/pathfind/path"
+	"hyperdrive/remote/topic"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dominikbraun/graph"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type trackPayload struct {
+	Value struct {
+		TrackID       int    `json:"trackID"`
+		TrackLocation int    `json:"trackLocation"`
+		Direction     string `json:"direction"`
+	} `json:"value"`
+}
+
+// vehicleState is what the service knows about one vehicle.
+type vehicleState struct {
+	piece   int
+	node    string
+	located *path.Localizer // position of the vehicle on the graph, between its track events
+	next    int             // predicted next piece (0: unknown)
+	updated time.Time       // last track event
+	entered time.Time       // arrival on piece
+	nextAt  time.Time       // since when next is predicted, the arrival in front of it
+}
+
+// Service combines the positions of every vehicle into an occupancy map and reserves
+// the crossing and the intersections so only one vehicle drives on them at a time.
+type Service struct {
+	client       mqtt.Client
+	track        *path.Track
+	adjacency    map[string]map[string]graph.Edge[string]
+	reserved     []int         // pieces that need a reservation
+	slowVelocity float32       // velocity of a vehicle approaching a piece reserved by an approaching vehicle
+	stale        time.Duration // vehicles without events for this long are forgotten, unless they are held
+
+	mu           sync.Mutex
+	vehicles     map[string]*vehicleState
+	reservations map[int]string                      // piece -> vehicle
+	holds        map[string]hyperdrive.OccupancyHold // last published hold per vehicle
+	commanded    map[string]float64                  // last velocity sent to each vehicle, mm/s
+}
+
+func NewService(client mqtt.Client, track *path.Track, adjacency map[string]map[string]graph.Edge[string], reserved []int, slowVelocity float32, stale time.Duration) *Service {
+	return &Service{
+		client:       client,
+		track:        track,
+		adjacency:    adjacency,
+		reserved:     reserved,
+		slowVelocity: slowVelocity,
+		stale:        stale,
+		vehicles:     map[string]*vehicleState{},
+		reservations: map[int]string{},
+		holds:        map[string]hyperdrive.OccupancyHold{},
+		commanded:    map[string]float64{},
+	}
+}
+
+// Start subscribes to the track events and the speed commands of every vehicle and forgets stale
+// vehicles in the background.
+func (s *Service) Start() error {
+	if token := s.client.Subscribe(hyperdrive.VehicleTrackTopic.Filter(), 1, s.trackHandler); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	if token := s.client.Subscribe(hyperdrive.SpeedTopic.Filter(), 1, s.speedHandler); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.mu.Lock()
+			changed := false
+			for id, v := range s.vehicles {
+				if time.Since(v.updated) > s.stale && !s.holds[id].Active() {
+					log.Println("[Occupancy] Forgetting", id, "after", s.stale, "without track events")
+					delete(s.vehicles, id)
+					changed = true
+				}
+			}
+			s.mu.Unlock()
+			if changed {
+				s.update()
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *Service) trackHandler(c mqtt.Client, m mqtt.Message) {
+	values, ok := hyperdrive.VehicleTrackTopic.Match(m.Topic())
+	if !ok {
+		return
+	}
+	var data []trackPayload
+	if err := json.Unmarshal(m.Payload(), &data); err != nil || len(data) == 0 || data[0].Value.TrackID == 0 {
+		return
+	}
+	vehicle := values["vehicle"]
+	piece := data[0].Value.TrackID
+	node := s.track.PositionNode(piece, data[0].Value.TrackLocation, data[0].Value.Direction)
+
+	s.mu.Lock()
+	v, ok := s.vehicles[vehicle]
+	if !ok {
+		v = &vehicleState{located: path.NewLocalizer(s.track, s.adjacency, s.velocity(vehicle))}
+		s.vehicles[vehicle] = v
+	}
+	previous, previousNext := v.piece, v.next
+	v.piece, v.node, v.updated = piece, node, time.Now()
+	if piece != previous {
+		v.entered = v.updated
+	}
+	v.located.Observe(node, v.updated)
+	v.next = s.predictNext(v, previous)
+	if v.next != previousNext {
+		v.nextAt = v.updated
+	}
+	s.mu.Unlock()
+
+	s.update()
+}
+
+// speedHandler follows the velocity the controllers command, the localizers drive at it.
+func (s *Service) speedHandler(c mqtt.Client, m mqtt.Message) {
+	values, ok := hyperdrive.SpeedTopic.Match(m.Topic())
+	if !ok {
+		return
+	}
+	var data hyperdrive.SpeedPayload
+	if err := json.Unmarshal(m.Payload(), &data); err != nil {
+		return
+	}
+	vehicle := values["vehicle"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commanded[vehicle] = math.Abs(float64(data.Velocity))
+	if v, ok := s.vehicles[vehicle]; ok {
+		v.located.SetVelocity(s.velocity(vehicle), time.Now())
+	}
+}
+
+// velocity returns the velocity a vehicle drives at: the commanded one, the default velocity of
+// the pathfinder before any command, limited while it is slowed down and 0 while it is held.
+// s.mu must be held.
+func (s *Service) velocity(vehicle string) float64 {
+	velocity, ok := s.commanded[vehicle]
+	if !ok {
+		velocity = instruct.VelocityValue
+	}
+	switch hold := s.holds[vehicle]; {
+	case hold.Hold:
+		return 0
+	case hold.MaxVelocity > 0:
+		return math.Min(velocity, float64(hold.MaxVelocity))
+	}
+	return velocity
+}
+
+// predictNext returns the piece the vehicle is about to enter, the most likely next piece of its
+// localizer. The crossings are not in the graph: a vehicle about to drive across one is
+// approaching the crossing, and so is a vehicle next to one that does not come from it when the
+// localizer does not know.
+func (s *Service) predictNext(v *vehicleState, previous int) int {
+	next, _, ok := v.located.NextPiece()
+	for _, crossing := range s.track.Crossings() {
+		neighbours := s.track.GridNeighbours(crossing)
+		if !slices.Contains(neighbours, v.piece) {
+			continue
+		}
+		across := ok && next != v.piece && slices.Contains(neighbours, next)
+		beside := !ok && previous != crossing && previous != v.piece
+		if across || beside {
+			return crossing
+		}
+	}
+	return next
+}
+
+// update recomputes the reservations and the holds, then publishes the occupancy map and the
+// holds that changed.
+func (s *Service) update() {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.vehicles))
+	for id := range s.vehicles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	occupancy := hyperdrive.OccupancyMap{Timestamp: time.Now().UnixMilli()}
+	holds := map[string]hyperdrive.OccupancyHold{}
+
+	for _, piece := range s.reserved {
+		var occupants, approaching []string
+		for _, id := range ids {
+			switch v := s.vehicles[id]; {
+			case v.piece == piece:
+				occupants = append(occupants, id)
+			case v.next == piece:
+				approaching = append(approaching, id)
+			}
+		}
+
+		// The vehicle that arrived first on the piece, or in front of it, gets the reservation.
+		sort.SliceStable(occupants, func(i, j int) bool {
+			return s.vehicles[occupants[i]].entered.Before(s.vehicles[occupants[j]].entered)
+		})
+		sort.SliceStable(approaching, func(i, j int) bool {
+			return s.vehicles[approaching[i]].nextAt.Before(s.vehicles[approaching[j]].nextAt)
+		})
+
+		holder := s.reservations[piece]
+		if !slices.Contains(occupants, holder) && !slices.Contains(approaching, holder) {
+			holder = ""
+		}
+		if holder == "" && len(occupants) > 0 {
+			holder = occupants[0]
+		}
+		if holder == "" && len(approaching) > 0 {
+			holder = approaching[0]
+		}
+		if holder == "" {
+			delete(s.reservations, piece)
+			continue
+		}
+		s.reservations[piece] = holder
+
+		reservation := hyperdrive.Reservation{Piece: piece, Vehicle: holder, Occupied: slices.Contains(occupants, holder)}
+		for _, id := range append(occupants, approaching...) {
+			if id == holder {
+				continue
+			}
+			reservation.Waiting = append(reservation.Waiting, id)
+			hold := hyperdrive.OccupancyHold{Vehicle: id, Piece: piece, Holder: holder}
+			if reservation.Occupied {
+				hold.Hold = true
+			} else {
+				hold.MaxVelocity = s.slowVelocity
+			}
+			// A hold wins over a slow down for another piece.
+			if previous, ok := holds[id]; !ok || (hold.Hold && !previous.Hold) {
+				holds[id] = hold
+			}
+		}
+		occupancy.Reservations = append(occupancy.Reservations, reservation)
+	}
+
+	for _, id := range ids {
+		v := s.vehicles[id]
+		occupancy.Vehicles = append(occupancy.Vehicles, hyperdrive.VehiclePosition{
+			Vehicle: id,
+			Piece:   v.piece,
+			Node:    v.node,
+			Next:    v.next,
+			Updated: v.updated.UnixMilli(),
+		})
+	}
+
+	// Only publish the holds that changed, and release the vehicles that are no longer held.
+	var changed []hyperdrive.OccupancyHold
+	for id, hold := range holds {
+		if s.holds[id] != hold {
+			changed = append(changed, hold)
+		}
+	}
+	for id := range s.holds {
+		if _, ok := holds[id]; !ok {
+			changed = append(changed, hyperdrive.OccupancyHold{Vehicle: id})
+		}
+	}
+	s.holds = holds
+	now := time.Now()
+	for _, hold := range changed {
+		if v, ok := s.vehicles[hold.Vehicle]; ok {
+			v.located.SetVelocity(s.velocity(hold.Vehicle), now)
+		}
+	}
+	s.mu.Unlock()
+
+	s.publish(hyperdrive.OccupancyTopic, occupancy)
+	for _, hold := range changed {
+		if hold.Active() {
+			log.Printf("[Occupancy] %s waits for piece %d, reserved by %s (hold: %v, max velocity: %.0f)", hold.Vehicle, hold.Piece, hold.Holder, hold.Hold, hold.MaxVelocity)
+		} else {
+			log.Println("[Occupancy] Releasing", hold.Vehicle)
+		}
+		s.publish(hyperdrive.OccupancyHoldTopic.Format(topic.Values{"vehicle": hold.Vehicle}), hold)
+	}
+}
+
+func (s *Service) publish(topic string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("[Occupancy] Could not marshal payload for", topic, ":", err)
+		return
+	}
+	if token := s.client.Publish(topic, 1, true, data); token.Wait() && token.Error() != nil {
+		log.Println("[Occupancy] Could not publish on", topic, ":", token.Error())
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"hyperdrive/remote/hyperdrive"
+	"hyperdrive/remote/pathfind/path"
+	"hyperdrive/remote/topic"
+	"log"
+	"net/http"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// serveMissions serves the missions of the vehicles on addr: POST /vehicles/{id}/mission submits
+// a mission (JSON, see path.Mission), DELETE cancels it and GET returns its progress. The missions
+// go through MQTT like the ones published directly on the mission topics. With a token, every
+// request must carry it; without one, addr must be on this machine.
+func serveMissions(client mqtt.Client, addr, token string) error {
+	if err := hyperdrive.CheckHTTPAddr(addr, token); err != nil {
+		return err
+	}
+
+	var (
+		mu       sync.Mutex
+		progress = map[string]path.MissionProgress{}
+	)
+	filter := path.MissionProgressTopic.Filter()
+	if token := client.Subscribe(filter, 1, func(c mqtt.Client, m mqtt.Message) {
+		values, ok := path.MissionProgressTopic.Match(m.Topic())
+		if !ok {
+			return
+		}
+		var p path.MissionProgress
+		if err := json.Unmarshal(m.Payload(), &p); err != nil {
+			return
+		}
+		mu.Lock()
+		progress[values["vehicle"]] = p
+		mu.Unlock()
+	}); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	submit := func(w http.ResponseWriter, r *http.Request, mission path.Mission) {
+		data, _ := json.Marshal(mission)
+		t := path.MissionTopic.Format(topic.Values{"vehicle": r.PathValue("id")})
+		if token := client.Publish(t, 1, false, data); token.Wait() && token.Error() != nil {
+			http.Error(w, token.Error().Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /vehicles/{id}/mission", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		p, ok := progress[r.PathValue("id")]
+		mu.Unlock()
+		if !ok {
+			http.Error(w, "no mission for this vehicle", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(p); err != nil {
+			log.Println("[HTTP] Could not write the mission progress:", err)
+		}
+	})
+	mux.HandleFunc("POST /vehicles/{id}/mission", func(w http.ResponseWriter, r *http.Request) {
+		var mission path.Mission
+		if err := json.NewDecoder(r.Body).Decode(&mission); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Println("[HTTP] Mission for", r.PathValue("id"), "submitted by", r.RemoteAddr)
+		submit(w, r, mission)
+	})
+	mux.HandleFunc("DELETE /vehicles/{id}/mission", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("[HTTP] Mission of", r.PathValue("id"), "cancelled by", r.RemoteAddr)
+		submit(w, r, path.Mission{})
+	})
+
+	log.Println("[HTTP] Serving the missions on", addr)
+	return http.ListenAndServe(addr, hyperdrive.GuardHTTP(mux, token))
+}
@@ -2,96 +2,160 @@ package instruct
 
 import (
 	"encoding/json"
-	"fmt"
 	"hyperdrive/remote/hyperdrive"
 	"hyperdrive/remote/pathfind/util"
+	"hyperdrive/remote/topic"
 	"log"
+	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
-const (
-	laneTopic         = util.RootTopic + "/lane"
-	speedTopic        = util.RootTopic + "/speed"
-	connectTopic      = util.RootTopic + "/connect"
-	InstructionTopic  = util.RootTopic + "/instruction"
-	AnkiVehicleIntent = "Anki/Vehicles/U/%s/I"
+// InstructionTopic carries the instructions of the pathfinder for a vehicle, {vehicle} is the car ID.
+// The commands themselves go to the RemoteControl topics, mediated by the Emergency app like the
+// commands of any other controller.
+const InstructionTopic topic.Template = util.RootTopic + "/{vehicle}/instruction"
 
+const (
 	LaneDirValue      = 68
 	AccelerationValue = 200
 	VelocityValue     = 200
 	NegVelocityValue  = -100
 )
 
-type LanePayload struct {
-	Velocity         float32 `json:"velocity"`
-	Acceleration     float32 `json:"acceleration"`
-	Offset           float32 `json:"offset"`
-	OffsetFromCenter float32 `json:"offsetFromCenter"`
+type LaneChangeMessage struct {
+	LaneChange string  `json:"lane_change"`
+	Forward    bool    `json:"forward"`
+	Wait       float64 `json:"wait,omitempty"` // seconds to stay stopped before following the instruction
 }
 
-type SpeedPayload struct {
-	Velocity     float32 `json:"velocity"`
-	Acceleration float32 `json:"acceleration"`
+// vehicle sends the instructions of one vehicle.
+type vehicle struct {
+	client     mqtt.Client
+	id         string
+	controller string // controller ID sent with the commands, checked against the lease by the mediator
+
+	mu   sync.Mutex
+	hold hyperdrive.OccupancyHold // hold of the vehicle published by the occupancy service
 }
 
-type LaneChangeMessage struct {
-	LaneChange string `json:"lane_change"`
-	Forward    bool   `json:"forward"`
+// topic returns the topic of the vehicle for a per-vehicle template.
+func (v *vehicle) topic(t topic.Template) string {
+	return t.Format(topic.Values{"vehicle": v.id})
 }
 
-func lane_change(
-	client mqtt.Client,
+func (v *vehicle) laneChange(
 	velocity float32,
 	acceleration float32,
 	offsetFromCenter float32,
 	offset float32,
 ) error {
-	payload, err := json.Marshal(LanePayload{
+	payload, err := json.Marshal(hyperdrive.LanePayload{
 		Velocity:         velocity,
 		Acceleration:     acceleration,
 		OffsetFromCenter: offsetFromCenter,
 		Offset:           offset,
+		Controller:       v.controller,
 	})
 	if err != nil {
 		return err
 	}
 
-	log.Println("[Lane] Sending", string(payload), "on", laneTopic)
+	log.Println("[Lane] Sending", string(payload), "on", v.topic(hyperdrive.LaneTopic))
 
-	if token := client.Publish(laneTopic, 1, false, payload); token.Wait() && token.Error() != nil {
+	if token := v.client.Publish(v.topic(hyperdrive.LaneTopic), 1, false, payload); token.Wait() && token.Error() != nil {
 		return token.Error()
 	}
 	return nil
 }
 
-func speed(client mqtt.Client, velocity float32, acceleration float32) error {
-	payload, err := json.Marshal(SpeedPayload{
+func (v *vehicle) speed(velocity float32, acceleration float32) error {
+	payload, err := json.Marshal(hyperdrive.SpeedPayload{
 		Velocity:     velocity,
 		Acceleration: acceleration,
+		Controller:   v.controller,
 	})
 	if err != nil {
 		return err
 	}
 
-	log.Println("[Speed] Sending", string(payload), "on", speedTopic)
+	log.Println("[Speed] Sending", string(payload), "on", v.topic(hyperdrive.SpeedTopic))
 
-	if token := client.Publish(speedTopic, 1, false, payload); token.Wait() && token.Error() != nil {
+	if token := v.client.Publish(v.topic(hyperdrive.SpeedTopic), 1, false, payload); token.Wait() && token.Error() != nil {
 		return token.Error()
 	}
 	return nil
 }
 
-func laneChangeHandler(client mqtt.Client, msg mqtt.Message) {
+// allowedVelocity applies the occupancy hold to a velocity: 0 while the vehicle is held,
+// at most MaxVelocity while it is slowed down.
+func (v *vehicle) allowedVelocity(velocity float32) float32 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.hold.Hold {
+		return 0
+	}
+	if v.hold.MaxVelocity > 0 && velocity > v.hold.MaxVelocity {
+		return v.hold.MaxVelocity
+	}
+	if v.hold.MaxVelocity > 0 && velocity < -v.hold.MaxVelocity {
+		return -v.hold.MaxVelocity
+	}
+	return velocity
+}
+
+// watchOccupancy slows down or holds the vehicle before the reserved pieces of the occupancy service.
+func (v *vehicle) watchOccupancy() {
+	err := hyperdrive.WatchOccupancyHolds(v.client, v.id, func(hold hyperdrive.OccupancyHold) {
+		v.mu.Lock()
+		changed := v.hold != hold
+		v.hold = hold
+		v.mu.Unlock()
+		if !changed {
+			return
+		}
+
+		log.Printf("[Occupancy] %s hold: %v, max velocity: %.0f, piece: %d, reserved by: %s", v.id, hold.Hold, hold.MaxVelocity, hold.Piece, hold.Holder)
+		if err := v.speed(v.allowedVelocity(VelocityValue), AccelerationValue); err != nil {
+			log.Println("[Speed] Error sending speed command:", err)
+		}
+	})
+	if err != nil {
+		log.Println("[Occupancy] Could not watch the holds, driving without reservations:", err)
+	}
+}
+
+func (v *vehicle) laneChangeHandler(client mqtt.Client, msg mqtt.Message) {
 	var lcMsg LaneChangeMessage
 	if err := json.Unmarshal(msg.Payload(), &lcMsg); err != nil {
 		log.Println("[LaneChange] Error decoding message:", err)
 		return
 	}
 
-	log.Println("[LaneChange] Lane change:", lcMsg.LaneChange)
-	log.Println("[LaneChange] Forward:", lcMsg.Forward)
+	// The planner makes the vehicle wait while the piece ahead is reserved by another one.
+	if lcMsg.Wait > 0 {
+		log.Printf("[LaneChange] %s waits %.1fs before going on", v.id, lcMsg.Wait)
+		if err := v.speed(0, AccelerationValue); err != nil {
+			log.Println("[Speed] Error sending speed command:", err)
+		}
+		wait := time.Duration(lcMsg.Wait * float64(time.Second))
+		lcMsg.Wait = 0
+		time.AfterFunc(wait, func() {
+			if err := v.speed(v.allowedVelocity(VelocityValue), AccelerationValue); err != nil {
+				log.Println("[Speed] Error sending speed command:", err)
+			}
+			v.follow(lcMsg)
+		})
+		return
+	}
+	v.follow(lcMsg)
+}
+
+// follow sends the lane change or the reversal of an instruction.
+func (v *vehicle) follow(lcMsg LaneChangeMessage) {
+	log.Println("[LaneChange]", v.id, "lane change:", lcMsg.LaneChange)
+	log.Println("[LaneChange]", v.id, "forward:", lcMsg.Forward)
 
 	// Forward lane change
 	if lcMsg.Forward {
@@ -104,9 +168,8 @@ func laneChangeHandler(client mqtt.Client, msg mqtt.Message) {
 			offsetFromCenter = LaneDirValue
 		}
 
-		if err := lane_change(
-			client,
-			VelocityValue,
+		if err := v.laneChange(
+			v.allowedVelocity(VelocityValue),
 			AccelerationValue,
 			float32(offsetFromCenter),
 			0,
@@ -117,72 +180,45 @@ func laneChangeHandler(client mqtt.Client, msg mqtt.Message) {
 
 	// Reverse / stop
 	if !lcMsg.Forward {
-		if err := speed(client, NegVelocityValue, AccelerationValue); err != nil {
+		if err := v.speed(v.allowedVelocity(NegVelocityValue), AccelerationValue); err != nil {
 			log.Println("[Speed] Error sending speed command:", err)
 		}
 	}
 }
 
-func subscribeLaneChange(client mqtt.Client) {
-	if token := client.Subscribe(InstructionTopic, 1, laneChangeHandler); token.Wait() && token.Error() != nil {
-		log.Fatal("[LaneChange] Subscribe error:", token.Error())
+func (v *vehicle) subscribeLaneChange() {
+	instructionTopic := v.topic(InstructionTopic)
+	if token := v.client.Subscribe(instructionTopic, 1, v.laneChangeHandler); token.Wait() && token.Error() != nil {
+		log.Println("[LaneChange] Subscribe error:", token.Error())
+		return
 	}
-	log.Println("[LaneChange] Subscribed to topic:", InstructionTopic)
+	log.Println("[LaneChange] Subscribed to topic:", instructionTopic)
 }
 
-func connectEverything(client mqtt.Client, id string) {
-	// stepCh := make(chan struct{})
-	// baseAnkiSub := "Anki/Vehicles/U/%s/S/DIT/%s"
-	// client.Subscribe(fmt.Sprintf(baseAnkiSub, id, "connectSubscription"), 1, func(c mqtt.Client, m mqtt.Message) {
-	// 	var data map[string]any
-	// 	json.Unmarshal(m.Payload(), &data)
-	// 	if data["value"] != nil && slices.Contains(data["value"].([]string), connectTopic) {
-	// 		stepCh <- struct{}{}
-	// 	}
-	// })
-	hyperdrive.SyncSubscription(client, "connectSubscription", fmt.Sprintf(AnkiVehicleIntent, id), connectTopic, true)
-	// <-stepCh // wait for the subscription to go through
-
-	// client.Subscribe(fmt.Sprintf(baseAnkiSub, id, "speedSubscription"), 1, func(c mqtt.Client, m mqtt.Message) {
-	// 	var data map[string]any
-	// 	json.Unmarshal(m.Payload(), &data)
-	// 	if slices.Contains(data["value"].([]string), speedTopic) {
-	// 		stepCh <- struct{}{}
-	// 	}
-	// })
-	hyperdrive.SyncSubscription(client, "speedSubscription", fmt.Sprintf(AnkiVehicleIntent, id), speedTopic, true)
-	// <-stepCh
-
-	// client.Subscribe(fmt.Sprintf(baseAnkiSub, id, "laneSubscription"), 1, func(c mqtt.Client, m mqtt.Message) {
-	// 	var data map[string]any
-	// 	json.Unmarshal(m.Payload(), &data)
-	// 	if slices.Contains(data["value"].([]string), laneTopic) {
-	// 		stepCh <- struct{}{}
-	// 	}
-	// })
-	hyperdrive.SyncSubscription(client, "laneSubscription", fmt.Sprintf(AnkiVehicleIntent, id), connectTopic, true)
-	// <-stepCh
-
-	// Subscriptions are unreliable and don't work. Waiting a second instead.
-	time.Sleep(1 * time.Second)
-}
+// InstructionProcess connects the vehicle and follows the instructions of the pathfinder until done is closed.
+// The vehicle is then stopped and its lease released.
+func InstructionProcess(client mqtt.Client, heartbeat *hyperdrive.Heartbeat, vehicleID string, done <-chan struct{}) {
+	v := &vehicle{client: client, id: vehicleID, controller: heartbeat.Controller()}
+	heartbeat.AddVehicle(vehicleID)
 
-func InstructionProcess(client mqtt.Client) {
-	// 1. get vehicle ID from UI
-	vehicleID := util.WaitForVehicleID(client)
-
-	// 2. Publish necessary instructions for DIT to work (connect, speed, lane)
-	connectEverything(client, vehicleID)
-
-	// 3. Connect to the vehicle and publish initial speed instruction
-	data, _ := json.Marshal(hyperdrive.ConnectPayload{Value: true})
-	client.Publish(connectTopic, 1, false, data)
+	// 1. Connect to the vehicle through the mediator, which subscribes the vehicle to its topics on
+	// the first message of each type, then publish the initial speed instruction.
+	data, _ := json.Marshal(hyperdrive.ConnectPayload{Value: true, Controller: v.controller})
+	client.Publish(v.topic(hyperdrive.ConnectTopic), 1, false, data)
 	time.Sleep(2 * time.Second)
-	speed(client, VelocityValue, AccelerationValue)
+	v.watchOccupancy()
+	v.speed(v.allowedVelocity(VelocityValue), AccelerationValue)
 
-	subscribeLaneChange(client)
+	// 2. Follow the instructions of the pathfinder.
+	v.subscribeLaneChange()
 
-	select {} // block indefinetely
+	<-done
+	client.Unsubscribe(v.topic(InstructionTopic), hyperdrive.OccupancyHoldTopic.Format(topic.Values{"vehicle": vehicleID}))
+	if err := v.speed(0, AccelerationValue); err != nil {
+		log.Println("[Speed] Error stopping", vehicleID, ":", err)
+	}
+	heartbeat.RemoveVehicle(vehicleID)
+	log.Println("[Instruct] Stopped instructing", vehicleID)
 }
 
 func main() {
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"hyperdrive/remote/hyperdrive"
 	"hyperdrive/remote/pathfind/instruct"
 	"hyperdrive/remote/pathfind/path"
+	"hyperdrive/remote/pathfind/util"
 	"log"
+	"os"
 	"strconv"
+	"sync"
 
-	"github.com/dominikbraun/graph"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/google/uuid"
 )
@@ -18,33 +22,157 @@ const (
 	// rpiIp    = "test.mosquitto.org"
 )
 
+var (
+	layoutsFlag = flag.String("layouts", "assets/layouts.yml", "File listing the track layouts")
+	layoutFlag  = flag.String("layout", "", "Name of the track layout (default: the default layout of -layouts)")
+	dotFlag     = flag.String("dot", "", "Write the track graph to this Graphviz file, e.g. assets/track-graph.gv")
+	httpFlag    = flag.String("http", "", "Address of the HTTP mission endpoints, e.g. localhost:8081 (empty: disabled, missions over MQTT only)")
+	tokenFlag   = flag.String("http-token", os.Getenv("PATHFIND_HTTP_TOKEN"), "Token required by the HTTP mission endpoints, needed to listen on other hosts than localhost")
+	plannerFlag = flag.String("planner", "cooperative", "Route planning: cooperative (all the cars together, with reservations) or single (each car on its own)")
+)
+
+// vehicleProcesses is one generation of the processes of a vehicle, from its addition to its removal.
+type vehicleProcesses struct {
+	done    chan struct{} // closed when the vehicle is removed
+	stopped chan struct{} // closed once every process returned
+}
+
 func main() {
+	flag.Parse()
+
+	track, err := path.LoadLayout(*layoutsFlag, *layoutFlag)
+	if err != nil {
+		log.Fatal("Could not load the track: ", err)
+	}
+	log.Println("Using the track layout", track.Name)
+	if *dotFlag != "" {
+		if err := track.ExportDOT(*dotFlag); err != nil {
+			log.Fatal("Could not write the track graph: ", err)
+		}
+	}
+	g := track.Graph
+
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(rpiIp + ":" + strconv.Itoa(mqttPort))
-	opts.SetClientID(uuid.NewString())
+	controllerID := uuid.NewString()
+	opts.SetClientID(controllerID)
+	hyperdrive.SetHeartbeatWill(opts, controllerID)
 
 	// Connect to the broker and initialize the client
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatal("Could not establish connection with MQTT server: ", token.Error())
-	}
-	log.Println("Connected to mosquitto broker on", rpiIp+":"+strconv.Itoa(mqttPort))
+	client := connect(opts)
 
 	// We need a second client since the same client can't listen to the same topic twice.
 	opts.SetClientID(uuid.NewString())
-	vehicleClient := mqtt.NewClient(opts)
-	if token := vehicleClient.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatal("Could not establish connection with MQTT server: ", token.Error())
-	}
-	log.Println("Connected to mosquitto broker on", rpiIp+":"+strconv.Itoa(mqttPort))
+	opts.UnsetWill() // only the client sending instructions is watched by the Emergency app
+	vehicleClient := connect(opts)
 
-	g := path.ImportYaml()
-	p, _ := graph.ShortestPath(g, "13.curve.outer", "03.intersection.high")
+	// The missions follow the position of their vehicle, like the path calculation of -planner single.
+	opts.SetClientID(uuid.NewString())
+	missionClient := connect(opts)
+
+	p, _ := path.ShortestRoute(g, path.DirectedNode("13.curve.outer", path.Forward), "03.intersection.high")
 	fmt.Println(p)
 
-	go path.PathCalculation(client, g)
-	go path.VehicleTracking(vehicleClient, g)
-	go instruct.InstructionProcess(client)
+	var planner *path.CooperativePlanner
+	switch *plannerFlag {
+	case "cooperative":
+		// The planner follows the target and position filters of every vehicle, like the map of the UI.
+		opts.SetClientID(uuid.NewString())
+		planner, err = path.NewCooperativePlanner(connect(opts), track, instruct.VelocityValue)
+		if err != nil {
+			log.Fatal("Could not create the planner: ", err)
+		}
+		go planner.Run(make(chan struct{}))
+	case "single":
+	default:
+		log.Fatal("Unknown -planner ", *plannerFlag, ", expected cooperative or single")
+	}
+
+	heartbeat := hyperdrive.NewHeartbeat(client, controllerID, hyperdrive.HeartbeatInterval)
+	heartbeat.Start()
+	defer heartbeat.Stop()
+
+	// Every vehicle added in the UI gets its own path calculation, tracking and instructions,
+	// stopped again when the vehicle is removed. A vehicle added again waits for the processes
+	// of its previous generation to return, as they unsubscribe the same topics.
+	var (
+		mu       sync.Mutex
+		vehicles = map[string]*vehicleProcesses{}
+		stopping = map[string]<-chan struct{}{} // stopped channel of the last removed generation
+	)
+	err = util.WatchVehicles(client, func(id string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := vehicles[id]; ok {
+			return
+		}
+		p := &vehicleProcesses{done: make(chan struct{}), stopped: make(chan struct{})}
+		vehicles[id] = p
+		previous := stopping[id]
+		delete(stopping, id)
+		log.Println("Adding vehicle", id)
 
-	path.UI(client)
+		go func() {
+			defer close(p.stopped)
+			if previous != nil {
+				<-previous
+			}
+			select {
+			case <-p.done:
+				return
+			default:
+			}
+
+			var wg sync.WaitGroup
+			start := func(process func()) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					process()
+				}()
+			}
+			if planner == nil {
+				start(func() { path.PathCalculation(client, track, id, p.done) })
+			}
+			start(func() { path.VehicleTracking(vehicleClient, track, id, p.done) })
+			start(func() { path.MissionProcess(missionClient, track, id, p.done) })
+			start(func() { instruct.InstructionProcess(client, heartbeat, id, p.done) })
+			wg.Wait()
+		}()
+	}, func(id string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if p, ok := vehicles[id]; ok {
+			log.Println("Removing vehicle", id)
+			close(p.done)
+			delete(vehicles, id)
+			stopping[id] = p.stopped
+			if planner != nil {
+				planner.RemoveVehicle(id)
+			}
+		}
+	})
+	if err != nil {
+		log.Fatal("Could not subscribe to ", util.VehicleIDTopic, ": ", err)
+	}
+
+	if *httpFlag != "" {
+		go func() {
+			if err := serveMissions(client, *httpFlag, *tokenFlag); err != nil {
+				log.Println("[HTTP] Mission endpoints stopped:", err)
+			}
+		}()
+	}
+
+	path.UI(client, track)
+}
+
+// connect connects a client to the broker, or exits.
+func connect(opts *mqtt.ClientOptions) mqtt.Client {
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		log.Fatal("Could not establish connection with MQTT server: ", token.Error())
+	}
+	log.Println("Connected to mosquitto broker on", rpiIp+":"+strconv.Itoa(mqttPort))
+	return client
 }
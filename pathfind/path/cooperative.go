@@ -0,0 +1,258 @@
+package path
+
+import (
+	"encoding/json"
+	"hyperdrive/remote/pathfind/util"
+	"hyperdrive/remote/topic"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// lateTolerance is how late a vehicle may reach the steps of its plan before all the vehicles are replanned.
+const lateTolerance = 3 * time.Second
+
+// blockedWait is how long a vehicle without route stays stopped, until it is planned again.
+const blockedWait = 2 * time.Second
+
+// planEvent is a change of a vehicle seen by the cooperative planner.
+type planEvent struct {
+	vehicle          string
+	position, target string // the one that changed, the other is empty
+	remove           bool
+}
+
+// plannedVehicle is what the cooperative planner knows about a vehicle.
+type plannedVehicle struct {
+	position, target string
+	route            []PlannedStep // nil without route
+	start            time.Time     // start of the plan the times of the route are relative to
+	step             int           // index of the position in the route
+	blocked          bool          // no route was found, the vehicle waits on its position
+}
+
+// CooperativePlanner plans the routes of all the vehicles together, so that they never hold the
+// same piece at the same time, and publishes the next step of each on its nextStep topic. The
+// vehicles are replanned when a target changes, and when a vehicle leaves its route or falls behind.
+// It subscribes to the target and position filters, which the map also follows: give it its own client.
+type CooperativePlanner struct {
+	client   mqtt.Client
+	track    *Track
+	planner  *Planner
+	vehicles map[string]*plannedVehicle
+
+	// The MQTT handlers and RemoveVehicle queue the events without waiting for a planning in progress.
+	mu      sync.Mutex
+	pending []planEvent
+	wake    chan struct{}
+}
+
+func NewCooperativePlanner(client mqtt.Client, track *Track, velocity float64) (*CooperativePlanner, error) {
+	planner, err := NewPlanner(track, velocity)
+	if err != nil {
+		return nil, err
+	}
+	return &CooperativePlanner{
+		client:   client,
+		track:    track,
+		planner:  planner,
+		vehicles: map[string]*plannedVehicle{},
+		wake:     make(chan struct{}, 1),
+	}, nil
+}
+
+// RemoveVehicle forgets a vehicle and frees its reservations.
+func (c *CooperativePlanner) RemoveVehicle(id string) {
+	c.post(planEvent{vehicle: id, remove: true})
+}
+
+// post queues an event for Run without blocking. A new position of a vehicle replaces the one
+// still queued, the planner only needs the last one.
+func (c *CooperativePlanner) post(e planEvent) {
+	c.mu.Lock()
+	i := -1
+	if e.position != "" {
+		i = slices.IndexFunc(c.pending, func(p planEvent) bool { return p.vehicle == e.vehicle && p.position != "" })
+	}
+	if i >= 0 {
+		c.pending[i] = e
+	} else {
+		c.pending = append(c.pending, e)
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run follows the targets and the positions of every vehicle and plans their routes, until done is closed.
+func (c *CooperativePlanner) Run(done <-chan struct{}) {
+	targetFilter := vehicleTargetTopic.Filter()
+	if token := c.client.Subscribe(targetFilter, 1, func(client mqtt.Client, m mqtt.Message) {
+		values, ok := vehicleTargetTopic.Match(m.Topic())
+		if !ok {
+			return
+		}
+		var data tilePayload
+		if err := json.Unmarshal(m.Payload(), &data); err != nil {
+			log.Println("[Planner] Could not unmarshal target:", string(m.Payload()))
+			return
+		}
+		segment, ok := c.track.TargetSegment(data.ID)
+		if !ok {
+			log.Println("[Planner] It is not allowed to stop on piece", data.ID, ", ignoring the target.")
+			return
+		}
+		c.post(planEvent{vehicle: values["vehicle"], target: segment})
+	}); token.Wait() && token.Error() != nil {
+		log.Println("[Planner] Could not subscribe to", targetFilter, ":", token.Error())
+		return
+	}
+	positionFilter := vehiclePositionTopic.Filter()
+	if token := c.client.Subscribe(positionFilter, 1, func(client mqtt.Client, m mqtt.Message) {
+		values, ok := vehiclePositionTopic.Match(m.Topic())
+		if !ok {
+			return
+		}
+		var data positionPayload
+		if err := json.Unmarshal(m.Payload(), &data); err != nil || data.ID == "" {
+			return
+		}
+		c.post(planEvent{vehicle: values["vehicle"], position: data.ID})
+	}); token.Wait() && token.Error() != nil {
+		log.Println("[Planner] Could not subscribe to", positionFilter, ":", token.Error())
+		return
+	}
+	defer c.client.Unsubscribe(targetFilter, positionFilter)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+
+		case <-c.wake:
+			c.mu.Lock()
+			events := c.pending
+			c.pending = nil
+			c.mu.Unlock()
+			for _, e := range events {
+				c.handle(e)
+			}
+
+		case now := <-ticker.C:
+			for id, v := range c.vehicles {
+				if v.blocked {
+					c.replan()
+					break
+				}
+				if v.route != nil && v.step < len(v.route)-1 && now.Sub(v.start) > seconds(v.route[v.step].Leave)+lateTolerance {
+					log.Println("[Planner]", id, "is late on", v.position, ", replanning")
+					c.replan()
+					break
+				}
+			}
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func (c *CooperativePlanner) handle(e planEvent) {
+	if e.remove {
+		if _, ok := c.vehicles[e.vehicle]; ok {
+			delete(c.vehicles, e.vehicle)
+			c.replan()
+		}
+		return
+	}
+
+	v, ok := c.vehicles[e.vehicle]
+	if !ok {
+		v = &plannedVehicle{}
+		c.vehicles[e.vehicle] = v
+	}
+	if e.target != "" {
+		log.Println("[Planner]", e.vehicle, "got a new target:", e.target)
+		v.target = e.target
+		c.replan()
+		return
+	}
+	if e.position == v.position {
+		return
+	}
+	v.position = e.position
+	if v.target == "" {
+		return
+	}
+	if v.route != nil {
+		for k := v.step + 1; k < len(v.route); k++ {
+			if v.route[k].Node == v.position {
+				v.step = k
+				c.publish(e.vehicle, v)
+				return
+			}
+		}
+		log.Println("[Planner]", e.vehicle, "left its route at", v.position, ", replanning")
+	}
+	c.replan()
+}
+
+// replan plans the routes of all the vehicles from their current positions.
+func (c *CooperativePlanner) replan() {
+	var agents []Agent
+	for id, v := range c.vehicles {
+		if v.position != "" {
+			agents = append(agents, Agent{id, v.position, v.target})
+		}
+	}
+	routes, table, failed := c.planner.PlanAll(agents)
+	log.Printf("[Planner] Planned %d routes, %d reservations", len(routes), len(table.All()))
+
+	now := time.Now()
+	for id, v := range c.vehicles {
+		v.route, v.start, v.step = routes[id], now, 0
+		v.blocked = slices.Contains(failed, id)
+		switch {
+		case v.route != nil:
+			c.publish(id, v)
+		case v.blocked:
+			// Stopped on its position until the next attempt, every second.
+			log.Println("[Planner] No route without conflicts for", id, "within", c.planner.Horizon, "s, it waits on", v.position)
+			util.SendJSON(c.client, nextStepTopic.Format(topic.Values{"vehicle": id}), nextStepPayload{NextStep: v.position, Wait: blockedWait.Seconds()})
+		}
+	}
+}
+
+// publish sends the rest of the route of a vehicle and its next step, or that it arrived.
+func (c *CooperativePlanner) publish(id string, v *plannedVehicle) {
+	values := topic.Values{"vehicle": id}
+	steps := v.route[v.step:]
+	nodes := make([]string, len(steps))
+	for i, s := range steps {
+		nodes[i] = s.Node
+	}
+	util.SendJSON(c.client, routeTopic.Format(values), routePayload{nodes})
+
+	if len(steps) <= 1 {
+		util.SendJSON(c.client, arrivedTopic.Format(values), arrivedPayload{true})
+		return
+	}
+	distance, err := PathLength(c.track.Graph, nodes)
+	if err != nil {
+		log.Println("[Planner] Could not compute the length of", nodes, ":", err)
+	}
+	eta := math.Max(0, steps[len(steps)-1].Enter-time.Since(v.start).Seconds())
+	payload := nextStepPayload{NextStep: nodes[1], Distance: distance, ETA: eta, Wait: steps[0].Wait}
+	log.Printf("[Planner] Next step of %s: %s (wait %.1fs, %d mm, ETA %.1fs)", id, payload.NextStep, payload.Wait, distance, eta)
+	util.SendJSON(c.client, nextStepTopic.Format(values), payload)
+}
@@ -0,0 +1,91 @@
+package path
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dominikbraun/graph"
+)
+
+// Directions of travel on a lane segment. An edge of track.yml joins the forward nodes unless it
+// sets its directions: a vehicle driving forward goes from the source to the target, a vehicle
+// driving in reverse goes from the target to the source.
+const (
+	Forward = "forward"
+	Reverse = "reverse"
+)
+
+// UTurnConfig tells where a vehicle may reverse its direction of travel.
+type UTurnConfig struct {
+	Shapes []string `yaml:"shapes"` // shapes where reversing is allowed
+	Cost   int      `yaml:"cost"`   // cost of reversing, in mm of equivalent driving
+}
+
+// DirectedNode returns the graph node of a lane segment (e.g. "13.curve.outer") for a direction
+// of travel, e.g. "13.curve.outer.forward".
+func DirectedNode(segment, direction string) string {
+	return segment + "." + direction
+}
+
+// SplitNode returns the lane segment and the direction of travel of a graph node.
+// The direction is empty if the node has none.
+func SplitNode(node string) (segment, direction string) {
+	i := strings.LastIndex(node, ".")
+	if i < 0 {
+		return node, ""
+	}
+	switch node[i+1:] {
+	case Forward, Reverse:
+		return node[:i], node[i+1:]
+	}
+	return node, ""
+}
+
+// opposite returns the other direction of travel.
+func opposite(direction string) string {
+	if direction == Reverse {
+		return Forward
+	}
+	return Reverse
+}
+
+// ParseDirection maps the direction reported in the track events to Forward or Reverse.
+// Unknown values count as forward.
+func ParseDirection(direction string) string {
+	switch strings.ToLower(direction) {
+	case "reverse", "reversed", "backward", "backwards":
+		return Reverse
+	}
+	return Forward
+}
+
+// IsUTurn tells whether the step from one node to the next reverses the direction of travel
+// on the same lane segment.
+func IsUTurn(from, to string) bool {
+	fromSegment, fromDirection := SplitNode(from)
+	toSegment, toDirection := SplitNode(to)
+	return fromSegment == toSegment && fromDirection != "" && toDirection != "" && fromDirection != toDirection
+}
+
+// ShortestRoute returns the shortest route from a node to a lane segment, reached in any direction of travel.
+func ShortestRoute(g graph.Graph[string, string], from, targetSegment string) ([]string, error) {
+	var best []string
+	bestLength := 0
+	for _, direction := range []string{Forward, Reverse} {
+		p, err := graph.ShortestPath(g, from, DirectedNode(targetSegment, direction))
+		if err != nil {
+			continue
+		}
+		length, err := PathLength(g, p)
+		if err != nil {
+			continue
+		}
+		if best == nil || length < bestLength {
+			best, bestLength = p, length
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no route from %s to %s", from, targetSegment)
+	}
+	return best, nil
+}
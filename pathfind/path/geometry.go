@@ -0,0 +1,93 @@
+package path
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Point is a position on the track grid, in cells: (0, 0) is the top left corner of the grid
+// and (1, 1) the bottom right corner of its first cell.
+type Point struct {
+	X, Y float64
+}
+
+func (p Point) lerp(q Point, f float64) Point {
+	return Point{p.X + (q.X-p.X)*f, p.Y + (q.Y-p.Y)*f}
+}
+
+// slotOffset is the distance of a lane slot from the middle of its side, in cells.
+const slotOffset = 0.22
+
+// sideMiddles are the middles of the sides of a cell, and sideLefts the direction of the left
+// slot of each side seen from inside the cell looking out.
+var (
+	sideMiddles = [...]Point{{0.5, 0}, {1, 0.5}, {0.5, 1}, {0, 0.5}}
+	sideLefts   = [...]Point{{-1, 0}, {0, -1}, {1, 0}, {0, 1}}
+)
+
+// point returns the position of a lane end in a cell.
+func (e laneEnd) point(row, col int) Point {
+	middle, left := sideMiddles[e.side], sideLefts[e.side]
+	offset := slotOffset
+	if !e.left {
+		offset = -offset
+	}
+	return Point{float64(col) + middle.X + left.X*offset, float64(row) + middle.Y + left.Y*offset}
+}
+
+// LaneCurve is the drawn path of a lane segment: a quadratic Bézier curve from the point where a
+// vehicle driving forward enters it to the point where it leaves it.
+type LaneCurve struct {
+	From, Control, To Point
+}
+
+// At returns the position at a fraction of the curve, from 0 (entry) to 1 (exit).
+func (c LaneCurve) At(f float64) Point {
+	f = math.Max(0, math.Min(1, f))
+	return c.From.lerp(c.Control, f).lerp(c.Control.lerp(c.To, f), f)
+}
+
+// Curve returns the drawn path of a lane segment such as "13.curve.outer", from the lane ends of
+// its shape. A lane between opposite sides is straight, a lane between adjacent sides turns
+// around the corner they share. It returns false for the segments without lane ends.
+func (t *Track) Curve(segment string) (LaneCurve, bool) {
+	parts := strings.SplitN(segment, ".", 3)
+	if len(parts) != 3 {
+		return LaneCurve{}, false
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return LaneCurve{}, false
+	}
+	piece, ok := t.Piece(id)
+	if !ok || piece.Shape != parts[1] {
+		return LaneCurve{}, false
+	}
+	lane := t.Config.Shapes[piece.Shape].lane(parts[2])
+	if lane == nil {
+		return LaneCurve{}, false
+	}
+	entry, err := parseLaneEnd(lane.Entry)
+	if err != nil {
+		return LaneCurve{}, false
+	}
+	exit, err := parseLaneEnd(lane.Exit)
+	if err != nil {
+		return LaneCurve{}, false
+	}
+	entry, exit = entry.rotate(piece.Rotation), exit.rotate(piece.Rotation)
+	from, to := entry.point(piece.Row, piece.Col), exit.point(piece.Row, piece.Col)
+
+	// The control point is where the lines going into the cell from both ends cross, or the
+	// middle of the lane when they are parallel.
+	control := from.lerp(to, 0.5)
+	horizontal := func(side int) bool { return side == east || side == west }
+	switch {
+	case horizontal(entry.side) && !horizontal(exit.side):
+		control = Point{to.X, from.Y}
+	case !horizontal(entry.side) && horizontal(exit.side):
+		control = Point{from.X, to.Y}
+	}
+	return LaneCurve{from, control, to}, true
+}
@@ -2,57 +2,145 @@ package path
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hyperdrive/remote/pathfind/instruct"
 	"hyperdrive/remote/pathfind/util"
+	"hyperdrive/remote/topic"
 	"log"
-	"os"
+	"slices"
+	"strings"
 
 	"github.com/dominikbraun/graph"
-	"github.com/dominikbraun/graph/draw"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/goccy/go-yaml"
 )
 
 type TrackConfig struct {
-	Shapes map[string]ShapeDefinition `yaml:"shapes"`
-	Edges  []EdgePair                 `yaml:"edges"`
+	Shapes         map[string]ShapeDefinition `yaml:"shapes"`
+	LaneChangeCost int                        `yaml:"laneChangeCost"` // cost of a lane change within a piece, in mm
+	UTurn          UTurnConfig                `yaml:"uTurn"`
+	Layout         []PiecePlacement           `yaml:"layout,omitempty"`     // pieces on the grid, the edges are generated from them
+	Edges          []EdgePair                 `yaml:"edges,omitempty"`      // edges listed by hand, added to the generated ones
+	Background     string                     `yaml:"background,omitempty"` // image drawn behind the track map, relative to the track file
 }
 
+// EdgePair joins two lane segments: a vehicle leaving the source in SourceDirection enters the
+// target in TargetDirection. Both directions are Forward when empty.
 type EdgePair struct {
-	Source string `yaml:"source"`
-	Target string `yaml:"target"`
+	Source          string `yaml:"source"`
+	Target          string `yaml:"target"`
+	SourceDirection string `yaml:"sourceDirection,omitempty"`
+	TargetDirection string `yaml:"targetDirection,omitempty"`
+}
+
+// directions returns the directions of travel of the edge, Forward when not set.
+func (e EdgePair) directions() (source, target string) {
+	source, target = e.SourceDirection, e.TargetDirection
+	if source == "" {
+		source = Forward
+	}
+	if target == "" {
+		target = Forward
+	}
+	return source, target
 }
 
 // ShapeDefinition holds the lane segments for a particular shape type.
 type ShapeDefinition struct {
-	Lanes []LaneSegment `yaml:"lanes"`
+	Lanes       []LaneSegment `yaml:"lanes,omitempty"`
+	LaneChanges [][2]string   `yaml:"laneChanges,omitempty"` // pairs of lanes a vehicle can change between, both ways
+	PassThrough bool          `yaml:"passThrough,omitempty"` // no lanes of its own, the pieces on both sides are joined (the crossing)
 }
 
 // LaneSegment defines a named segment within a shape, identified by 'from' and 'to' values.
 type LaneSegment struct {
-	Name string `yaml:"name"`
-	From int    `yaml:"from"`
-	To   int    `yaml:"to"`
+	Name      string  `yaml:"name"`
+	From      int     `yaml:"from"`
+	To        int     `yaml:"to"`
+	Length    int     `yaml:"length"`              // distance driven on the segment, in mm
+	Detection float64 `yaml:"detection,omitempty"` // probability that a car driving the lane reports a track event on it
+	Entry     string  `yaml:"entry,omitempty"`     // where a vehicle driving forward enters the lane at rotation 0, e.g. "W:right"
+	Exit      string  `yaml:"exit,omitempty"`      // where it leaves the lane
 }
 
-const (
-	trackYamlPath = "assets/track.yml"
-	nextStepTopic = util.RootTopic + "/graph/nextStep"
-	arrivedTopic  = util.RootTopic + "/graph/arrived"
-)
+// defaultSegmentLength is used for the segments without a length, it is the length of a straight piece in mm.
+const defaultSegmentLength = 560
 
-func ImportYaml() graph.Graph[string, string] {
-	b, err := os.ReadFile(trackYamlPath)
+// segmentLength returns the length of a node such as "13.curve.outer".
+func (c TrackConfig) segmentLength(node string) (int, bool) {
+	parts := strings.SplitN(node, ".", 3)
+	if len(parts) != 3 {
+		return 0, false
+	}
+	for _, lane := range c.Shapes[parts[1]].Lanes {
+		if lane.Name == parts[2] && lane.Length > 0 {
+			return lane.Length, true
+		}
+	}
+	return 0, false
+}
 
-	g := graph.New(func(s string) string { return s })
+// laneName returns the lane of a shape whose from/to range holds a track location, e.g. "outer".
+// A location outside every range goes to the lane with the closest range.
+func (c TrackConfig) laneName(shape string, location int) string {
+	name, distance := "", 0
+	for _, lane := range c.Shapes[shape].Lanes {
+		d := max(lane.From-location, location-lane.To, 0)
+		if name == "" || d < distance {
+			name, distance = lane.Name, d
+		}
+	}
+	return name
+}
 
-	if err != nil {
-		workdir, _ := os.Getwd()
-		log.Fatal("Could not read file:", err, "\tWorkdir:", workdir)
+// detection returns the probability that a car driving the lane segment of a node reports a
+// track event on it, defaultDetection if the lane has none.
+func (c TrackConfig) detection(node string) float64 {
+	segment, _ := SplitNode(node)
+	parts := strings.SplitN(segment, ".", 3)
+	if len(parts) != 3 {
+		return defaultDetection
+	}
+	if lane := c.Shapes[parts[1]].lane(parts[2]); lane != nil && lane.Detection > 0 {
+		return lane.Detection
+	}
+	return defaultDetection
+}
 
+// isLaneChange tells whether an edge of track.yml joins two lanes of the same piece.
+func isLaneChange(source, target string) bool {
+	return len(source) >= 2 && len(target) >= 2 && source[:2] == target[:2]
+}
+
+// edgeWeight returns the cost of driving from source to target. A lane change within a piece
+// costs LaneChangeCost, any other edge costs the length of the target segment, or
+// defaultSegmentLength if it has none (track lint reports those lanes).
+func (c TrackConfig) edgeWeight(source, target string) int {
+	if isLaneChange(source, target) {
+		return c.LaneChangeCost
+	}
+	length, ok := c.segmentLength(target)
+	if !ok {
+		return defaultSegmentLength
 	}
-	var data TrackConfig
-	yaml.Unmarshal(b, &data)
+	return length
+}
+
+// Per-vehicle topics of the path calculation, {vehicle} is the car ID.
+const (
+	nextStepTopic topic.Template = util.RootTopic + "/graph/{vehicle}/nextStep"
+	arrivedTopic  topic.Template = util.RootTopic + "/graph/{vehicle}/arrived"
+	routeTopic    topic.Template = util.RootTopic + "/graph/{vehicle}/route"
+)
+
+// buildGraph builds the directed track graph of a track definition. Every lane segment has a node
+// per direction of travel: the edges connect the nodes of their directions (forward by default),
+// the reversed edges connect the opposite nodes, lane changes are possible both ways in both
+// directions, and U-turn edges join the two nodes of the segments whose shape allows reversing.
+// It returns the graph with the edges it could add and the errors of the others, e.g. an edge
+// listed twice.
+func buildGraph(data TrackConfig) (graph.Graph[string, string], error) {
+	g := graph.New(func(s string) string { return s }, graph.Directed(), graph.Weighted())
 
 	uniqueVertices := map[string]bool{}
 	for _, e := range data.Edges {
@@ -61,60 +149,97 @@ func ImportYaml() graph.Graph[string, string] {
 	}
 
 	for k := range uniqueVertices {
-		g.AddVertex(k)
+		g.AddVertex(DirectedNode(k, Forward))
+		g.AddVertex(DirectedNode(k, Reverse))
 	}
 
+	var errs []error
+	addEdge := func(source, sourceDirection, target, targetDirection string, weight int) {
+		from, to := DirectedNode(source, sourceDirection), DirectedNode(target, targetDirection)
+		if err := g.AddEdge(from, to, graph.EdgeWeight(weight)); err != nil {
+			errs = append(errs, fmt.Errorf("edge %s -> %s: %w", from, to, err))
+		}
+	}
 	for _, e := range data.Edges {
-		g.AddEdge(e.Source, e.Target)
+		sd, td := e.directions()
+		addEdge(e.Source, sd, e.Target, td, data.edgeWeight(e.Source, e.Target))
+		addEdge(e.Target, opposite(td), e.Source, opposite(sd), data.edgeWeight(e.Target, e.Source))
+		if isLaneChange(e.Source, e.Target) {
+			addEdge(e.Target, td, e.Source, sd, data.LaneChangeCost)
+			addEdge(e.Source, opposite(sd), e.Target, opposite(td), data.LaneChangeCost)
+		}
 	}
 
-	file, _ := os.Create("assets/track-graph.gv")
-	draw.DOT(g, file)
-	file.Close()
+	for k := range uniqueVertices {
+		if parts := strings.SplitN(k, ".", 3); len(parts) == 3 && slices.Contains(data.UTurn.Shapes, parts[1]) {
+			addEdge(k, Forward, k, Reverse, data.UTurn.Cost)
+			addEdge(k, Reverse, k, Forward, data.UTurn.Cost)
+		}
+	}
+	return g, errors.Join(errs...)
+}
 
-	return g
+// PathLength returns the weighted length of a path of the track graph, in mm.
+func PathLength(g graph.Graph[string, string], p []string) (int, error) {
+	total := 0
+	for i := 1; i < len(p); i++ {
+		edge, err := g.Edge(p[i-1], p[i])
+		if err != nil {
+			return 0, err
+		}
+		total += edge.Properties.Weight
+	}
+	return total, nil
 }
 
 type nextStepPayload struct {
-	NextStep string `json:"next_step"`
+	NextStep string  `json:"next_step"`
+	Distance int     `json:"distance"`       // remaining distance to the target, in mm
+	ETA      float64 `json:"eta"`            // estimated time to the target at the cruise velocity, in seconds
+	Wait     float64 `json:"wait,omitempty"` // time to stay stopped before driving on, in seconds, while the next piece is reserved
 }
 
 type arrivedPayload struct {
 	Arrived bool `json:"arrived"`
 }
 
-type strChannel chan string
-
-func (ch strChannel) targetTopicHandler(c mqtt.Client, m mqtt.Message) {
-	var data tilePayload
-	err := json.Unmarshal(m.Payload(), &data)
-	if err != nil {
-		log.Println("Could not unmarshal message:", string(m.Payload()))
-		return
-	}
-	log.Println("[targetTopicHandler] Got a new target:", data.ID)
+// routePayload is the planned route of a vehicle, shown on the map of the UI.
+type routePayload struct {
+	Route []string `json:"route"` // graph nodes from the position to the target
+}
 
-	// sanitize: we only get a number, and we need to map it to a string with the correct suffix if necessary.
-	n := data.ID
-	shape := getTrackShape(n)
-	var suffix string
+// strChannel passes the values of the MQTT handlers to the loop of a vehicle, until done is closed.
+type strChannel struct {
+	ch   chan string
+	done <-chan struct{}
+}
 
-	switch shape {
-	case "curve":
-		suffix = "outer"
-	case "straight":
-		suffix = "top"
-	case "intersection":
-		suffix = "bottom"
+func (ch strChannel) send(s string) {
+	select {
+	case ch.ch <- s:
+	case <-ch.done:
 	}
+}
 
-	if n == 0 || n == 17 {
-		// we cannot stop on the crossing. This is invalid.
-		log.Println("It is not allowed to stop on the crossing. Setting it to the default value 15.")
-		n = 15
-	}
+// targetTopicHandler returns the handler of the target tiles chosen in the UI.
+func (ch strChannel) targetTopicHandler(track *Track) mqtt.MessageHandler {
+	return func(c mqtt.Client, m mqtt.Message) {
+		var data tilePayload
+		err := json.Unmarshal(m.Payload(), &data)
+		if err != nil {
+			log.Println("Could not unmarshal message:", string(m.Payload()))
+			return
+		}
+		log.Println("[targetTopicHandler] Got a new target:", data.ID)
 
-	ch <- fmt.Sprintf("%02d.%s.%s", n, shape, suffix)
+		segment, ok := track.TargetSegment(data.ID)
+		if !ok {
+			// e.g. the crossing, we cannot stop there.
+			log.Println("It is not allowed to stop on piece", data.ID, ", ignoring the target.")
+			return
+		}
+		ch.send(segment)
+	}
 }
 
 func (ch strChannel) positionTopicHandler(c mqtt.Client, m mqtt.Message) {
@@ -127,20 +252,30 @@ func (ch strChannel) positionTopicHandler(c mqtt.Client, m mqtt.Message) {
 	log.Println("[positionTopicHandler] Got new position:", data.ID)
 
 	if data.ID != "" {
-		ch <- data.ID
+		ch.send(data.ID)
 	}
 }
 
-func PathCalculation(client mqtt.Client, g graph.Graph[string, string]) {
+// PathCalculation computes the route of one vehicle to its target and publishes the next step,
+// until done is closed.
+func PathCalculation(client mqtt.Client, track *Track, vehicleID string, done <-chan struct{}) {
+	values := topic.Values{"vehicle": vehicleID}
+	g := track.Graph
+
+	targetTopic := vehicleTargetTopic.Format(values)
 	targetUpdate := make(chan string)
-	if token := client.Subscribe(vehicleTargetTopic, 1, strChannel(targetUpdate).targetTopicHandler); token.Wait() && token.Error() != nil {
-		log.Fatal("Could not subscribe to", vehicleTargetTopic, "because of:", token.Error())
+	if token := client.Subscribe(targetTopic, 1, strChannel{targetUpdate, done}.targetTopicHandler(track)); token.Wait() && token.Error() != nil {
+		log.Println("Could not subscribe to", targetTopic, "because of:", token.Error())
+		return
 	}
 
+	positionTopic := vehiclePositionTopic.Format(values)
 	positionUpdate := make(chan string)
-	if token := client.Subscribe(vehiclePositionTopic, 1, strChannel(positionUpdate).positionTopicHandler); token.Wait() && token.Error() != nil {
-		log.Fatal("Could not subscribe to", vehiclePositionTopic, "because of:", token.Error())
+	if token := client.Subscribe(positionTopic, 1, strChannel{positionUpdate, done}.positionTopicHandler); token.Wait() && token.Error() != nil {
+		log.Println("Could not subscribe to", positionTopic, "because of:", token.Error())
+		return
 	}
+	defer client.Unsubscribe(targetTopic, positionTopic)
 
 	var (
 		target, position string
@@ -148,39 +283,48 @@ func PathCalculation(client mqtt.Client, g graph.Graph[string, string]) {
 	)
 	for {
 		select {
+		case <-done:
+			return
+
 		case target, ok = <-targetUpdate:
 			if !ok {
 				continue
 			}
-			log.Println("[Graph] Got a new target:", target)
+			log.Println("[Graph]", vehicleID, "got a new target:", target)
 
 		case position, ok = <-positionUpdate:
 			if !ok {
 				continue
 			}
-			log.Println("[Graph] Got new position:", position)
+			log.Println("[Graph]", vehicleID, "got new position:", position)
 		}
 
 		if target == "" || position == "" {
 			continue
 		}
 
-		p, err := graph.ShortestPath(g, position, target)
+		p, err := ShortestRoute(g, position, target)
 		if err != nil {
 			log.Println("[Graph] Could not compute shortest path from", position, "to", target)
 			continue
 		}
 
-		log.Println("[Graph] The shortest path from", position, "to", target, "is", p)
+		distance, err := PathLength(g, p)
+		if err != nil {
+			log.Println("[Graph] Could not compute the length of", p, ":", err)
+		}
+		eta := float64(distance) / instruct.VelocityValue
+		log.Printf("[Graph] The shortest path from %s to %s is %v (%d mm, ETA %.1fs)", position, target, p, distance, eta)
 
+		util.SendJSON(client, routeTopic.Format(values), routePayload{p})
 		if len(p) <= 1 {
 			data, _ := json.Marshal(arrivedPayload{true})
-			client.Publish(arrivedTopic, 1, false, data)
+			client.Publish(arrivedTopic.Format(values), 1, false, data)
 		} else {
 			nextStep := p[1]
-			data, _ := json.Marshal(nextStepPayload{nextStep})
+			data, _ := json.Marshal(nextStepPayload{NextStep: nextStep, Distance: distance, ETA: eta})
 			log.Println("[Graph] Publishing next step as being:", string(data))
-			client.Publish(nextStepTopic, 1, false, data)
+			client.Publish(nextStepTopic.Format(values), 1, false, data)
 		}
 	}
 }
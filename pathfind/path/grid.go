@@ -0,0 +1,115 @@
+package path
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+)
+
+// defaultTileImage is the tile image of the pieces without an image, relative to the track file.
+const defaultTileImage = "ID_%02d.png"
+
+// Piece returns the placement of a piece in the layout.
+func (t *Track) Piece(id int) (PiecePlacement, bool) {
+	i := slices.IndexFunc(t.Config.Layout, func(p PiecePlacement) bool { return p.ID == id })
+	if i < 0 {
+		return PiecePlacement{}, false
+	}
+	return t.Config.Layout[i], true
+}
+
+// Shape returns the shape of a piece. The pieces missing from the layout are straights.
+func (t *Track) Shape(id int) string {
+	if p, ok := t.Piece(id); ok {
+		return p.Shape
+	}
+	return "straight"
+}
+
+// PositionNode returns the graph node of a track event, e.g. "13.curve.outer.forward".
+// direction is the direction reported by the vehicle, see ParseDirection.
+func (t *Track) PositionNode(trackID, location int, direction string) string {
+	shape := t.Shape(trackID)
+	return DirectedNode(fmt.Sprintf("%02d.%s.%s", trackID, shape, t.Config.laneName(shape, location)), ParseDirection(direction))
+}
+
+// IsTarget tells whether a vehicle may be sent to a piece: it must be in the layout, and not be
+// flagged noTarget (the crossing, where a car must not stop).
+func (t *Track) IsTarget(id int) bool {
+	p, ok := t.Piece(id)
+	return ok && !p.NoTarget
+}
+
+// TargetSegment returns the lane segment a vehicle sent to a piece stops on, e.g. "13.curve.outer".
+// It returns false for the pieces that are not targets.
+func (t *Track) TargetSegment(id int) (string, bool) {
+	if !t.IsTarget(id) {
+		return "", false
+	}
+	shape := t.Shape(id)
+	var suffix string
+	switch shape {
+	case "curve":
+		suffix = "outer"
+	case "straight":
+		suffix = "top"
+	case "intersection":
+		suffix = "bottom"
+	}
+	return fmt.Sprintf("%02d.%s.%s", id, shape, suffix), true
+}
+
+// Crossings returns the pieces whose shape is a pass-through, the cars drive across them.
+func (t *Track) Crossings() []int {
+	var pieces []int
+	for _, p := range t.Config.Layout {
+		if t.Config.Shapes[p.Shape].PassThrough {
+			pieces = append(pieces, p.ID)
+		}
+	}
+	return pieces
+}
+
+// GridSize returns the number of rows and columns of the grid the pieces are placed on.
+func (t *Track) GridSize() (rows, cols int) {
+	for _, p := range t.Config.Layout {
+		rows, cols = max(rows, p.Row+1), max(cols, p.Col+1)
+	}
+	return rows, cols
+}
+
+// PieceAt returns the piece placed at a grid position.
+func (t *Track) PieceAt(row, col int) (PiecePlacement, bool) {
+	i := slices.IndexFunc(t.Config.Layout, func(p PiecePlacement) bool { return p.Row == row && p.Col == col })
+	if i < 0 {
+		return PiecePlacement{}, false
+	}
+	return t.Config.Layout[i], true
+}
+
+// GridNeighbours returns the pieces next to the given piece in the grid (north, east, south, west).
+func (t *Track) GridNeighbours(piece int) []int {
+	p, ok := t.Piece(piece)
+	if !ok {
+		return nil
+	}
+	var neighbours []int
+	for _, step := range sideSteps {
+		if n, ok := t.PieceAt(p.Row+step[0], p.Col+step[1]); ok {
+			neighbours = append(neighbours, n.ID)
+		}
+	}
+	return neighbours
+}
+
+// TileImage returns the path of the tile image of a piece.
+func (t *Track) TileImage(p PiecePlacement) string {
+	image := p.Image
+	if image == "" {
+		image = fmt.Sprintf(defaultTileImage, p.ID)
+	}
+	if filepath.IsAbs(image) {
+		return image
+	}
+	return filepath.Join(t.Dir, image)
+}
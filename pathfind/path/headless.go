@@ -0,0 +1,21 @@
+//go:build headless
+
+package path
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// UI replaces the window in a binary built with -tags headless, without Fyne: the vehicles are
+// driven by the targets and missions published over MQTT until the process is interrupted.
+func UI(client mqtt.Client, track *Track) {
+	log.Println("Built without UI (-tags headless), send the targets over MQTT. Interrupt to quit.")
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+}
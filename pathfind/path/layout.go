@@ -0,0 +1,234 @@
+package path
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PiecePlacement places a piece on the track grid, the grid shown by the pathfind UI.
+type PiecePlacement struct {
+	ID       int    `yaml:"id"`
+	Shape    string `yaml:"shape"`
+	Row      int    `yaml:"row"`
+	Col      int    `yaml:"col"`
+	Rotation int    `yaml:"rotation"`           // clockwise, in degrees: 0, 90, 180 or 270
+	Image    string `yaml:"image,omitempty"`    // tile image, relative to the track file (default: ID_<id>.png)
+	NoTarget bool   `yaml:"noTarget,omitempty"` // a vehicle must not be sent there, e.g. the crossing
+}
+
+// Sides of a piece on the grid, in clockwise order.
+const (
+	north = iota
+	east
+	south
+	west
+)
+
+var sideNames = [...]string{"north", "east", "south", "west"}
+
+// sideSteps are the grid steps (row, column) to the neighbour on each side.
+var sideSteps = [...][2]int{{-1, 0}, {0, 1}, {1, 0}, {0, -1}}
+
+// laneEnd is where a lane leaves a piece: a side, and the slot of that side seen from inside
+// the piece looking out, "left" or "right". Facing sides of two pieces join left to right.
+type laneEnd struct {
+	side int
+	left bool
+}
+
+// parseLaneEnd parses a lane end of the shapes section such as "W:right".
+func parseLaneEnd(s string) (laneEnd, error) {
+	sideName, slot, ok := strings.Cut(s, ":")
+	if !ok {
+		return laneEnd{}, fmt.Errorf("lane end %q: expected side:slot, e.g. W:right", s)
+	}
+	side := strings.Index("NESW", strings.ToUpper(strings.TrimSpace(sideName)))
+	if len(strings.TrimSpace(sideName)) != 1 || side < 0 {
+		return laneEnd{}, fmt.Errorf("lane end %q: the side must be N, E, S or W", s)
+	}
+	switch strings.TrimSpace(slot) {
+	case "left":
+		return laneEnd{side, true}, nil
+	case "right":
+		return laneEnd{side, false}, nil
+	}
+	return laneEnd{}, fmt.Errorf("lane end %q: the slot must be left or right", s)
+}
+
+// rotate turns the lane end clockwise by the rotation of a piece.
+func (e laneEnd) rotate(rotation int) laneEnd {
+	return laneEnd{(e.side + rotation/90) % 4, e.left}
+}
+
+// facing returns the lane end of the neighbouring piece that joins this one.
+func (e laneEnd) facing() laneEnd {
+	return laneEnd{(e.side + 2) % 4, !e.left}
+}
+
+// LayoutError is a mistake in the layout section, index is the position of the piece in it.
+type LayoutError struct {
+	Index   int
+	Message string
+}
+
+func (e *LayoutError) Error() string {
+	return e.Message
+}
+
+// placedEnd is a lane end of a placed piece.
+type placedEnd struct {
+	index int    // of the piece in the layout
+	node  string // e.g. "13.curve.outer"
+	exit  bool   // true for the end a vehicle driving forward leaves the lane by
+}
+
+type endKey struct {
+	row, col int
+	end      laneEnd
+}
+
+// GenerateEdges derives the edges of the track graph from the layout: the lanes of neighbouring
+// pieces whose ends face each other are joined, and the lane changes of each shape are added
+// within every piece. A piece whose shape is a pass-through (the crossing) joins the pieces on
+// both sides of it. All the mistakes of the layout are returned as *LayoutError, joined.
+func GenerateEdges(data TrackConfig) ([]EdgePair, error) {
+	var errs []error
+	fail := func(index int, format string, args ...any) {
+		errs = append(errs, &LayoutError{index, fmt.Sprintf(format, args...)})
+	}
+
+	cells := map[[2]int]int{} // grid position -> index of the piece
+	ids := map[int]int{}
+	ends := map[endKey]placedEnd{}
+	var keys []endKey
+	for i, p := range data.Layout {
+		shape, ok := data.Shapes[p.Shape]
+		switch {
+		case !ok:
+			fail(i, "piece %d: shape %s is not declared", p.ID, p.Shape)
+			continue
+		case p.Rotation < 0 || p.Rotation >= 360 || p.Rotation%90 != 0:
+			fail(i, "piece %d: rotation %d is not 0, 90, 180 or 270", p.ID, p.Rotation)
+			continue
+		}
+		if other, ok := cells[[2]int{p.Row, p.Col}]; ok {
+			fail(i, "piece %d: row %d, col %d is already taken by piece %d", p.ID, p.Row, p.Col, data.Layout[other].ID)
+			continue
+		}
+		if other, ok := ids[p.ID]; ok {
+			fail(i, "piece %d is placed twice, first at row %d, col %d", p.ID, data.Layout[other].Row, data.Layout[other].Col)
+			continue
+		}
+		cells[[2]int{p.Row, p.Col}] = i
+		ids[p.ID] = i
+
+		for _, lane := range shape.Lanes {
+			node := fmt.Sprintf("%02d.%s.%s", p.ID, p.Shape, lane.Name)
+			for _, end := range []struct {
+				value string
+				exit  bool
+			}{{lane.Entry, false}, {lane.Exit, true}} {
+				e, err := parseLaneEnd(end.value)
+				if err != nil {
+					fail(i, "piece %d: lane %s: %v", p.ID, lane.Name, err)
+					continue
+				}
+				key := endKey{p.Row, p.Col, e.rotate(p.Rotation)}
+				if other, ok := ends[key]; ok {
+					fail(i, "piece %d: lanes %s and %s end on the same slot", p.ID, other.node, node)
+					continue
+				}
+				ends[key] = placedEnd{i, node, end.exit}
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	var edges []EdgePair
+	joined := map[endKey]bool{}
+	for _, key := range keys {
+		if joined[key] {
+			continue
+		}
+		end := ends[key]
+		piece := data.Layout[end.index]
+
+		// The neighbour on the side of the lane end, crossing the pass-through pieces.
+		row, col := key.row, key.col
+		step := sideSteps[key.end.side]
+		neighbour := -1
+		for {
+			row, col = row+step[0], col+step[1]
+			i, ok := cells[[2]int{row, col}]
+			if !ok || !data.Shapes[data.Layout[i].Shape].PassThrough {
+				if ok {
+					neighbour = i
+				}
+				break
+			}
+		}
+		if neighbour < 0 {
+			fail(end.index, "piece %d: lane %s ends on the %s side, where there is no piece", piece.ID, end.node, sideNames[key.end.side])
+			continue
+		}
+		otherKey := endKey{row, col, key.end.facing()}
+		other, ok := ends[otherKey]
+		if !ok {
+			fail(end.index, "piece %d: lane %s ends on the %s side, piece %d has no lane there", piece.ID, end.node, sideNames[key.end.side], data.Layout[neighbour].ID)
+			continue
+		}
+		joined[key], joined[otherKey] = true, true
+		edges = append(edges, joinEnds(end, other))
+	}
+
+	for i, p := range data.Layout {
+		shape := data.Shapes[p.Shape]
+		for _, change := range shape.LaneChanges {
+			from, to := shape.lane(change[0]), shape.lane(change[1])
+			if from == nil || to == nil {
+				fail(i, "piece %d: lane change %s -> %s between unknown lanes of %s", p.ID, change[0], change[1], p.Shape)
+				continue
+			}
+			edge := EdgePair{
+				Source: fmt.Sprintf("%02d.%s.%s", p.ID, p.Shape, from.Name),
+				Target: fmt.Sprintf("%02d.%s.%s", p.ID, p.Shape, to.Name),
+			}
+			// Lanes driven in opposite directions: changing lane also reverses the direction of the piece.
+			if fromEntry, err := parseLaneEnd(from.Entry); err == nil {
+				if toEntry, err := parseLaneEnd(to.Entry); err == nil && fromEntry.side != toEntry.side {
+					edge.TargetDirection = Reverse
+				}
+			}
+			edges = append(edges, edge)
+		}
+	}
+
+	return edges, errors.Join(errs...)
+}
+
+// joinEnds returns the edge between two facing lane ends. Leaving a lane by its exit means
+// driving forward, entering a lane by its entry means driving forward.
+func joinEnds(from, to placedEnd) EdgePair {
+	if !from.exit && to.exit {
+		from, to = to, from
+	}
+	edge := EdgePair{Source: from.node, Target: to.node}
+	if !from.exit {
+		edge.SourceDirection = Reverse
+	}
+	if to.exit {
+		edge.TargetDirection = Reverse
+	}
+	return edge
+}
+
+// lane returns the lane of the shape with the given name, or nil.
+func (s ShapeDefinition) lane(name string) *LaneSegment {
+	for i := range s.Lanes {
+		if s.Lanes[i].Name == name {
+			return &s.Lanes[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package path
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGenerateEdges(t *testing.T) {
+	ring := []string{
+		"01.curve.inner -> 02.curve.inner",
+		"01.curve.inner -> 01.curve.outer",
+		"01.curve.outer -> 02.curve.outer",
+		"02.curve.inner -> 03.curve.inner",
+		"02.curve.inner -> 02.curve.outer",
+		"02.curve.outer -> 03.curve.outer",
+		"03.curve.inner -> 04.curve.inner",
+		"03.curve.inner -> 03.curve.outer",
+		"03.curve.outer -> 04.curve.outer",
+		"04.curve.inner -> 01.curve.inner",
+		"04.curve.inner -> 04.curve.outer",
+		"04.curve.outer -> 01.curve.outer",
+	}
+	tests := []struct {
+		name    string
+		change  func(*TrackConfig)
+		want    []string       // edges as "source -> target", with their directions that are not forward
+		wantErr map[int]string // index of the piece in the layout -> part of its message
+	}{
+		{
+			name:   "ring",
+			change: func(*TrackConfig) {},
+			want:   ring,
+		},
+		{
+			name: "opposite lanes",
+			change: func(c *TrackConfig) {
+				// The outer lane is driven counterclockwise: its edges reverse the ring, its lane
+				// changes reverse the direction of travel.
+				lanes := c.Shapes["curve"].Lanes
+				lanes[1].Entry, lanes[1].Exit = "E:right", "S:left"
+			},
+			want: []string{
+				"01.curve.inner -> 02.curve.inner",
+				"01.curve.inner -> 01.curve.outer (target reverse)",
+				"01.curve.outer -> 04.curve.outer",
+				"02.curve.inner -> 03.curve.inner",
+				"02.curve.inner -> 02.curve.outer (target reverse)",
+				"02.curve.outer -> 01.curve.outer",
+				"03.curve.inner -> 04.curve.inner",
+				"03.curve.inner -> 03.curve.outer (target reverse)",
+				"03.curve.outer -> 02.curve.outer",
+				"04.curve.inner -> 01.curve.inner",
+				"04.curve.inner -> 04.curve.outer (target reverse)",
+				"04.curve.outer -> 03.curve.outer",
+			},
+		},
+		{
+			name: "pass-through",
+			change: func(c *TrackConfig) {
+				// Crossings between the pieces of each row join them.
+				c.Shapes["crossing"] = ShapeDefinition{PassThrough: true}
+				c.Layout[1].Col, c.Layout[2].Col = 2, 2
+				c.Layout = append(c.Layout,
+					PiecePlacement{ID: 5, Shape: "crossing", Row: 0, Col: 1},
+					PiecePlacement{ID: 6, Shape: "crossing", Row: 1, Col: 1})
+			},
+			want: ring,
+		},
+		{
+			name:    "undeclared shape",
+			change:  func(c *TrackConfig) { c.Layout[2].Shape = "bend" },
+			wantErr: map[int]string{2: "piece 3: shape bend is not declared"},
+		},
+		{
+			name:    "rotation",
+			change:  func(c *TrackConfig) { c.Layout[1].Rotation = 45 },
+			wantErr: map[int]string{1: "piece 2: rotation 45 is not 0, 90, 180 or 270"},
+		},
+		{
+			name:    "cell taken",
+			change:  func(c *TrackConfig) { c.Layout[3].Row, c.Layout[3].Col = 0, 1 },
+			wantErr: map[int]string{3: "piece 4: row 0, col 1 is already taken by piece 2"},
+		},
+		{
+			name:    "placed twice",
+			change:  func(c *TrackConfig) { c.Layout[3].ID = 1 },
+			wantErr: map[int]string{3: "piece 1 is placed twice, first at row 0, col 0"},
+		},
+		{
+			name:    "no neighbour",
+			change:  func(c *TrackConfig) { c.Layout = c.Layout[:3] },
+			wantErr: map[int]string{0: "lane 01.curve.inner ends on the south side, where there is no piece", 2: "lane 03.curve.inner ends on the west side, where there is no piece"},
+		},
+		{
+			name:    "no lane",
+			change:  func(c *TrackConfig) { c.Layout[1].Rotation = 180 },
+			wantErr: map[int]string{2: "lane 03.curve.inner ends on the north side, piece 2 has no lane there"},
+		},
+		{
+			name: "lane end",
+			change: func(c *TrackConfig) {
+				c.Shapes["curve"].Lanes[0].Exit = "E:middle"
+			},
+			wantErr: map[int]string{0: "piece 1: lane inner: lane end \"E:middle\": the slot must be left or right"},
+		},
+		{
+			name:    "lane change",
+			change:  func(c *TrackConfig) { c.Shapes["curve"] = withLaneChange(c.Shapes["curve"], "inner", "middle") },
+			wantErr: map[int]string{0: "piece 1: lane change inner -> middle between unknown lanes of curve"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ringConfig(t)
+			tt.change(&config)
+			edges, err := GenerateEdges(config)
+
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("GenerateEdges: %v", err)
+				}
+				if got, want := edgeStrings(edges), slices.Sorted(slices.Values(tt.want)); !slices.Equal(got, want) {
+					t.Errorf("GenerateEdges =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("GenerateEdges: no error")
+			}
+			for index, message := range tt.wantErr {
+				if !hasLayoutError(err, index, message) {
+					t.Errorf("GenerateEdges error = %v, want %q for layout entry %d", err, message, index)
+				}
+			}
+		})
+	}
+}
+
+// edgeStrings returns the edges as "source -> target" followed by their directions that are
+// not forward, sorted.
+func edgeStrings(edges []EdgePair) []string {
+	var s []string
+	for _, e := range edges {
+		edge := e.Source + " -> " + e.Target
+		if e.SourceDirection == Reverse {
+			edge += " (source reverse)"
+		}
+		if e.TargetDirection == Reverse {
+			edge += " (target reverse)"
+		}
+		s = append(s, edge)
+	}
+	slices.Sort(s)
+	return s
+}
+
+// hasLayoutError tells whether the joined errors hold a *LayoutError of a layout entry with a message.
+func hasLayoutError(err error, index int, message string) bool {
+	for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var layoutErr *LayoutError
+		if errors.As(err, &layoutErr) && layoutErr.Index == index && strings.Contains(layoutErr.Message, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// withLaneChange returns a copy of a shape with one more lane change.
+func withLaneChange(shape ShapeDefinition, from, to string) ShapeDefinition {
+	shape.LaneChanges = append(slices.Clone(shape.LaneChanges), [2]string{from, to})
+	return shape
+}
+
+func TestLaneEnd(t *testing.T) {
+	tests := []struct {
+		end      string
+		rotation int
+		want     string // the rotated lane end and the lane end facing it
+	}{
+		{"W:right", 0, "west right, east left"},
+		{"w : left", 90, "north left, south right"},
+		{"S:right", 180, "north right, south left"},
+		{"E:left", 270, "north left, south right"},
+	}
+	slot := func(e laneEnd) string {
+		if e.left {
+			return sideNames[e.side] + " left"
+		}
+		return sideNames[e.side] + " right"
+	}
+	for _, tt := range tests {
+		e, err := parseLaneEnd(tt.end)
+		if err != nil {
+			t.Errorf("parseLaneEnd(%q): %v", tt.end, err)
+			continue
+		}
+		rotated := e.rotate(tt.rotation)
+		if got := fmt.Sprintf("%s, %s", slot(rotated), slot(rotated.facing())); got != tt.want {
+			t.Errorf("%q rotated by %d = %s, want %s", tt.end, tt.rotation, got, tt.want)
+		}
+	}
+
+	for _, end := range []string{"W", "X:left", "NE:left", "W:up"} {
+		if _, err := parseLaneEnd(end); err == nil {
+			t.Errorf("parseLaneEnd(%q): no error", end)
+		}
+	}
+}
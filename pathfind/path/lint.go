@@ -0,0 +1,404 @@
+package path
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dominikbraun/graph"
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+)
+
+// LintProblem is a mistake found in a track definition.
+type LintProblem struct {
+	Line    int // line of the track definition, 0 if the problem has no line
+	Message string
+}
+
+func (p LintProblem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("%d: %s", p.Line, p.Message)
+}
+
+// linter collects the problems of a track definition with their line numbers.
+type linter struct {
+	file      *ast.File
+	data      TrackConfig
+	track     *Track // data without its graph, for the layout lookups
+	generated int    // the first edges of data are generated from the layout
+	problems  []LintProblem
+}
+
+// line returns the line of a YAML path such as "$.edges[3].source", or 0 if it does not exist.
+func (l *linter) line(path string) int {
+	p, err := yaml.PathString(path)
+	if err != nil {
+		return 0
+	}
+	node, err := p.FilterFile(l.file)
+	if err != nil || node == nil || node.GetToken() == nil {
+		return 0
+	}
+	return node.GetToken().Position.Line
+}
+
+func (l *linter) report(line int, format string, args ...any) {
+	l.problems = append(l.problems, LintProblem{Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// LintTrack checks a track definition (the content of track.yml) and returns its problems, sorted by line:
+// layout mistakes, unknown node names, nodes that disagree with the layout, duplicate edges,
+// disconnected or unreachable nodes, pieces missing from the graph and lanes outside their
+// declared ranges.
+func LintTrack(b []byte) ([]LintProblem, error) {
+	file, err := parser.ParseBytes(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	l := &linter{file: file}
+	if err := yaml.Unmarshal(b, &l.data); err != nil {
+		return nil, err
+	}
+	l.lintLayout()
+	l.track = &Track{Config: l.data}
+
+	l.lintLanes()
+	l.lintLocations()
+	firstLine := l.lintEdges()
+	l.lintConnectivity(firstLine)
+	l.lintGrid(firstLine)
+
+	sort.SliceStable(l.problems, func(i, j int) bool { return l.problems[i].Line < l.problems[j].Line })
+	return l.problems, nil
+}
+
+// lintLayout reports the mistakes of the layout and adds the generated edges before the
+// edges listed by hand.
+func (l *linter) lintLayout() {
+	if len(l.data.Layout) == 0 {
+		return
+	}
+	edges, err := GenerateEdges(l.data)
+	if err != nil {
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+		for _, err := range errs {
+			var layoutErr *LayoutError
+			if errors.As(err, &layoutErr) {
+				l.report(l.line(fmt.Sprintf("$.layout[%d]", layoutErr.Index)), "%s", layoutErr.Message)
+			} else {
+				l.report(l.line("$.layout"), "%v", err)
+			}
+		}
+	}
+	l.generated = len(edges)
+	l.data.Edges = append(edges, l.data.Edges...)
+}
+
+// edgeLine returns the line of the source or target of an edge. A generated edge has the line
+// of the layout entry of its piece.
+func (l *linter) edgeLine(i int, field string) int {
+	if i >= l.generated {
+		return l.line(fmt.Sprintf("$.edges[%d].%s", i-l.generated, field))
+	}
+	node := l.data.Edges[i].Source
+	if field == "target" {
+		node = l.data.Edges[i].Target
+	}
+	id, err := strconv.Atoi(node[:min(2, len(node))])
+	if err != nil {
+		return 0
+	}
+	index := slices.IndexFunc(l.data.Layout, func(p PiecePlacement) bool { return p.ID == id })
+	if index < 0 {
+		return 0
+	}
+	return l.line(fmt.Sprintf("$.layout[%d]", index))
+}
+
+// sortedShapes returns the shape names in a stable order.
+func (l *linter) sortedShapes() []string {
+	names := make([]string, 0, len(l.data.Shapes))
+	for name := range l.data.Shapes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// lintLanes checks the from/to ranges and the lengths of the lanes of every shape.
+func (l *linter) lintLanes() {
+	var noLength []string
+	for _, shape := range l.sortedShapes() {
+		lanes := l.data.Shapes[shape].Lanes
+		for i, lane := range lanes {
+			path := fmt.Sprintf("$.shapes.%s.lanes[%d]", shape, i)
+			line := l.line(path + ".name")
+			if line == 0 {
+				line = l.line(fmt.Sprintf("$.shapes.%s", shape))
+			}
+			if lane.Name == "" {
+				l.report(line, "shape %s: lane %d has no name", shape, i+1)
+				continue
+			}
+			if lane.Length <= 0 {
+				noLength = append(noLength, shape+"."+lane.Name)
+			}
+			if lane.From > lane.To {
+				l.report(line, "shape %s: lane %s goes from %d to %d", shape, lane.Name, lane.From, lane.To)
+			}
+			for _, other := range lanes[:i] {
+				if other.Name != "" && lane.From <= other.To && other.From <= lane.To {
+					l.report(line, "shape %s: lane %s (%d-%d) overlaps lane %s (%d-%d)", shape, lane.Name, lane.From, lane.To, other.Name, other.From, other.To)
+				}
+			}
+		}
+	}
+	if len(noLength) > 0 {
+		l.report(l.line("$.shapes"), "lanes %s have no length, routes count them as %d mm", strings.Join(noLength, ", "), defaultSegmentLength)
+	}
+}
+
+// lintLocations checks that the lanes of every shape cover its track locations, from 1 to the
+// highest declared one. Track.PositionNode puts a location outside every range on the closest lane.
+func (l *linter) lintLocations() {
+	// The shapes of the layout, or every shape with lanes without a layout.
+	var shapes []string
+	for _, p := range l.data.Layout {
+		if !slices.Contains(shapes, p.Shape) {
+			shapes = append(shapes, p.Shape)
+		}
+	}
+	if len(l.data.Layout) == 0 {
+		shapes = l.sortedShapes()
+	}
+	slices.Sort(shapes)
+
+	for _, shape := range shapes {
+		definition, ok := l.data.Shapes[shape]
+		if !ok || len(definition.Lanes) == 0 {
+			continue // undeclared shapes are reported with the layout
+		}
+
+		maxLocation := 0
+		for _, lane := range definition.Lanes {
+			maxLocation = max(maxLocation, lane.To)
+		}
+		var missing []int
+		for location := 1; location <= maxLocation; location++ {
+			if !slices.ContainsFunc(definition.Lanes, func(lane LaneSegment) bool {
+				return lane.Name != "" && lane.From <= location && location <= lane.To
+			}) {
+				missing = append(missing, location)
+			}
+		}
+		if len(missing) > 0 {
+			l.report(l.line(fmt.Sprintf("$.shapes.%s", shape)), "shape %s: track locations %s are in no lane, they go to the closest one", shape, locationRanges(missing))
+		}
+	}
+}
+
+// lintNode checks a node name against the shapes and the layout.
+func (l *linter) lintNode(node string, line int) {
+	parts := strings.Split(node, ".")
+	if len(parts) != 3 || len(parts[0]) != 2 {
+		l.report(line, "%s: expected a node named id.shape.lane, e.g. 13.curve.outer", node)
+		return
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		l.report(line, "%s: %q is not a piece id", node, parts[0])
+		return
+	}
+
+	shape, lane := parts[1], parts[2]
+	if len(l.data.Layout) > 0 {
+		if p, ok := l.track.Piece(id); !ok {
+			l.report(line, "%s: piece %d is not in the layout", node, id)
+		} else if p.Shape != shape {
+			l.report(line, "%s: piece %d has the shape %s in the layout, not %s", node, id, p.Shape, shape)
+		}
+	}
+	definition, ok := l.data.Shapes[shape]
+	if !ok {
+		l.report(line, "%s: shape %s is not declared", node, shape)
+		return
+	}
+	if !slices.ContainsFunc(definition.Lanes, func(l LaneSegment) bool { return l.Name == lane }) {
+		l.report(line, "%s: shape %s has no lane %s", node, shape, lane)
+	}
+}
+
+// lintEdges checks the node names and the duplicate edges. It returns the first line of every node.
+func (l *linter) lintEdges() map[string]int {
+	firstLine := map[string]int{}
+	seen := map[[2]string]int{}
+
+	for i, e := range l.data.Edges {
+		sourceLine := l.edgeLine(i, "source")
+		targetLine := l.edgeLine(i, "target")
+
+		for _, n := range []struct {
+			node string
+			line int
+		}{{e.Source, sourceLine}, {e.Target, targetLine}} {
+			if _, ok := firstLine[n.node]; !ok {
+				firstLine[n.node] = n.line
+				l.lintNode(n.node, n.line)
+			}
+		}
+
+		if e.Source == e.Target {
+			l.report(sourceLine, "edge from %s to itself", e.Source)
+			continue
+		}
+		// The graph adds the reversed edges, so a reversed edge is a duplicate too.
+		key := [2]string{min(e.Source, e.Target), max(e.Source, e.Target)}
+		if line, ok := seen[key]; ok {
+			l.report(sourceLine, "duplicate edge %s -> %s, already on line %d", e.Source, e.Target, line)
+			continue
+		}
+		seen[key] = sourceLine
+	}
+	return firstLine
+}
+
+// lintConnectivity reports the disconnected components and the nodes a car cannot reach or leave.
+func (l *linter) lintConnectivity(firstLine map[string]int) {
+	if len(l.data.Edges) == 0 {
+		l.report(l.line("$.edges"), "the track has no edges")
+		return
+	}
+
+	// Components of the undirected graph of the lane segments.
+	neighbours := map[string][]string{}
+	for _, e := range l.data.Edges {
+		neighbours[e.Source] = append(neighbours[e.Source], e.Target)
+		neighbours[e.Target] = append(neighbours[e.Target], e.Source)
+	}
+	var components [][]string
+	visited := map[string]bool{}
+	for _, start := range l.sortedNodes(firstLine) {
+		if visited[start] {
+			continue
+		}
+		component := []string{}
+		stack := []string{start}
+		visited[start] = true
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			component = append(component, node)
+			for _, n := range neighbours[node] {
+				if !visited[n] {
+					visited[n] = true
+					stack = append(stack, n)
+				}
+			}
+		}
+		components = append(components, component)
+	}
+	sort.SliceStable(components, func(i, j int) bool { return len(components[i]) > len(components[j]) })
+	for _, component := range components[1:] {
+		slices.SortFunc(component, func(a, b string) int { return firstLine[a] - firstLine[b] })
+		l.report(firstLine[component[0]], "%v are disconnected from the rest of the track", component)
+	}
+
+	// Directed nodes outside the main strongly connected component: a car can get there and not
+	// come back, or never get there.
+	// The edges buildGraph cannot add are the duplicates, reported by lintEdges.
+	g, _ := buildGraph(l.data)
+	sccs, err := graph.StronglyConnectedComponents(g)
+	if err != nil {
+		l.report(0, "could not compute the strongly connected components: %v", err)
+		return
+	}
+	// The largest component is the main one, ties broken by node name so the report is stable.
+	for _, scc := range sccs {
+		slices.Sort(scc)
+	}
+	sort.SliceStable(sccs, func(i, j int) bool {
+		if len(sccs[i]) != len(sccs[j]) {
+			return len(sccs[i]) > len(sccs[j])
+		}
+		return sccs[i][0] < sccs[j][0]
+	})
+	adjacency, _ := g.AdjacencyMap()
+	predecessors, _ := g.PredecessorMap()
+	fromMain := reachable(sccs[0][0], adjacency)
+	toMain := reachable(sccs[0][0], predecessors)
+	for _, scc := range sccs[1:] {
+		for _, node := range scc {
+			segment, _ := SplitNode(node)
+			switch {
+			case len(adjacency[node]) == 0:
+				l.report(firstLine[segment], "%s is a dead end: a car cannot leave it", node)
+			case !fromMain[node]:
+				l.report(firstLine[segment], "%s cannot be reached from the rest of the track", node)
+			case !toMain[node]:
+				l.report(firstLine[segment], "%s does not lead back to the rest of the track", node)
+			}
+		}
+	}
+}
+
+// reachable returns the nodes reachable from start by following the edges of the map.
+func reachable(start string, edges map[string]map[string]graph.Edge[string]) map[string]bool {
+	visited := map[string]bool{start: true}
+	stack := []string{start}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for next := range edges[node] {
+			if !visited[next] {
+				visited[next] = true
+				stack = append(stack, next)
+			}
+		}
+	}
+	return visited
+}
+
+// sortedNodes returns the nodes in the order of their first line.
+func (l *linter) sortedNodes(firstLine map[string]int) []string {
+	nodes := make([]string, 0, len(firstLine))
+	for node := range firstLine {
+		nodes = append(nodes, node)
+	}
+	slices.SortFunc(nodes, func(a, b string) int {
+		if firstLine[a] != firstLine[b] {
+			return firstLine[a] - firstLine[b]
+		}
+		return strings.Compare(a, b)
+	})
+	return nodes
+}
+
+// lintGrid reports the pieces of the layout without any node in the graph. The pass-through
+// pieces (the crossing) are not part of the graph, the cars drive across them between the pieces
+// around them.
+func (l *linter) lintGrid(firstLine map[string]int) {
+	pieces := map[int]bool{}
+	for node := range firstLine {
+		if id, err := strconv.Atoi(node[:min(2, len(node))]); err == nil {
+			pieces[id] = true
+		}
+	}
+	for i, p := range l.data.Layout {
+		if l.data.Shapes[p.Shape].PassThrough {
+			continue
+		}
+		if !pieces[p.ID] {
+			l.report(l.line(fmt.Sprintf("$.layout[%d]", i)), "piece %d is in the layout but has no node in the graph", p.ID)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package path
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLintTrack(t *testing.T) {
+	const shapes = `shapes:
+  straight:
+    lanes:
+      - {name: a, from: 1, to: 2, length: 560}
+      - {name: b, from: 3, to: 4, length: 560}
+`
+	// A car drives from piece 01 to 02 and turns around to drive back.
+	const shuttle = `uTurn: {shapes: [straight], cost: 500}
+edges:
+  - {source: 01.straight.a, target: 02.straight.a}
+`
+	tests := []struct {
+		name  string
+		track string
+		want  []LintProblem // the messages only have to contain the wanted ones
+	}{
+		{
+			name:  "ring",
+			track: ringTrack,
+		},
+		{
+			name:  "shuttle",
+			track: shapes + shuttle,
+		},
+		{
+			name:  "no edges",
+			track: shapes,
+			want:  []LintProblem{{0, "the track has no edges"}},
+		},
+		{
+			name: "dead end",
+			track: shapes + `edges:
+  - {source: 01.straight.a, target: 02.straight.a}
+`,
+			want: []LintProblem{
+				{7, "01.straight.a.reverse is a dead end: a car cannot leave it"},
+				{7, "02.straight.a.forward is a dead end: a car cannot leave it"},
+				{7, "02.straight.a.reverse cannot be reached from the rest of the track"},
+			},
+		},
+		{
+			name: "duplicate edge",
+			track: shapes + shuttle + `  - {source: 01.straight.a, target: 02.straight.a}
+  - {source: 02.straight.a, target: 01.straight.a}
+`,
+			want: []LintProblem{
+				{9, "duplicate edge 01.straight.a -> 02.straight.a, already on line 8"},
+				{10, "duplicate edge 02.straight.a -> 01.straight.a, already on line 8"},
+			},
+		},
+		{
+			name: "edge to itself",
+			track: shapes + shuttle + `  - {source: 01.straight.b, target: 01.straight.b}
+`,
+			want: []LintProblem{{9, "edge from 01.straight.b to itself"}},
+		},
+		{
+			name: "node names",
+			track: shapes + shuttle + `  - {source: 01.straight.c, target: 02.bend.a}
+  - {source: xx.straight.a, target: 01.straight}
+`,
+			want: []LintProblem{
+				{9, "01.straight.c: shape straight has no lane c"},
+				{9, "02.bend.a: shape bend is not declared"},
+				{10, `xx.straight.a: "xx" is not a piece id`},
+				{10, "01.straight: expected a node named id.shape.lane, e.g. 13.curve.outer"},
+			},
+		},
+		{
+			name: "disconnected",
+			track: shapes + shuttle + `  - {source: 03.straight.a, target: 04.straight.a}
+`,
+			want: []LintProblem{{9, "[03.straight.a 04.straight.a] are disconnected from the rest of the track"}},
+		},
+		{
+			name: "lanes",
+			track: `shapes:
+  straight:
+    lanes:
+      - {name: a, from: 1, to: 4, length: 560}
+      - {name: b, from: 3, to: 6, length: 560}
+      - {name: c, from: 8, to: 7, length: 560}
+      - {from: 9, to: 10, length: 560}
+` + shuttle,
+			want: []LintProblem{
+				{5, "shape straight: lane b (3-6) overlaps lane a (1-4)"},
+				{6, "shape straight: lane c goes from 8 to 7"},
+				{3, "shape straight: lane 4 has no name"},
+			},
+		},
+		{
+			name: "lengths",
+			track: `shapes:
+  straight:
+    lanes:
+      - {name: a, from: 1, to: 2}
+      - {name: b, from: 3, to: 4}
+` + shuttle,
+			want: []LintProblem{{2, "lanes straight.a, straight.b have no length, routes count them as 560 mm"}},
+		},
+		{
+			name: "locations",
+			track: `shapes:
+  straight:
+    lanes:
+      - {name: a, from: 1, to: 2, length: 560}
+      - {name: b, from: 5, to: 6, length: 560}
+` + shuttle,
+			want: []LintProblem{{3, "shape straight: track locations 3-4 are in no lane, they go to the closest one"}},
+		},
+		{
+			name:  "layout",
+			track: strings.Replace(ringTrack, "rotation: 90", "rotation: 45", 1),
+			want: []LintProblem{
+				{12, "piece 2: rotation 45 is not 0, 90, 180 or 270"},
+				{11, "piece 1: lane 01.curve.inner ends on the east side, where there is no piece"},
+			},
+		},
+		{
+			name: "layout and edges",
+			track: ringTrack + `edges:
+  - {source: 01.curve.inner, target: 02.curve.inner}
+  - {source: 05.curve.inner, target: 01.curve.inner}
+  - {source: 02.straight.a, target: 03.curve.inner}
+`,
+			want: []LintProblem{
+				{16, "duplicate edge 01.curve.inner -> 02.curve.inner, already on line 11"},
+				{17, "05.curve.inner: piece 5 is not in the layout"},
+				{18, "02.straight.a: piece 2 has the shape curve in the layout, not straight"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := LintTrack([]byte(tt.track))
+			if err != nil {
+				t.Fatalf("LintTrack: %v", err)
+			}
+			if tt.want == nil && len(problems) > 0 {
+				t.Fatalf("LintTrack = %v, want no problem", problems)
+			}
+			for _, want := range tt.want {
+				if !hasProblem(problems, want) {
+					t.Errorf("LintTrack = %v, want %v", problems, want)
+				}
+			}
+		})
+	}
+}
+
+// hasProblem tells whether a problem is on the line of want and its message contains the message of want.
+func hasProblem(problems []LintProblem, want LintProblem) bool {
+	for _, p := range problems {
+		if p.Line == want.Line && strings.Contains(p.Message, want.Message) {
+			return true
+		}
+	}
+	return false
+}
+
+// The track of the lab is kept free of problems.
+func TestLintAssets(t *testing.T) {
+	b, err := os.ReadFile("../../assets/track.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err := LintTrack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Error(p)
+	}
+}
+
+func TestLintTrackSyntax(t *testing.T) {
+	if _, err := LintTrack([]byte("shapes: [\n")); err == nil {
+		t.Error("LintTrack of invalid YAML: no error")
+	}
+}
@@ -0,0 +1,127 @@
+package path
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/dominikbraun/graph"
+	"github.com/dominikbraun/graph/draw"
+	"github.com/goccy/go-yaml"
+)
+
+// Track is a loaded track definition with its directed graph.
+type Track struct {
+	Name   string // layout name, or the file name
+	Dir    string // directory of the track file, the tile images are relative to it
+	Config TrackConfig
+	Graph  graph.Graph[string, string]
+}
+
+// LoadTrack reads a track definition (the format of assets/track.yml) and builds its graph.
+// The edges are generated from the layout of the pieces, if the definition has one.
+func LoadTrack(r io.Reader) (*Track, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var config TrackConfig
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+	if len(config.Layout) > 0 {
+		edges, err := GenerateEdges(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid layout: %w", err)
+		}
+		config.Edges = append(edges, config.Edges...)
+	}
+	if len(config.Edges) == 0 {
+		return nil, errors.New("the track has no edges")
+	}
+	g, err := buildGraph(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid edges: %w", err)
+	}
+	return &Track{Config: config, Graph: g}, nil
+}
+
+// LoadTrackFile loads the track definition of a file.
+func LoadTrackFile(path string) (*Track, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	track, err := LoadTrack(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	track.Name = filepath.Base(path)
+	track.Dir = filepath.Dir(path)
+	return track, nil
+}
+
+// LayoutConfig lists the named track layouts, e.g. one per lab or exercise.
+// The paths of the layouts are relative to the layout file.
+type LayoutConfig struct {
+	Default string            `yaml:"default"`
+	Layouts map[string]string `yaml:"layouts"` // name -> track definition
+}
+
+// LoadLayout loads a named layout of a layout file. An empty name selects the default layout.
+func LoadLayout(configPath, name string) (*Track, error) {
+	b, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var config LayoutConfig
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", configPath, err)
+	}
+
+	if name == "" {
+		name = config.Default
+	}
+	file, ok := config.Layouts[name]
+	if !ok {
+		names := make([]string, 0, len(config.Layouts))
+		for n := range config.Layouts {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("%s: unknown layout %q (available: %v)", configPath, name, names)
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(configPath), file)
+	}
+
+	track, err := LoadTrackFile(file)
+	if err != nil {
+		return nil, err
+	}
+	track.Name = name
+	return track, nil
+}
+
+// WriteDOT writes the graph of the track in the Graphviz DOT format.
+func (t *Track) WriteDOT(w io.Writer) error {
+	return draw.DOT(t.Graph, w)
+}
+
+// ExportDOT writes the graph of the track to a DOT file, e.g. assets/track-graph.gv.
+func (t *Track) ExportDOT(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := t.WriteDOT(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
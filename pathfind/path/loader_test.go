@@ -0,0 +1,74 @@
+package path
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+)
+
+// ringTrack is a loop of four curves on a 2x2 grid, each with two lanes a vehicle can change
+// between and turn around on, driven clockwise: 01 (top left) -> 02 -> 03 -> 04 -> 01.
+const ringTrack = `shapes:
+  curve:
+    lanes:
+      - {name: inner, from: 1, to: 4, length: 400, entry: "S:right", exit: "E:left"}
+      - {name: outer, from: 5, to: 8, length: 600, entry: "S:left", exit: "E:right"}
+    laneChanges:
+      - [inner, outer]
+laneChangeCost: 100
+uTurn: {shapes: [curve], cost: 1000}
+layout:
+  - {id: 1, shape: curve, row: 0, col: 0, rotation: 0}
+  - {id: 2, shape: curve, row: 0, col: 1, rotation: 90}
+  - {id: 3, shape: curve, row: 1, col: 1, rotation: 180}
+  - {id: 4, shape: curve, row: 1, col: 0, rotation: 270}
+`
+
+// ringConfig returns the definition of ringTrack, without its generated edges.
+func ringConfig(t *testing.T) TrackConfig {
+	t.Helper()
+	var config TrackConfig
+	if err := yaml.Unmarshal([]byte(ringTrack), &config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// loadRing returns ringTrack loaded with its graph.
+func loadRing(t *testing.T) *Track {
+	t.Helper()
+	track, err := LoadTrack(strings.NewReader(ringTrack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return track
+}
+
+func TestLoadTrack(t *testing.T) {
+	const straight = "shapes:\n  straight:\n    lanes:\n      - {name: a, from: 1, to: 2, length: 560}\n"
+	tests := []struct {
+		name    string
+		track   string
+		wantErr string // empty: no error
+	}{
+		{"layout", ringTrack, ""},
+		{"edges", straight + "edges:\n  - {source: 01.straight.a, target: 02.straight.a}\n  - {source: 02.straight.a, target: 01.straight.a}\n", ""},
+		{"no edges", "shapes: {}\n", "the track has no edges"},
+		{"duplicate edge", straight + "edges:\n  - {source: 01.straight.a, target: 02.straight.a}\n  - {source: 01.straight.a, target: 02.straight.a}\n", "invalid edges: edge 01.straight.a.forward -> 02.straight.a.forward"},
+		{"invalid layout", strings.Replace(ringTrack, "rotation: 90", "rotation: 45", 1), "invalid layout: piece 2: rotation 45"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track, err := LoadTrack(strings.NewReader(tt.track))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("LoadTrack: %v", err)
+			case tt.wantErr == "" && track.Graph == nil:
+				t.Fatal("LoadTrack returned no graph")
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("LoadTrack error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
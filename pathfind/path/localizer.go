@@ -0,0 +1,279 @@
+package path
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"time"
+
+	"github.com/dominikbraun/graph"
+)
+
+// Parameters of the localizer.
+const (
+	localizerParticles = 300
+	// defaultDetection is the probability that a car driving a lane reports a track event on it,
+	// for the lanes without a detection in track.yml.
+	defaultDetection = 0.9
+	// Likelihoods of a track event for a particle that is not on the reported node: on the node
+	// after it (the car is ahead of the particle), on the same lane in the other direction, on
+	// another lane of the same piece (a misread location) or on another piece (a misread event).
+	likelihoodNext      = 0.8
+	likelihoodDirection = 0.3
+	likelihoodLane      = 0.2
+	likelihoodPiece     = 0.01
+	// injectFraction of the particles are put back on the reported node at every track event, so
+	// that the localizer recovers when no particle was there.
+	injectFraction = 0.05
+	// speedNoise is the standard deviation of the speed of the particles, relative to the commanded velocity.
+	speedNoise = 0.15
+)
+
+// particle is a guess of the position of a vehicle: a node, the distance driven on it and the
+// node it drives to next.
+type particle struct {
+	node, next string
+	offset     float64 // mm driven on node
+	speed      float64 // factor of the commanded velocity
+	seen       bool    // a track event was reported on node
+	weight     float64
+}
+
+// Localizer estimates the node of a vehicle with a particle filter over the track graph. The
+// particles drive along the graph at the commanded velocity; a track event weighs them by how
+// well they explain it, and a particle that leaves a node without an event is weighed by the
+// probability that the car drives the lane without reporting it.
+type Localizer struct {
+	track     *Track
+	adjacency map[string]map[string]graph.Edge[string]
+	rng       *rand.Rand
+	particles []particle
+	velocity  float64   // commanded velocity, mm/s
+	last      time.Time // time the particles were driven to
+}
+
+func NewLocalizer(track *Track, adjacency map[string]map[string]graph.Edge[string], velocity float64) *Localizer {
+	return &Localizer{
+		track:     track,
+		adjacency: adjacency,
+		rng:       rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		velocity:  velocity,
+	}
+}
+
+// SetVelocity sets the commanded velocity of the vehicle from now on. The direction of travel
+// comes from the track events, only the speed is used.
+func (l *Localizer) SetVelocity(velocity float64, now time.Time) {
+	l.Advance(now)
+	l.velocity = math.Abs(velocity)
+}
+
+// nodeLength returns the length of the lane segment of a node, in mm.
+func (l *Localizer) nodeLength(node string) float64 {
+	segment, _ := SplitNode(node)
+	length, ok := l.track.Config.segmentLength(segment)
+	if !ok {
+		length = defaultSegmentLength
+	}
+	return float64(length)
+}
+
+// sampleNext draws the node a particle drives to after a node. Lane changes and U-turns take an
+// instruction, they are only drawn when the node has no other successor.
+func (l *Localizer) sampleNext(node string) string {
+	var straight, other []string
+	for next := range l.adjacency[node] {
+		if nodePiece(next) == nodePiece(node) {
+			other = append(other, next)
+		} else {
+			straight = append(straight, next)
+		}
+	}
+	candidates := straight
+	if len(candidates) == 0 {
+		candidates = other
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Strings(candidates) // map order is random, the draw must only depend on rng
+	return candidates[l.rng.IntN(len(candidates))]
+}
+
+func (l *Localizer) newParticle(node string, weight float64) particle {
+	return particle{
+		node:   node,
+		next:   l.sampleNext(node),
+		speed:  math.Max(0.2, 1+l.rng.NormFloat64()*speedNoise),
+		seen:   true,
+		weight: weight,
+	}
+}
+
+// enter moves a particle to its next node.
+func (l *Localizer) enter(p *particle) {
+	p.node, p.next, p.seen = p.next, l.sampleNext(p.next), false
+}
+
+// Advance drives the particles at the commanded velocity until now.
+func (l *Localizer) Advance(now time.Time) {
+	if l.last.IsZero() || len(l.particles) == 0 {
+		l.last = now
+		return
+	}
+	dt := now.Sub(l.last).Seconds()
+	l.last = now
+	if dt <= 0 || l.velocity == 0 {
+		return
+	}
+	for i := range l.particles {
+		p := &l.particles[i]
+		p.offset += l.velocity * p.speed * dt
+		for p.next != "" && p.offset >= l.nodeLength(p.node) {
+			if !p.seen {
+				p.weight *= 1 - l.track.Config.detection(p.node)
+			}
+			p.offset -= l.nodeLength(p.node)
+			l.enter(p)
+		}
+		if p.next == "" {
+			p.offset = math.Min(p.offset, l.nodeLength(p.node))
+		}
+	}
+	l.normalize()
+}
+
+// Observe weighs the particles with a track event reported on a node at a time.
+func (l *Localizer) Observe(node string, now time.Time) {
+	l.Advance(now)
+	if len(l.particles) == 0 {
+		l.particles = make([]particle, localizerParticles)
+		for i := range l.particles {
+			l.particles[i] = l.newParticle(node, 1.0/localizerParticles)
+		}
+		return
+	}
+
+	segment, direction := SplitNode(node)
+	for i := range l.particles {
+		p := &l.particles[i]
+		pSegment, pDirection := SplitNode(p.node)
+		switch {
+		case p.node == node:
+			p.seen = true
+		case p.next == node:
+			p.weight *= likelihoodNext
+			p.offset = 0
+			l.enter(p)
+			p.seen = true
+		case pSegment == segment && pDirection != direction:
+			p.weight *= likelihoodDirection
+			p.node, p.next, p.seen = node, l.sampleNext(node), true
+			p.offset = l.nodeLength(node) - p.offset
+		case nodePiece(p.node) == nodePiece(node):
+			p.weight *= likelihoodLane
+		default:
+			p.weight *= likelihoodPiece
+		}
+	}
+
+	// The least likely particles are put back on the reported node.
+	sort.Slice(l.particles, func(i, j int) bool { return l.particles[i].weight < l.particles[j].weight })
+	mean := 0.0
+	for _, p := range l.particles {
+		mean += p.weight
+	}
+	mean /= float64(len(l.particles))
+	for i := range int(injectFraction * localizerParticles) {
+		l.particles[i] = l.newParticle(node, mean)
+	}
+	l.normalize()
+	l.resample()
+}
+
+func (l *Localizer) normalize() {
+	total := 0.0
+	for _, p := range l.particles {
+		total += p.weight
+	}
+	if total == 0 {
+		for i := range l.particles {
+			l.particles[i].weight = 1 / float64(len(l.particles))
+		}
+		return
+	}
+	for i := range l.particles {
+		l.particles[i].weight /= total
+	}
+}
+
+// resample draws the particles again by weight when few of them carry most of it.
+func (l *Localizer) resample() {
+	sumSquares := 0.0
+	for _, p := range l.particles {
+		sumSquares += p.weight * p.weight
+	}
+	n := len(l.particles)
+	if 1/sumSquares >= float64(n)/2 {
+		return
+	}
+	resampled := make([]particle, n)
+	step := 1 / float64(n)
+	u := l.rng.Float64() * step
+	cumulative, j := l.particles[0].weight, 0
+	for i := range resampled {
+		for u > cumulative && j < n-1 {
+			j++
+			cumulative += l.particles[j].weight
+		}
+		p := l.particles[j]
+		p.weight = step
+		p.speed = math.Max(0.2, p.speed+l.rng.NormFloat64()*speedNoise/4)
+		resampled[i] = p
+		u += step
+	}
+	l.particles = resampled
+}
+
+// Belief returns the probability of every node a particle is on.
+func (l *Localizer) Belief() map[string]float64 {
+	belief := map[string]float64{}
+	for _, p := range l.particles {
+		belief[p.node] += p.weight
+	}
+	return belief
+}
+
+// Estimate returns the most likely node of the vehicle and its probability, the confidence.
+// It returns false before the first track event.
+func (l *Localizer) Estimate() (string, float64, bool) {
+	return argmax(l.Belief())
+}
+
+// NextPiece returns the most likely piece the vehicle drives onto after its node, and its probability.
+func (l *Localizer) NextPiece() (int, float64, bool) {
+	pieces := map[int]float64{}
+	for _, p := range l.particles {
+		// After a lane change or a U-turn the next piece is not known yet.
+		if p.next != "" && nodePiece(p.next) != nodePiece(p.node) {
+			pieces[nodePiece(p.next)] += p.weight
+		}
+	}
+	best, probability := 0, 0.0
+	for piece, w := range pieces {
+		if w > probability || w == probability && piece < best {
+			best, probability = piece, w
+		}
+	}
+	return best, probability, best != 0
+}
+
+func argmax(belief map[string]float64) (string, float64, bool) {
+	best, probability := "", 0.0
+	for node, w := range belief {
+		if w > probability || w == probability && node < best {
+			best, probability = node, w
+		}
+	}
+	return best, probability, best != ""
+}
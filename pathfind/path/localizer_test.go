@@ -0,0 +1,101 @@
+package path
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// The particles are drawn at random: the tests only check what holds for nearly all of them.
+func TestLocalizer(t *testing.T) {
+	track := loadRing(t)
+	adjacency, err := track.Graph.AdjacencyMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	at := func(seconds float64) time.Time { return start.Add(time.Duration(seconds * float64(time.Second))) }
+
+	type step struct {
+		at       float64 // s after start
+		observe  string  // node of a track event, or empty
+		velocity float64 // commanded velocity from then on, if observe is empty
+	}
+	tests := []struct {
+		name      string
+		steps     []step
+		wantNode  string
+		wantPiece int // next piece
+	}{
+		{
+			name:      "first event",
+			steps:     []step{{0, "01.curve.inner.forward", 0}},
+			wantNode:  "01.curve.inner.forward",
+			wantPiece: 2,
+		},
+		{
+			name:      "next event",
+			steps:     []step{{0, "01.curve.inner.forward", 0}, {1, "02.curve.inner.forward", 0}},
+			wantNode:  "02.curve.inner.forward",
+			wantPiece: 3,
+		},
+		{
+			// 600 mm at 400 mm/s: on the middle of the next piece, without its event yet.
+			name:      "driving",
+			steps:     []step{{0, "01.curve.inner.forward", 0}, {1.5, "", 400}},
+			wantNode:  "02.curve.inner.forward",
+			wantPiece: 3,
+		},
+		{
+			// Stopped from the first event on: the car is still there when it reports it again.
+			name:      "held",
+			steps:     []step{{0, "01.curve.inner.forward", 0}, {0, "", 0}, {6, "01.curve.inner.forward", 0}},
+			wantNode:  "01.curve.inner.forward",
+			wantPiece: 2,
+		},
+		{
+			name:      "turned around",
+			steps:     []step{{0, "02.curve.inner.forward", 0}, {0.5, "02.curve.inner.reverse", 0}},
+			wantNode:  "02.curve.inner.reverse",
+			wantPiece: 1,
+		},
+		{
+			// A car changes lane on an instruction only: an event on the other lane is a misread location.
+			name:      "other lane",
+			steps:     []step{{0, "02.curve.inner.forward", 0}, {0.5, "02.curve.outer.forward", 0}},
+			wantNode:  "02.curve.inner.forward",
+			wantPiece: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLocalizer(track, adjacency, 400)
+			if _, _, ok := l.Estimate(); ok {
+				t.Fatal("Estimate before the first event")
+			}
+			for _, s := range tt.steps {
+				if s.observe != "" {
+					l.Observe(s.observe, at(s.at))
+				} else {
+					l.SetVelocity(s.velocity, at(s.at))
+				}
+			}
+
+			node, confidence, ok := l.Estimate()
+			if !ok || node != tt.wantNode || confidence < 0.6 {
+				t.Errorf("Estimate = %s, %.2f, %v, want %s", node, confidence, ok, tt.wantNode)
+			}
+			piece, probability, ok := l.NextPiece()
+			if !ok || piece != tt.wantPiece || probability < 0.6 {
+				t.Errorf("NextPiece = %d, %.2f, %v, want %d", piece, probability, ok, tt.wantPiece)
+			}
+			total := 0.0
+			for _, p := range l.Belief() {
+				total += p
+			}
+			if math.Abs(total-1) > 1e-9 {
+				t.Errorf("Belief sums to %v", total)
+			}
+		})
+	}
+}
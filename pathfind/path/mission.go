@@ -0,0 +1,406 @@
+package path
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hyperdrive/remote/pathfind/instruct"
+	"hyperdrive/remote/pathfind/util"
+	"hyperdrive/remote/topic"
+	"log"
+	"math"
+	"time"
+
+	"github.com/dominikbraun/graph"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Per-vehicle topics of the missions, {vehicle} is the car ID.
+const (
+	MissionTopic         topic.Template = util.RootTopic + "/vehicle/{vehicle}/mission"
+	MissionProgressTopic topic.Template = util.RootTopic + "/vehicle/{vehicle}/mission/progress"
+)
+
+// Kinds of missions.
+const (
+	MissionWaypoints = "waypoints" // the waypoints in the given order, once
+	MissionPatrol    = "patrol"    // the waypoints in the given order, again and again
+	MissionTour      = "tour"      // every waypoint once, in the order with the shortest route
+)
+
+// States of a mission.
+const (
+	MissionDriving   = "driving"   // driving to the current waypoint
+	MissionDwelling  = "dwelling"  // stopped at the current waypoint for its dwell time
+	MissionDone      = "done"      // every waypoint was visited
+	MissionCancelled = "cancelled" // replaced by a mission without waypoints
+	MissionFailed    = "failed"    // the mission is invalid, see the error
+)
+
+// heldKarpLimit is the number of waypoints up to which the shortest tour is searched exactly.
+const heldKarpLimit = 12
+
+// Waypoint is a piece a mission visits, with the time the vehicle stays stopped there.
+type Waypoint struct {
+	Piece int     `json:"piece"`
+	Dwell float64 `json:"dwell,omitempty"` // seconds, the dwell of the mission if 0
+}
+
+// Mission sends a vehicle to several pieces. A mission without waypoints cancels the current one.
+type Mission struct {
+	Kind      string     `json:"kind,omitempty"` // MissionWaypoints when empty
+	Waypoints []Waypoint `json:"waypoints"`
+	Dwell     float64    `json:"dwell,omitempty"` // seconds, for the waypoints without their own
+}
+
+// MissionProgress is the state of the mission of a vehicle, published retained.
+type MissionProgress struct {
+	State   string `json:"state"`
+	Kind    string `json:"kind,omitempty"`
+	Order   []int  `json:"order,omitempty"` // pieces in the order they are visited
+	Current int    `json:"current"`         // index in Order of the waypoint driven to or dwelt at
+	Visited int    `json:"visited"`         // waypoints reached since the start
+	Lap     int    `json:"lap,omitempty"`   // laps completed by a patrol
+	Error   string `json:"error,omitempty"`
+}
+
+// Validate checks a mission against a track: its kind, its dwell times and its waypoints, which must be targets.
+func (m Mission) Validate(track *Track) error {
+	var errs []error
+	switch m.Kind {
+	case "", MissionWaypoints, MissionTour:
+	case MissionPatrol:
+		if len(m.Waypoints) < 2 {
+			errs = append(errs, errors.New("a patrol needs two waypoints or more"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown kind %q, expected %s, %s or %s", m.Kind, MissionWaypoints, MissionPatrol, MissionTour))
+	}
+	if m.Dwell < 0 {
+		errs = append(errs, fmt.Errorf("negative dwell %v", m.Dwell))
+	}
+	for i, w := range m.Waypoints {
+		if !track.IsTarget(w.Piece) {
+			errs = append(errs, fmt.Errorf("waypoint %d: a car cannot be sent to piece %d", i+1, w.Piece))
+		}
+		if w.Dwell < 0 {
+			errs = append(errs, fmt.Errorf("waypoint %d: negative dwell %v", i+1, w.Dwell))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TourOrder returns the order to visit lane segments in with the shortest route from a node, and
+// the length of that route in mm. From an empty node, the tour may start at any segment. The
+// order is exact up to heldKarpLimit segments, a nearest neighbour tour improved by 2-opt above.
+func TourOrder(g graph.Graph[string, string], from string, segments []string) ([]int, int, error) {
+	predecessors, err := g.PredecessorMap()
+	if err != nil {
+		return nil, 0, err
+	}
+	n := len(segments)
+	const unreachable = math.MaxInt / 4
+	start := make([]int, n)  // from the node to each segment
+	cost := make([][]int, n) // from each segment to each other
+	for j, segment := range segments {
+		dist := distancesTo(predecessors, segment)
+		start[j] = 0
+		if from != "" {
+			d, ok := dist[from]
+			if !ok {
+				return nil, 0, fmt.Errorf("no route from %s to %s", from, segment)
+			}
+			start[j] = d
+		}
+		for i := range segments {
+			if cost[i] == nil {
+				cost[i] = make([]int, n)
+			}
+			cost[i][j] = unreachable
+			for _, direction := range []string{Forward, Reverse} {
+				if d, ok := dist[DirectedNode(segments[i], direction)]; ok && d < cost[i][j] {
+					cost[i][j] = d
+				}
+			}
+		}
+	}
+
+	var order []int
+	if n <= heldKarpLimit {
+		order = heldKarp(start, cost)
+	} else {
+		order = twoOpt(start, cost, nearestNeighbour(start, cost))
+	}
+	length := tourLength(start, cost, order)
+	if length >= unreachable {
+		return nil, 0, errors.New("the waypoints cannot all be reached from each other")
+	}
+	return order, length, nil
+}
+
+func tourLength(start []int, cost [][]int, order []int) int {
+	if len(order) == 0 {
+		return 0
+	}
+	length := start[order[0]]
+	for i := 1; i < len(order); i++ {
+		length += cost[order[i-1]][order[i]]
+	}
+	return length
+}
+
+// heldKarp returns the shortest open tour by dynamic programming over the subsets of the segments.
+func heldKarp(start []int, cost [][]int) []int {
+	n := len(start)
+	if n == 0 {
+		return nil
+	}
+	full := 1<<n - 1
+	best := make([][]int, 1<<n) // best[set][last]: shortest route visiting set, ending at last
+	prev := make([][]int, 1<<n)
+	for set := range best {
+		best[set], prev[set] = make([]int, n), make([]int, n)
+		for j := range best[set] {
+			best[set][j] = math.MaxInt
+		}
+	}
+	for j := range n {
+		best[1<<j][j] = start[j]
+	}
+	for set := 1; set <= full; set++ {
+		for last := range n {
+			if set&(1<<last) == 0 || best[set][last] == math.MaxInt {
+				continue
+			}
+			for next := range n {
+				if set&(1<<next) != 0 {
+					continue
+				}
+				d := best[set][last] + cost[last][next]
+				if d < best[set|1<<next][next] {
+					best[set|1<<next][next], prev[set|1<<next][next] = d, last
+				}
+			}
+		}
+	}
+	last := 0
+	for j := range n {
+		if best[full][j] < best[full][last] {
+			last = j
+		}
+	}
+	order := make([]int, n)
+	for set, i := full, n-1; i >= 0; i-- {
+		order[i] = last
+		set, last = set&^(1<<last), prev[set][last]
+	}
+	return order
+}
+
+// nearestNeighbour returns the tour that always drives to the closest segment not visited yet.
+func nearestNeighbour(start []int, cost [][]int) []int {
+	visited := make([]bool, len(start))
+	var order []int
+	for len(order) < len(start) {
+		next, best := -1, 0
+		for j := range start {
+			if visited[j] {
+				continue
+			}
+			d := start[j]
+			if len(order) > 0 {
+				d = cost[order[len(order)-1]][j]
+			}
+			if next < 0 || d < best {
+				next, best = j, d
+			}
+		}
+		visited[next] = true
+		order = append(order, next)
+	}
+	return order
+}
+
+// twoOpt shortens a tour by reversing parts of it while that helps. The track graph is directed,
+// so the lengths are computed again rather than updated.
+func twoOpt(start []int, cost [][]int, order []int) []int {
+	for improved := true; improved; {
+		improved = false
+		for i := 0; i < len(order)-1; i++ {
+			for j := i + 1; j < len(order); j++ {
+				candidate := append([]int(nil), order...)
+				for a, b := i, j; a < b; a, b = a+1, b-1 {
+					candidate[a], candidate[b] = candidate[b], candidate[a]
+				}
+				if tourLength(start, cost, candidate) < tourLength(start, cost, order) {
+					order, improved = candidate, true
+				}
+			}
+		}
+	}
+	return order
+}
+
+// missionRun is the mission a vehicle is running.
+type missionRun struct {
+	mission  Mission
+	order    []Waypoint // waypoints in the order they are visited
+	segments []string   // target segment of each waypoint
+	progress MissionProgress
+}
+
+// MissionProcess runs the missions of one vehicle until done is closed: it sends the vehicle to
+// its waypoints one after the other on its target topic, keeps it stopped at each of them for
+// its dwell time and publishes the progress of the mission, retained.
+func MissionProcess(client mqtt.Client, track *Track, vehicleID string, done <-chan struct{}) {
+	values := topic.Values{"vehicle": vehicleID}
+
+	missionTopic := MissionTopic.Format(values)
+	missionCh := make(chan Mission)
+	if token := client.Subscribe(missionTopic, 1, func(c mqtt.Client, m mqtt.Message) {
+		var mission Mission
+		if err := json.Unmarshal(m.Payload(), &mission); err != nil {
+			log.Println("[Mission] Could not unmarshal mission:", string(m.Payload()))
+			return
+		}
+		select {
+		case missionCh <- mission:
+		case <-done:
+		}
+	}); token.Wait() && token.Error() != nil {
+		log.Println("[Mission] Could not subscribe to", missionTopic, ":", token.Error())
+		return
+	}
+
+	positionTopic := vehiclePositionTopic.Format(values)
+	positionCh := make(chan string)
+	if token := client.Subscribe(positionTopic, 1, strChannel{positionCh, done}.positionTopicHandler); token.Wait() && token.Error() != nil {
+		log.Println("[Mission] Could not subscribe to", positionTopic, ":", token.Error())
+		return
+	}
+	defer client.Unsubscribe(missionTopic, positionTopic)
+
+	publish := func(p MissionProgress) {
+		data, _ := json.Marshal(p)
+		client.Publish(MissionProgressTopic.Format(values), 1, true, data)
+	}
+	sendTarget := func(run *missionRun) {
+		w := run.order[run.progress.Current]
+		log.Printf("[Mission] %s drives to waypoint %d/%d, piece %d", vehicleID, run.progress.Current+1, len(run.order), w.Piece)
+		util.SendJSON(client, vehicleTargetTopic.Format(values), tilePayload{w.Piece})
+	}
+
+	var (
+		run      *missionRun
+		position string
+		dwell    <-chan time.Time
+	)
+	// next sends the vehicle to the waypoint after the current one, or ends the mission.
+	next := func() {
+		run.progress.Current++
+		if run.progress.Current == len(run.order) {
+			if run.mission.Kind != MissionPatrol {
+				run.progress.State = MissionDone
+				log.Println("[Mission]", vehicleID, "completed its mission")
+				publish(run.progress)
+				run = nil
+				return
+			}
+			run.progress.Current = 0
+			run.progress.Lap++
+		}
+		run.progress.State = MissionDriving
+		publish(run.progress)
+		sendTarget(run)
+	}
+	// reached checks whether the vehicle is on the current waypoint.
+	reached := func() {
+		if run == nil || run.progress.State != MissionDriving {
+			return
+		}
+		if segment, _ := SplitNode(position); segment != run.segments[run.progress.Current] {
+			return
+		}
+		run.progress.Visited++
+		w := run.order[run.progress.Current]
+		seconds := w.Dwell
+		if seconds == 0 {
+			seconds = run.mission.Dwell
+		}
+		log.Printf("[Mission] %s reached piece %d, dwelling %.1fs", vehicleID, w.Piece, seconds)
+		if seconds <= 0 {
+			next()
+			return
+		}
+		run.progress.State = MissionDwelling
+		publish(run.progress)
+		util.SendJSON(client, instruct.InstructionTopic.Format(values), instruct.LaneChangeMessage{Forward: true, Wait: seconds})
+		dwell = time.After(time.Duration(seconds * float64(time.Second)))
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case mission := <-missionCh:
+			dwell = nil
+			if len(mission.Waypoints) == 0 {
+				if run != nil {
+					log.Println("[Mission]", vehicleID, "mission cancelled")
+					run.progress.State = MissionCancelled
+					publish(run.progress)
+					run = nil
+				}
+				continue
+			}
+			if mission.Kind == "" {
+				mission.Kind = MissionWaypoints
+			}
+			if err := mission.Validate(track); err != nil {
+				log.Println("[Mission] Invalid mission for", vehicleID, ":", err)
+				publish(MissionProgress{State: MissionFailed, Kind: mission.Kind, Error: err.Error()})
+				run = nil
+				continue
+			}
+
+			run = &missionRun{mission: mission, order: mission.Waypoints}
+			if mission.Kind == MissionTour {
+				segments := make([]string, len(mission.Waypoints))
+				for i, w := range mission.Waypoints {
+					segments[i], _ = track.TargetSegment(w.Piece)
+				}
+				order, length, err := TourOrder(track.Graph, position, segments)
+				if err != nil {
+					log.Println("[Mission] No tour for", vehicleID, ":", err)
+					publish(MissionProgress{State: MissionFailed, Kind: mission.Kind, Error: err.Error()})
+					run = nil
+					continue
+				}
+				run.order = make([]Waypoint, len(order))
+				for i, j := range order {
+					run.order[i] = mission.Waypoints[j]
+				}
+				log.Printf("[Mission] Tour of %s: %d waypoints, %d mm", vehicleID, len(order), length)
+			}
+			run.segments = make([]string, len(run.order))
+			run.progress = MissionProgress{State: MissionDriving, Kind: mission.Kind}
+			for i, w := range run.order {
+				run.segments[i], _ = track.TargetSegment(w.Piece)
+				run.progress.Order = append(run.progress.Order, w.Piece)
+			}
+			publish(run.progress)
+			sendTarget(run)
+			reached()
+
+		case position = <-positionCh:
+			reached()
+
+		case <-dwell:
+			dwell = nil
+			if run != nil && run.progress.State == MissionDwelling {
+				next()
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package path
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHeldKarp(t *testing.T) {
+	tests := []struct {
+		name  string
+		start []int
+		cost  [][]int
+		want  []int
+	}{
+		{"none", nil, nil, nil},
+		{"one", []int{7}, [][]int{{0}}, []int{0}},
+		{"closest first", []int{5, 1}, [][]int{{0, 3}, {3, 0}}, []int{1, 0}},
+		{
+			// Starting at the farthest segment makes the rest of the tour short.
+			name:  "one way",
+			start: []int{5, 0, 9},
+			cost:  [][]int{{0, 1, 100}, {100, 0, 1}, {1, 100, 0}},
+			want:  []int{1, 2, 0},
+		},
+		{
+			name:  "directed",
+			start: []int{0, 10, 10, 10},
+			cost:  [][]int{{0, 50, 1, 50}, {50, 0, 50, 50}, {50, 50, 0, 1}, {50, 1, 50, 0}},
+			want:  []int{0, 2, 3, 1},
+		},
+	}
+	for _, tt := range tests {
+		if got := heldKarp(tt.start, tt.cost); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: heldKarp = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// heldKarp finds the shortest tour, nearest neighbour improved by 2-opt a tour at least as long.
+func TestTourHeuristics(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for n := 1; n <= 7; n++ {
+		start := make([]int, n)
+		cost := make([][]int, n)
+		for i := range n {
+			start[i] = rng.IntN(1000)
+			cost[i] = make([]int, n)
+			for j := range n {
+				cost[i][j] = rng.IntN(1000)
+			}
+		}
+
+		shortest := -1
+		for _, order := range permutations(n) {
+			if length := tourLength(start, cost, order); shortest < 0 || length < shortest {
+				shortest = length
+			}
+		}
+		exact := heldKarp(start, cost)
+		if !isPermutation(exact, n) || tourLength(start, cost, exact) != shortest {
+			t.Errorf("n=%d: heldKarp = %v of length %d, want length %d", n, exact, tourLength(start, cost, exact), shortest)
+		}
+		heuristic := twoOpt(start, cost, nearestNeighbour(start, cost))
+		if !isPermutation(heuristic, n) || tourLength(start, cost, heuristic) < shortest {
+			t.Errorf("n=%d: twoOpt = %v of length %d, shortest %d", n, heuristic, tourLength(start, cost, heuristic), shortest)
+		}
+	}
+}
+
+// permutations returns all the orders of 0..n-1.
+func permutations(n int) [][]int {
+	if n == 0 {
+		return [][]int{{}}
+	}
+	var all [][]int
+	for _, p := range permutations(n - 1) {
+		for i := 0; i <= len(p); i++ {
+			all = append(all, slices.Insert(slices.Clone(p), i, n-1))
+		}
+	}
+	return all
+}
+
+func isPermutation(order []int, n int) bool {
+	sorted := slices.Sorted(slices.Values(order))
+	for i, v := range sorted {
+		if v != i {
+			return false
+		}
+	}
+	return len(order) == n
+}
+
+func TestTourOrder(t *testing.T) {
+	track := loadRing(t)
+	tests := []struct {
+		name       string
+		from       string
+		segments   []string
+		wantOrder  []int
+		wantLength int
+		wantErr    string
+	}{
+		{
+			name:       "ahead",
+			from:       "01.curve.inner.forward",
+			segments:   []string{"03.curve.inner", "02.curve.inner"},
+			wantOrder:  []int{1, 0},
+			wantLength: 800,
+		},
+		{
+			// Driving on around the ring is shorter than turning around.
+			name:       "behind",
+			from:       "01.curve.inner.forward",
+			segments:   []string{"04.curve.inner"},
+			wantOrder:  []int{0},
+			wantLength: 1200,
+		},
+		{
+			// Changing lane on 02 is cheaper than driving the longer outer lane of 01.
+			name:       "other lane",
+			from:       "01.curve.inner.forward",
+			segments:   []string{"02.curve.outer"},
+			wantOrder:  []int{0},
+			wantLength: 500,
+		},
+		{
+			name:       "anywhere",
+			segments:   []string{"02.curve.inner", "01.curve.inner"},
+			wantOrder:  []int{1, 0},
+			wantLength: 400,
+		},
+		{
+			name:     "unknown node",
+			from:     "09.curve.inner.forward",
+			segments: []string{"02.curve.inner"},
+			wantErr:  "no route from 09.curve.inner.forward to 02.curve.inner",
+		},
+	}
+	for _, tt := range tests {
+		order, length, err := TourOrder(track.Graph, tt.from, tt.segments)
+		switch {
+		case tt.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: TourOrder error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("%s: TourOrder: %v", tt.name, err)
+		case !slices.Equal(order, tt.wantOrder) || length != tt.wantLength:
+			t.Errorf("%s: TourOrder = %v, %d, want %v, %d", tt.name, order, length, tt.wantOrder, tt.wantLength)
+		}
+	}
+}
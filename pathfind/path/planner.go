@@ -0,0 +1,374 @@
+package path
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/dominikbraun/graph"
+)
+
+// Defaults of the cooperative planner.
+const (
+	defaultPlanMargin   = 0.5   // s kept free around the reservations of a piece
+	defaultPlanWaitStep = 1.0   // s a vehicle waits at once when a piece ahead is reserved
+	defaultPlanHorizon  = 120.0 // s, routes arriving later are not searched
+)
+
+// Reservation is the time a vehicle holds a piece of the track, in seconds from the start of the
+// plan. To is +Inf for a vehicle that stays on the piece, at its target.
+type Reservation struct {
+	Vehicle  string
+	Piece    int
+	Node     string // empty for a pass-through piece
+	From, To float64
+}
+
+// ReservationTable holds the reservations of the pieces. A piece is held by one vehicle at a time,
+// whatever its lane and direction: two vehicles on a piece either drive head-on or into each other.
+type ReservationTable struct {
+	margin  float64
+	byPiece map[int][]Reservation
+}
+
+func newReservationTable(margin float64) *ReservationTable {
+	return &ReservationTable{margin: margin, byPiece: map[int][]Reservation{}}
+}
+
+// Free tells whether a vehicle may hold a piece from one time to another.
+func (r *ReservationTable) Free(vehicle string, piece int, from, to float64) bool {
+	for _, res := range r.byPiece[piece] {
+		if res.Vehicle != vehicle && from < res.To+r.margin && res.From < to+r.margin {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *ReservationTable) reserve(res Reservation) {
+	r.byPiece[res.Piece] = append(r.byPiece[res.Piece], res)
+}
+
+// All returns the reservations sorted by piece and time.
+func (r *ReservationTable) All() []Reservation {
+	var all []Reservation
+	for _, reservations := range r.byPiece {
+		all = append(all, reservations...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Piece != all[j].Piece {
+			return all[i].Piece < all[j].Piece
+		}
+		return all[i].From < all[j].From
+	})
+	return all
+}
+
+// PlannedStep is a node of a planned route with the times the vehicle enters and leaves it, in
+// seconds from the start of the plan. Wait is the time it stays stopped on it before leaving.
+type PlannedStep struct {
+	Node  string  `json:"node"`
+	Enter float64 `json:"enter"`
+	Leave float64 `json:"leave"`
+	Wait  float64 `json:"wait,omitempty"`
+}
+
+// Agent is a vehicle to plan for: its position, a graph node, and its target, a lane segment.
+// A vehicle without target only holds its position.
+type Agent struct {
+	Vehicle  string
+	Position string
+	Target   string
+}
+
+// Planner plans the routes of several vehicles in the time-expanded track graph. The vehicles are
+// planned one after the other, the closest to its target first, and each route avoids the pieces
+// reserved by the routes planned before it, waiting if it has to.
+type Planner struct {
+	Velocity float64 // mm/s the vehicles drive at
+	Margin   float64 // s kept free around the reservations of a piece
+	WaitStep float64 // s a vehicle waits at once
+	Horizon  float64 // s, routes arriving later are not searched
+
+	track        *Track
+	adjacency    map[string]map[string]graph.Edge[string]
+	predecessors map[string]map[string]graph.Edge[string]
+}
+
+func NewPlanner(track *Track, velocity float64) (*Planner, error) {
+	adjacency, err := track.Graph.AdjacencyMap()
+	if err != nil {
+		return nil, err
+	}
+	predecessors, err := track.Graph.PredecessorMap()
+	if err != nil {
+		return nil, err
+	}
+	return &Planner{
+		Velocity:     velocity,
+		Margin:       defaultPlanMargin,
+		WaitStep:     defaultPlanWaitStep,
+		Horizon:      defaultPlanHorizon,
+		track:        track,
+		adjacency:    adjacency,
+		predecessors: predecessors,
+	}, nil
+}
+
+// nodePiece returns the piece of a graph node, e.g. 13 for "13.curve.outer.forward".
+func nodePiece(node string) int {
+	if len(node) < 2 {
+		return 0
+	}
+	id, _ := strconv.Atoi(node[:2])
+	return id
+}
+
+// duration returns the time a vehicle takes along an edge of the graph.
+func (p *Planner) duration(weight int) float64 {
+	return float64(weight) / p.Velocity
+}
+
+// startDuration is the time a vehicle is expected to stay on the node it is on when planning starts.
+func (p *Planner) startDuration(node string) float64 {
+	segment, _ := SplitNode(node)
+	length, ok := p.track.Config.segmentLength(segment)
+	if !ok {
+		length = defaultSegmentLength
+	}
+	return p.duration(length) / 2
+}
+
+// crossingBetween returns the pass-through piece a vehicle drives across between two pieces that
+// are not next to each other.
+func (p *Planner) crossingBetween(from, to int) (int, bool) {
+	if from == to {
+		return 0, false
+	}
+	for _, crossing := range p.track.Crossings() {
+		neighbours := p.track.GridNeighbours(crossing)
+		if slices.Contains(neighbours, from) && slices.Contains(neighbours, to) {
+			return crossing, true
+		}
+	}
+	return 0, false
+}
+
+// distancesTo returns the length of the shortest route from every node to a lane segment, reached
+// in any direction of travel. predecessors is the predecessor map of the track graph.
+func distancesTo(predecessors map[string]map[string]graph.Edge[string], target string) map[string]int {
+	dist := map[string]int{}
+	queue := &nodeQueue{}
+	for _, direction := range []string{Forward, Reverse} {
+		node := DirectedNode(target, direction)
+		if _, ok := predecessors[node]; ok {
+			dist[node] = 0
+			heap.Push(queue, queueItem{node: node})
+		}
+	}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(queueItem)
+		if int(item.cost) > dist[item.node] {
+			continue
+		}
+		for prev, edge := range predecessors[item.node] {
+			d := dist[item.node] + edge.Properties.Weight
+			if old, ok := dist[prev]; !ok || d < old {
+				dist[prev] = d
+				heap.Push(queue, queueItem{node: prev, cost: float64(d)})
+			}
+		}
+	}
+	return dist
+}
+
+// PlanAll plans the route of every agent with a target. Every position is held until its vehicle
+// leaves it, the agents without target hold theirs for good. It returns the planned routes, the
+// reservations and the agents no route was found for, which hold their position.
+//
+// The agents are planned the closest to its target first. When some get no route, e.g. two cars
+// that must swap pieces, the planning is tried again with them first, and the attempt with the
+// fewest failures is kept. The routes never cross the position of a failed agent.
+func (p *Planner) PlanAll(agents []Agent) (map[string][]PlannedStep, *ReservationTable, []string) {
+	dist := map[string]map[string]int{}
+	var order []Agent
+	for _, a := range agents {
+		if a.Target != "" {
+			dist[a.Vehicle] = distancesTo(p.predecessors, a.Target)
+			order = append(order, a)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		di, dj := dist[order[i].Vehicle][order[i].Position], dist[order[j].Vehicle][order[j].Position]
+		if di != dj {
+			return di < dj
+		}
+		return order[i].Vehicle < order[j].Vehicle
+	})
+
+	var (
+		bestRoutes map[string][]PlannedStep
+		bestTable  *ReservationTable
+		bestFailed []string
+	)
+	for attempt := 0; attempt <= len(order); attempt++ {
+		routes, table, failed := p.planOrder(agents, order, dist)
+		if bestRoutes == nil || len(failed) < len(bestFailed) {
+			bestRoutes, bestTable, bestFailed = routes, table, failed
+		}
+		if len(failed) == 0 {
+			break
+		}
+		// The failed agents first, in the same order, then the others.
+		first := slices.DeleteFunc(slices.Clone(order), func(a Agent) bool { return !slices.Contains(failed, a.Vehicle) })
+		rest := slices.DeleteFunc(slices.Clone(order), func(a Agent) bool { return slices.Contains(failed, a.Vehicle) })
+		next := append(first, rest...)
+		if slices.Equal(next, order) {
+			break
+		}
+		order = next
+	}
+	return bestRoutes, bestTable, bestFailed
+}
+
+// planOrder plans the agents with a target one after the other, in the given order. When an agent
+// gets no route it holds its position for good, and the agents are planned again around it.
+func (p *Planner) planOrder(agents, order []Agent, dist map[string]map[string]int) (map[string][]PlannedStep, *ReservationTable, []string) {
+	var failed []string
+	for {
+		table := newReservationTable(p.Margin)
+		for _, a := range agents {
+			to := math.Inf(1)
+			if a.Target != "" && !slices.Contains(failed, a.Vehicle) {
+				to = p.startDuration(a.Position)
+			}
+			table.reserve(Reservation{a.Vehicle, nodePiece(a.Position), a.Position, 0, to})
+		}
+
+		routes := map[string][]PlannedStep{}
+		failedBefore := len(failed)
+		for _, a := range order {
+			if slices.Contains(failed, a.Vehicle) {
+				continue
+			}
+			steps, ok := p.plan(a, dist[a.Vehicle], table)
+			if !ok {
+				failed = append(failed, a.Vehicle)
+				break
+			}
+			routes[a.Vehicle] = steps
+			p.reserveRoute(a.Vehicle, steps, table)
+		}
+		if len(failed) == failedBefore {
+			return routes, table, failed
+		}
+	}
+}
+
+// reserveRoute reserves the pieces of a planned route, and its target for good.
+func (p *Planner) reserveRoute(vehicle string, steps []PlannedStep, table *ReservationTable) {
+	for i, step := range steps {
+		to := step.Leave
+		if i == len(steps)-1 {
+			to = math.Inf(1)
+		}
+		table.reserve(Reservation{vehicle, nodePiece(step.Node), step.Node, step.Enter, to})
+		if i > 0 {
+			if crossing, ok := p.crossingBetween(nodePiece(steps[i-1].Node), nodePiece(step.Node)); ok {
+				table.reserve(Reservation{vehicle, crossing, "", step.Enter, step.Enter})
+			}
+		}
+	}
+}
+
+// planState is a vehicle on a node of the time-expanded graph: it entered the node at enter and
+// leaves it at leave, after waiting wait.
+type planState struct {
+	node               string
+	enter, leave, wait float64
+	parent             *planState
+}
+
+// plan searches the earliest route of an agent to its target with A*, the remaining distance at
+// full velocity being the heuristic. A vehicle may wait on its node while the next piece is held.
+func (p *Planner) plan(a Agent, dist map[string]int, table *ReservationTable) ([]PlannedStep, bool) {
+	if _, ok := dist[a.Position]; !ok {
+		return nil, false
+	}
+	h := func(node string) float64 { return p.duration(dist[node]) }
+	targetPiece := nodePiece(DirectedNode(a.Target, Forward))
+
+	start := &planState{node: a.Position, leave: p.startDuration(a.Position)}
+	queue := &nodeQueue{}
+	heap.Push(queue, queueItem{cost: start.leave + h(start.node), state: start})
+	closed := map[string]bool{}
+
+	for queue.Len() > 0 {
+		s := heap.Pop(queue).(queueItem).state
+		if segment, _ := SplitNode(s.node); segment == a.Target && table.Free(a.Vehicle, targetPiece, s.enter, math.Inf(1)) {
+			return s.steps(), true
+		}
+		key := fmt.Sprintf("%s@%d", s.node, int(math.Round(s.leave/p.WaitStep)))
+		if closed[key] || s.leave > p.Horizon {
+			continue
+		}
+		closed[key] = true
+
+		piece := nodePiece(s.node)
+		if table.Free(a.Vehicle, piece, s.leave, s.leave+p.WaitStep) {
+			wait := &planState{s.node, s.enter, s.leave + p.WaitStep, s.wait + p.WaitStep, s.parent}
+			heap.Push(queue, queueItem{cost: wait.leave + h(wait.node), state: wait})
+		}
+		for next, edge := range p.adjacency[s.node] {
+			if _, ok := dist[next]; !ok {
+				continue
+			}
+			nextPiece := nodePiece(next)
+			leave := s.leave + p.duration(edge.Properties.Weight)
+			if !table.Free(a.Vehicle, nextPiece, s.leave, leave) {
+				continue
+			}
+			if crossing, ok := p.crossingBetween(piece, nextPiece); ok && !table.Free(a.Vehicle, crossing, s.leave, s.leave) {
+				continue
+			}
+			n := &planState{node: next, enter: s.leave, leave: leave, parent: s}
+			heap.Push(queue, queueItem{cost: leave + h(next), state: n})
+		}
+	}
+	return nil, false
+}
+
+// steps returns the route that led to a state.
+func (s *planState) steps() []PlannedStep {
+	var steps []PlannedStep
+	for ; s != nil; s = s.parent {
+		steps = append(steps, PlannedStep{s.node, s.enter, s.leave, s.wait})
+	}
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return steps
+}
+
+// nodeQueue is a priority queue of nodes or plan states, the lowest cost first.
+type queueItem struct {
+	node  string
+	state *planState
+	cost  float64
+}
+
+type nodeQueue []queueItem
+
+func (q nodeQueue) Len() int           { return len(q) }
+func (q nodeQueue) Less(i, j int) bool { return q[i].cost < q[j].cost }
+func (q nodeQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *nodeQueue) Push(x any)        { *q = append(*q, x.(queueItem)) }
+func (q *nodeQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,134 @@
+package path
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestReservationTableFree(t *testing.T) {
+	table := newReservationTable(0.5)
+	table.reserve(Reservation{Vehicle: "a", Piece: 5, Node: "05.straight.top.forward", From: 2, To: 4})
+	table.reserve(Reservation{Vehicle: "b", Piece: 6, Node: "06.curve.inner.forward", From: 3, To: math.Inf(1)})
+
+	tests := []struct {
+		name     string
+		vehicle  string
+		piece    int
+		from, to float64
+		want     bool
+	}{
+		{"own reservation", "a", 5, 2, 4, true},
+		{"overlap", "b", 5, 3, 5, false},
+		{"inside", "b", 5, 2.5, 3.5, false},
+		{"around", "b", 5, 1, 5, false},
+		{"before, within the margin", "b", 5, 0, 1.8, false},
+		{"before", "b", 5, 0, 1.4, true},
+		{"after, within the margin", "b", 5, 4.3, 6, false},
+		{"after", "b", 5, 4.6, 6, true},
+		{"other piece", "b", 7, 2, 4, true},
+		{"held for good", "a", 6, 100, 101, false},
+		{"before held for good", "a", 6, 0, 2.4, true},
+	}
+	for _, tt := range tests {
+		if got := table.Free(tt.vehicle, tt.piece, tt.from, tt.to); got != tt.want {
+			t.Errorf("%s: Free(%s, %d, %v, %v) = %v, want %v", tt.name, tt.vehicle, tt.piece, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestReservationTableAll(t *testing.T) {
+	table := newReservationTable(0)
+	for _, res := range []Reservation{
+		{Vehicle: "a", Piece: 6, From: 5, To: 6},
+		{Vehicle: "b", Piece: 5, From: 3, To: 4},
+		{Vehicle: "a", Piece: 6, From: 1, To: 2},
+		{Vehicle: "a", Piece: 5, From: 0, To: 1},
+	} {
+		table.reserve(res)
+	}
+	var got [][2]float64
+	for _, res := range table.All() {
+		got = append(got, [2]float64{float64(res.Piece), res.From})
+	}
+	if want := [][2]float64{{5, 0}, {5, 3}, {6, 1}, {6, 5}}; !slices.Equal(got, want) {
+		t.Errorf("All = %v, want %v", got, want)
+	}
+}
+
+func TestPlanAll(t *testing.T) {
+	planner, err := NewPlanner(loadRing(t), 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		agents     []Agent
+		wantFailed []string
+		avoid      map[string]int // vehicle -> piece its route must not cross
+	}{
+		{
+			name:   "alone",
+			agents: []Agent{{Vehicle: "a", Position: "01.curve.inner.forward", Target: "03.curve.inner"}},
+		},
+		{
+			// b stays on 02, a turns around and drives by 04.
+			name: "parked ahead",
+			agents: []Agent{
+				{Vehicle: "a", Position: "01.curve.inner.forward", Target: "03.curve.inner"},
+				{Vehicle: "b", Position: "02.curve.inner.forward"},
+			},
+			avoid: map[string]int{"a": 2},
+		},
+		{
+			name: "both moving",
+			agents: []Agent{
+				{Vehicle: "a", Position: "01.curve.inner.forward", Target: "02.curve.inner"},
+				{Vehicle: "b", Position: "03.curve.outer.forward", Target: "04.curve.outer"},
+			},
+		},
+		{
+			// b holds 02 and d holds 04: a cannot leave 01 either way.
+			name: "boxed in",
+			agents: []Agent{
+				{Vehicle: "a", Position: "01.curve.inner.forward", Target: "03.curve.inner"},
+				{Vehicle: "b", Position: "02.curve.inner.forward"},
+				{Vehicle: "d", Position: "04.curve.inner.forward"},
+			},
+			wantFailed: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, table, failed := planner.PlanAll(tt.agents)
+			if !slices.Equal(failed, tt.wantFailed) {
+				t.Fatalf("failed = %v, want %v", failed, tt.wantFailed)
+			}
+			for _, a := range tt.agents {
+				route := routes[a.Vehicle]
+				if a.Target == "" || slices.Contains(failed, a.Vehicle) {
+					continue
+				}
+				if len(route) == 0 || route[0].Node != a.Position {
+					t.Fatalf("%s: route %v does not start at %s", a.Vehicle, route, a.Position)
+				}
+				if segment, _ := SplitNode(route[len(route)-1].Node); segment != a.Target {
+					t.Errorf("%s: route %v does not end on %s", a.Vehicle, route, a.Target)
+				}
+				for _, step := range route {
+					if piece, ok := tt.avoid[a.Vehicle]; ok && nodePiece(step.Node) == piece {
+						t.Errorf("%s: route %v crosses piece %d", a.Vehicle, route, piece)
+					}
+				}
+			}
+			// A piece is held by one vehicle at a time.
+			all := table.All()
+			for i := 1; i < len(all); i++ {
+				prev, res := all[i-1], all[i]
+				if prev.Piece == res.Piece && prev.Vehicle != res.Vehicle && res.From < prev.To {
+					t.Errorf("piece %d: %s from %v to %v overlaps %s from %v to %v", res.Piece, res.Vehicle, res.From, res.To, prev.Vehicle, prev.From, prev.To)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package path
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// scanEvent is a track event recorded while scanning.
+type scanEvent struct {
+	piece, location int
+	direction       string
+}
+
+// Scan infers the pieces of a track and the connections between them from the track events of
+// cars driving around it. The shapes and the pieces of a known track definition are used to name
+// the lanes; the shape of the pieces it does not know is guessed from the track locations.
+type Scan struct {
+	known  *Track
+	events map[string][]scanEvent // per vehicle, in order
+}
+
+func NewScan(known *Track) *Scan {
+	return &Scan{known: known, events: map[string][]scanEvent{}}
+}
+
+// Add records a track event of a vehicle. The events on pass-through pieces (the crossing) are
+// ignored, the cars drive across them between the pieces around them.
+func (s *Scan) Add(vehicle string, piece, location int, direction string) {
+	if piece == 0 {
+		return
+	}
+	if p, ok := s.known.Piece(piece); ok && s.known.Config.Shapes[p.Shape].PassThrough {
+		return
+	}
+	s.events[vehicle] = append(s.events[vehicle], scanEvent{piece, location, ParseDirection(direction)})
+}
+
+// Events returns the number of recorded events.
+func (s *Scan) Events() int {
+	n := 0
+	for _, events := range s.events {
+		n += len(events)
+	}
+	return n
+}
+
+// ScannedPiece is a piece seen while scanning.
+type ScannedPiece struct {
+	ID        int
+	Shape     string
+	Known     bool  // the shape comes from the known track definition, it is guessed otherwise
+	Locations []int // track locations seen, sorted
+}
+
+// Pieces returns the pieces seen while scanning, sorted by ID.
+func (s *Scan) Pieces() []ScannedPiece {
+	locations := map[int]map[int]bool{}
+	neighbours := map[int]map[int]bool{}
+	for _, events := range s.events {
+		for i, e := range events {
+			if locations[e.piece] == nil {
+				locations[e.piece], neighbours[e.piece] = map[int]bool{}, map[int]bool{}
+			}
+			locations[e.piece][e.location] = true
+			if i > 0 && events[i-1].piece != e.piece {
+				neighbours[e.piece][events[i-1].piece] = true
+				neighbours[events[i-1].piece][e.piece] = true
+			}
+		}
+	}
+
+	pieces := make([]ScannedPiece, 0, len(locations))
+	for id, seen := range locations {
+		p := ScannedPiece{ID: id}
+		for location := range seen {
+			p.Locations = append(p.Locations, location)
+		}
+		slices.Sort(p.Locations)
+		if known, ok := s.known.Piece(id); ok {
+			p.Shape, p.Known = known.Shape, true
+		} else {
+			p.Shape = s.guessShape(p.Locations, len(neighbours[id]))
+		}
+		pieces = append(pieces, p)
+	}
+	sort.Slice(pieces, func(i, j int) bool { return pieces[i].ID < pieces[j].ID })
+	return pieces
+}
+
+// guessShape returns the shape whose lanes cover the track locations seen on a piece with the
+// smallest range, e.g. a curve rather than a straight for the locations 1 to 12. A piece joined
+// to three pieces or more branches, it gets the covering shape with the most lanes.
+func (s *Scan) guessShape(locations []int, neighbours int) string {
+	type candidate struct {
+		name        string
+		lanes, span int
+	}
+	var candidates []candidate
+	for name, shape := range s.known.Config.Shapes {
+		if shape.PassThrough || len(shape.Lanes) == 0 {
+			continue
+		}
+		to := 0
+		for _, lane := range shape.Lanes {
+			to = max(to, lane.To)
+		}
+		if len(locations) > 0 && locations[len(locations)-1] > to {
+			continue
+		}
+		candidates = append(candidates, candidate{name, len(shape.Lanes), to})
+	}
+	if len(candidates) == 0 {
+		return "straight"
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch {
+		case neighbours >= 3 && a.lanes != b.lanes:
+			return a.lanes > b.lanes
+		case a.span != b.span:
+			return a.span < b.span
+		case a.lanes != b.lanes:
+			return a.lanes < b.lanes
+		}
+		return a.name < b.name
+	})
+	return candidates[0].name
+}
+
+// ScannedEdge is a connection seen while scanning, with the number of times a car drove it.
+type ScannedEdge struct {
+	EdgePair
+	Count int
+}
+
+// Edges returns the connections between the pieces seen while scanning, and the lane changes of
+// their shapes. The connections seen fewer than minCount times are returned apart, they are
+// likely misread track events.
+func (s *Scan) Edges(minCount int) (edges, rare []ScannedEdge) {
+	shapes := map[int]string{}
+	for _, p := range s.Pieces() {
+		shapes[p.ID] = p.Shape
+	}
+	segment := func(e scanEvent) string {
+		return fmt.Sprintf("%02d.%s.%s", e.piece, shapes[e.piece], s.known.Config.laneName(shapes[e.piece], e.location))
+	}
+
+	counts := map[EdgePair]int{}
+	for _, events := range s.events {
+		for i := 1; i < len(events); i++ {
+			from, to := events[i-1], events[i]
+			if from.piece == to.piece {
+				continue
+			}
+			counts[canonicalEdge(EdgePair{
+				Source:          segment(from),
+				Target:          segment(to),
+				SourceDirection: from.direction,
+				TargetDirection: to.direction,
+			})]++
+		}
+	}
+
+	for edge, count := range counts {
+		if count < minCount {
+			rare = append(rare, ScannedEdge{edge, count})
+		} else {
+			edges = append(edges, ScannedEdge{edge, count})
+		}
+	}
+	for _, p := range s.Pieces() {
+		shape := s.known.Config.Shapes[p.Shape]
+		for _, change := range shape.LaneChanges {
+			edges = append(edges, ScannedEdge{EdgePair{
+				Source: fmt.Sprintf("%02d.%s.%s", p.ID, p.Shape, change[0]),
+				Target: fmt.Sprintf("%02d.%s.%s", p.ID, p.Shape, change[1]),
+			}, 0})
+		}
+	}
+	sortEdges := func(edges []ScannedEdge) {
+		sort.Slice(edges, func(i, j int) bool { return edgeString(edges[i].EdgePair) < edgeString(edges[j].EdgePair) })
+	}
+	sortEdges(edges)
+	sortEdges(rare)
+	return edges, rare
+}
+
+// Draft returns a track definition with the shapes and the layout of the known one, and the
+// scanned edges the layout does not generate, listed by hand. The pieces missing from the layout
+// and the generated connections no car drove are written as comments, to check the layout.
+func (s *Scan) Draft(minCount int) ([]byte, error) {
+	config := TrackConfig{
+		Shapes:         s.known.Config.Shapes,
+		LaneChangeCost: s.known.Config.LaneChangeCost,
+		UTurn:          s.known.Config.UTurn,
+		Background:     s.known.Config.Background,
+	}
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Layout = s.known.Config.Layout
+	generated := map[EdgePair]bool{}
+	if len(config.Layout) > 0 {
+		edges, err := GenerateEdges(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid known layout: %w", err)
+		}
+		for _, e := range edges {
+			generated[canonicalEdge(e)] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# Draft track definition, built from the track events of a scan.")
+	fmt.Fprintln(&buf, "# Pieces seen:")
+	for _, p := range s.Pieces() {
+		origin := "known"
+		if !p.Known {
+			origin = "guessed, check it"
+			if len(config.Layout) > 0 {
+				origin = "guessed, not in the layout: place it"
+			}
+		}
+		fmt.Fprintf(&buf, "#   %02d %s (%s), track locations %s\n", p.ID, p.Shape, origin, locationRanges(p.Locations))
+	}
+	buf.Write(b)
+
+	if len(config.Layout) > 0 {
+		fmt.Fprintln(&buf, "layout:")
+		for _, p := range config.Layout {
+			line, err := yaml.MarshalWithOptions(p, yaml.Flow(true))
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&buf, "  - %s", line)
+		}
+	}
+
+	edges, rare := s.Edges(minCount)
+	driven := map[EdgePair]bool{}
+	var listed []ScannedEdge
+	for _, e := range edges {
+		driven[canonicalEdge(e.EdgePair)] = true
+		if !generated[canonicalEdge(e.EdgePair)] {
+			listed = append(listed, e)
+		}
+	}
+	if len(listed) > 0 {
+		if len(config.Layout) > 0 {
+			fmt.Fprintln(&buf, "# Driven during the scan, not generated from the layout:")
+		}
+		fmt.Fprintln(&buf, "edges:")
+		for _, e := range listed {
+			writeDraftEdge(&buf, "", e)
+		}
+	}
+	rare = slices.DeleteFunc(rare, func(e ScannedEdge) bool { return generated[canonicalEdge(e.EdgePair)] })
+	if len(rare) > 0 {
+		fmt.Fprintf(&buf, "# Seen fewer than %d times, probably misread events:\n", minCount)
+		for _, e := range rare {
+			writeDraftEdge(&buf, "# ", e)
+		}
+	}
+
+	var notDriven []string
+	for e := range generated {
+		if !driven[e] && !isLaneChange(e.Source, e.Target) {
+			notDriven = append(notDriven, edgeString(e))
+		}
+	}
+	if len(notDriven) > 0 {
+		slices.Sort(notDriven)
+		fmt.Fprintln(&buf, "# Generated from the layout, not driven during the scan:")
+		for _, e := range notDriven {
+			fmt.Fprintf(&buf, "#   %s\n", e)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// locationRanges formats sorted track locations as ranges, e.g. "1-8, 12".
+func locationRanges(locations []int) string {
+	var ranges []string
+	for i := 0; i < len(locations); {
+		j := i
+		for j+1 < len(locations) && locations[j+1] == locations[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, fmt.Sprint(locations[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", locations[i], locations[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
+
+func writeDraftEdge(buf *bytes.Buffer, prefix string, e ScannedEdge) {
+	comment := "lane change"
+	if e.Count > 0 {
+		comment = fmt.Sprintf("seen %d times", e.Count)
+	}
+	fmt.Fprintf(buf, "%s  - source: %s # %s\n", prefix, e.Source, comment)
+	fmt.Fprintf(buf, "%s    target: %s\n", prefix, e.Target)
+	if e.SourceDirection != "" {
+		fmt.Fprintf(buf, "%s    sourceDirection: %s\n", prefix, e.SourceDirection)
+	}
+	if e.TargetDirection != "" {
+		fmt.Fprintf(buf, "%s    targetDirection: %s\n", prefix, e.TargetDirection)
+	}
+}
+
+// canonicalEdge returns the form of an edge used to compare edges: an edge and its reversed edge
+// are the same connection, and so are the two ways of a lane change. Forward directions are empty.
+func canonicalEdge(e EdgePair) EdgePair {
+	sd, td := e.directions()
+	forms := []EdgePair{
+		{e.Source, e.Target, sd, td},
+		{e.Target, e.Source, opposite(td), opposite(sd)},
+	}
+	if isLaneChange(e.Source, e.Target) {
+		forms = append(forms, EdgePair{e.Target, e.Source, td, sd}, EdgePair{e.Source, e.Target, opposite(sd), opposite(td)})
+	}
+	for i := range forms {
+		if forms[i].SourceDirection == Forward {
+			forms[i].SourceDirection = ""
+		}
+		if forms[i].TargetDirection == Forward {
+			forms[i].TargetDirection = ""
+		}
+	}
+	// The fewest reverse directions first, then the names.
+	reverses := func(e EdgePair) int {
+		n := 0
+		if e.SourceDirection != "" {
+			n++
+		}
+		if e.TargetDirection != "" {
+			n++
+		}
+		return n
+	}
+	slices.SortFunc(forms, func(a, b EdgePair) int {
+		if d := reverses(a) - reverses(b); d != 0 {
+			return d
+		}
+		return strings.Compare(edgeString(a), edgeString(b))
+	})
+	return forms[0]
+}
+
+// edgeString returns an edge between directed nodes, e.g.
+// "13.curve.outer.forward -> 20.straight.top.forward".
+func edgeString(e EdgePair) string {
+	sd, td := e.directions()
+	return DirectedNode(e.Source, sd) + " -> " + DirectedNode(e.Target, td)
+}
+
+// DiffEdges compares the edges of two track definitions. It returns the connections only in the
+// first one and the connections only in the second one, as strings sorted by name.
+func DiffEdges(a, b []EdgePair) (onlyA, onlyB []string) {
+	set := func(edges []EdgePair) map[EdgePair]bool {
+		m := map[EdgePair]bool{}
+		for _, e := range edges {
+			m[canonicalEdge(e)] = true
+		}
+		return m
+	}
+	setA, setB := set(a), set(b)
+	for e := range setA {
+		if !setB[e] {
+			onlyA = append(onlyA, edgeString(e))
+		}
+	}
+	for e := range setB {
+		if !setA[e] {
+			onlyB = append(onlyB, edgeString(e))
+		}
+	}
+	slices.Sort(onlyA)
+	slices.Sort(onlyB)
+	return onlyA, onlyB
+}
@@ -0,0 +1,335 @@
+//go:build !headless
+
+package path
+
+import (
+	"encoding/json"
+	"fmt"
+	"hyperdrive/remote/hyperdrive"
+	"hyperdrive/remote/pathfind/instruct"
+	"hyperdrive/remote/topic"
+	"image/color"
+	"log"
+	"math"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/widget"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// vehicleColors are the colours of the vehicles on the map, in the order they appear.
+var vehicleColors = []color.NRGBA{
+	{230, 25, 75, 255},
+	{0, 130, 200, 255},
+	{60, 180, 75, 255},
+	{245, 130, 48, 255},
+	{145, 30, 180, 255},
+	{0, 170, 170, 255},
+}
+
+const (
+	mapFrameInterval = 50 * time.Millisecond
+	// maxProgress keeps a vehicle before the end of its lane until its next track event.
+	maxProgress = 0.9
+	// curveSteps is the number of lines a lane is drawn with.
+	curveSteps = 8
+)
+
+// mapVehicle is what the map knows about a vehicle.
+type mapVehicle struct {
+	node     string    // last graph node, e.g. "13.curve.outer.forward"
+	since    time.Time // when the vehicle reached it
+	velocity float64   // mm/s
+	route    []string  // planned route, graph nodes
+	target   int       // target piece, 0 if none
+}
+
+// TrackMap draws the track from the geometry of its layout, with the vehicles moving along their
+// lanes between their track events, their planned routes and their targets.
+type TrackMap struct {
+	widget.BaseWidget
+	track    *Track
+	onTapped func(piece int)
+
+	mu       sync.Mutex
+	vehicles map[string]*mapVehicle
+	order    []string // vehicles in the order they appeared, for their colours
+}
+
+// NewTrackMap returns the map of a track. onTapped is called with the piece tapped on the map.
+func NewTrackMap(track *Track, onTapped func(piece int)) *TrackMap {
+	m := &TrackMap{track: track, onTapped: onTapped, vehicles: map[string]*mapVehicle{}}
+	m.ExtendBaseWidget(m)
+	return m
+}
+
+// vehicle returns the state of a vehicle, added if it is new. m.mu must be held.
+func (m *TrackMap) vehicle(id string) *mapVehicle {
+	v, ok := m.vehicles[id]
+	if !ok {
+		v = &mapVehicle{velocity: instruct.VelocityValue}
+		m.vehicles[id] = v
+		m.order = append(m.order, id)
+	}
+	return v
+}
+
+// color returns the colour of a vehicle. m.mu must be held.
+func (m *TrackMap) color(id string) color.NRGBA {
+	return vehicleColors[slices.Index(m.order, id)%len(vehicleColors)]
+}
+
+// RemoveVehicle takes a vehicle off the map. It must be called from the UI goroutine.
+func (m *TrackMap) RemoveVehicle(id string) {
+	m.mu.Lock()
+	delete(m.vehicles, id)
+	m.mu.Unlock()
+	m.Refresh()
+}
+
+// Watch follows the positions, velocities, routes and targets of every vehicle and animates the map.
+func (m *TrackMap) Watch(client mqtt.Client) {
+	subscribe := func(t topic.Template, handle func(vehicle string, payload []byte)) {
+		if token := client.Subscribe(t.Filter(), 1, func(c mqtt.Client, msg mqtt.Message) {
+			if values, ok := t.Match(msg.Topic()); ok {
+				handle(values["vehicle"], msg.Payload())
+			}
+		}); token.Wait() && token.Error() != nil {
+			log.Println("[Map] Could not subscribe to", t, ":", token.Error())
+		}
+	}
+
+	subscribe(vehiclePositionTopic, func(vehicle string, payload []byte) {
+		var data positionPayload
+		if json.Unmarshal(payload, &data) != nil || data.ID == "" {
+			return
+		}
+		m.mu.Lock()
+		if v := m.vehicle(vehicle); v.node != data.ID {
+			v.node, v.since = data.ID, time.Now()
+		}
+		m.mu.Unlock()
+	})
+	subscribe(hyperdrive.SpeedTopic, func(vehicle string, payload []byte) {
+		var data hyperdrive.SpeedPayload
+		if json.Unmarshal(payload, &data) != nil {
+			return
+		}
+		m.mu.Lock()
+		m.vehicle(vehicle).velocity = math.Abs(float64(data.Velocity))
+		m.mu.Unlock()
+	})
+	subscribe(routeTopic, func(vehicle string, payload []byte) {
+		var data routePayload
+		if json.Unmarshal(payload, &data) != nil {
+			return
+		}
+		m.mu.Lock()
+		m.vehicle(vehicle).route = data.Route
+		m.mu.Unlock()
+	})
+	subscribe(vehicleTargetTopic, func(vehicle string, payload []byte) {
+		var data tilePayload
+		if json.Unmarshal(payload, &data) != nil {
+			return
+		}
+		m.mu.Lock()
+		v := m.vehicle(vehicle)
+		v.target, v.route = data.ID, nil
+		m.mu.Unlock()
+	})
+
+	go func() {
+		ticker := time.NewTicker(mapFrameInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			fyne.Do(m.Refresh)
+		}
+	}()
+}
+
+// position returns where a vehicle is drawn: along its lane, at its velocity since its last
+// track event. It returns false when the lane has no geometry.
+func (m *TrackMap) position(v *mapVehicle, now time.Time) (Point, bool) {
+	segment, direction := SplitNode(v.node)
+	curve, ok := m.track.Curve(segment)
+	if !ok {
+		return Point{}, false
+	}
+	length, ok := m.track.Config.segmentLength(segment)
+	if !ok {
+		length = defaultSegmentLength
+	}
+	progress := math.Min(maxProgress, v.velocity*now.Sub(v.since).Seconds()/float64(length))
+	if direction == Reverse {
+		progress = 1 - progress
+	}
+	return curve.At(progress), true
+}
+
+// Tapped calls onTapped with the piece under the tap.
+func (m *TrackMap) Tapped(e *fyne.PointEvent) {
+	if m.onTapped == nil {
+		return
+	}
+	scale, origin := mapScale(m.track, m.Size())
+	col := int(math.Floor(float64((e.Position.X - origin.X) / scale)))
+	row := int(math.Floor(float64((e.Position.Y - origin.Y) / scale)))
+	if p, ok := m.track.PieceAt(row, col); ok {
+		m.onTapped(p.ID)
+	}
+}
+
+func (m *TrackMap) CreateRenderer() fyne.WidgetRenderer {
+	r := &trackMapRenderer{m: m}
+	if m.track.Config.Background != "" {
+		background := m.track.Config.Background
+		if !filepath.IsAbs(background) {
+			background = filepath.Join(m.track.Dir, background)
+		}
+		r.background = canvas.NewImageFromFile(background)
+		r.background.FillMode = canvas.ImageFillStretch
+	}
+	for _, p := range m.track.Config.Layout {
+		for _, lane := range m.track.Config.Shapes[p.Shape].Lanes {
+			curve, ok := m.track.Curve(pieceSegment(p, lane.Name))
+			if !ok {
+				continue
+			}
+			r.curves = append(r.curves, curve)
+			for range curveSteps {
+				line := canvas.NewLine(color.NRGBA{150, 150, 150, 255})
+				line.StrokeWidth = 2
+				r.lanes = append(r.lanes, line)
+			}
+		}
+	}
+	r.Refresh()
+	return r
+}
+
+// pieceSegment returns the lane segment of a placed piece, e.g. "13.curve.outer".
+func pieceSegment(p PiecePlacement, lane string) string {
+	return fmt.Sprintf("%02d.%s.%s", p.ID, p.Shape, lane)
+}
+
+// mapScale returns the size of a grid cell and the top left corner of the grid, centered in size.
+func mapScale(track *Track, size fyne.Size) (float32, fyne.Position) {
+	rows, cols := track.GridSize()
+	if rows == 0 || cols == 0 {
+		return 1, fyne.Position{}
+	}
+	scale := min(size.Width/float32(cols), size.Height/float32(rows))
+	return scale, fyne.NewPos((size.Width-scale*float32(cols))/2, (size.Height-scale*float32(rows))/2)
+}
+
+type trackMapRenderer struct {
+	m          *TrackMap
+	background *canvas.Image // nil without background
+	curves     []LaneCurve
+	lanes      []*canvas.Line // curveSteps lines per curve
+	objects    []fyne.CanvasObject
+}
+
+func (r *trackMapRenderer) Layout(size fyne.Size) {
+	r.Refresh()
+}
+
+func (r *trackMapRenderer) MinSize() fyne.Size {
+	return fyne.NewSize(300, 300)
+}
+
+func (r *trackMapRenderer) Objects() []fyne.CanvasObject {
+	return r.objects
+}
+
+func (r *trackMapRenderer) Destroy() {}
+
+// Refresh places the lanes for the current size and redraws the targets, the routes and the vehicles.
+func (r *trackMapRenderer) Refresh() {
+	m := r.m
+	scale, origin := mapScale(m.track, m.Size())
+	toPos := func(p Point) fyne.Position {
+		return fyne.NewPos(origin.X+float32(p.X)*scale, origin.Y+float32(p.Y)*scale)
+	}
+	drawCurve := func(curve LaneCurve, lines []*canvas.Line) {
+		for i, line := range lines {
+			line.Position1 = toPos(curve.At(float64(i) / curveSteps))
+			line.Position2 = toPos(curve.At(float64(i+1) / curveSteps))
+		}
+	}
+
+	objects := []fyne.CanvasObject{}
+	if r.background != nil {
+		rows, cols := m.track.GridSize()
+		r.background.Move(origin)
+		r.background.Resize(fyne.NewSize(scale*float32(cols), scale*float32(rows)))
+		objects = append(objects, r.background)
+	}
+	for i, curve := range r.curves {
+		drawCurve(curve, r.lanes[i*curveSteps:(i+1)*curveSteps])
+	}
+	for _, line := range r.lanes {
+		objects = append(objects, line)
+	}
+
+	m.mu.Lock()
+	now := time.Now()
+	var dynamic []fyne.CanvasObject
+	for _, id := range m.order {
+		v, ok := m.vehicles[id]
+		if !ok {
+			continue
+		}
+		c := m.color(id)
+
+		if p, ok := m.track.Piece(v.target); ok {
+			rect := canvas.NewRectangle(color.Transparent)
+			rect.StrokeColor, rect.StrokeWidth = c, 3
+			rect.Move(toPos(Point{float64(p.Col), float64(p.Row)}))
+			rect.Resize(fyne.NewSize(scale, scale))
+			dynamic = append(dynamic, rect)
+		}
+
+		routeColor := c
+		routeColor.A = 110
+		for _, node := range v.route {
+			segment, _ := SplitNode(node)
+			curve, ok := m.track.Curve(segment)
+			if !ok {
+				continue
+			}
+			lines := make([]*canvas.Line, curveSteps)
+			for i := range lines {
+				lines[i] = canvas.NewLine(routeColor)
+				lines[i].StrokeWidth = 6
+				dynamic = append(dynamic, lines[i])
+			}
+			drawCurve(curve, lines)
+		}
+
+		point, ok := m.position(v, now)
+		if !ok {
+			continue
+		}
+		const radius = 7
+		center := toPos(point)
+		marker := canvas.NewCircle(c)
+		marker.StrokeColor, marker.StrokeWidth = color.White, 2
+		marker.Move(center.SubtractXY(radius, radius))
+		marker.Resize(fyne.NewSize(2*radius, 2*radius))
+		label := canvas.NewText(id, c)
+		label.TextSize = 11
+		label.TextStyle.Bold = true
+		label.Move(center.AddXY(radius+2, -radius-4))
+		dynamic = append(dynamic, marker, label)
+	}
+	m.mu.Unlock()
+
+	r.objects = append(objects, dynamic...)
+}
@@ -1,10 +1,16 @@
+//go:build !headless
+
 package path
 
 import (
 	"encoding/json"
 	"fmt"
+	"hyperdrive/remote/hyperdrive"
 	"hyperdrive/remote/pathfind/util"
+	"hyperdrive/remote/topic"
 	"image/color"
+	"slices"
+	"strings"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -16,23 +22,9 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
-var gridSections = [5][5]int{
-	{13, 20, 04, 21, 16},
-	{1, 07, 5, 8, 2},
-	{9, 11, 6, 10, 12},
-	{18, 22, 0, 23, 19}, // important note: 17 changed to 0.
-	{14, 24, 3, 25, 15},
-}
-
-const (
-	vehicleTargetTopic = "/hobHq10yb9dKwxrdfhtT/vehicle/target"
-)
-
-type tilePayload struct {
-	ID int `json:"id"`
-}
-
-func UI(client mqtt.Client) {
+// UI shows the pieces of the track layout on its grid, with the positions of the vehicles, and
+// lets the user choose their targets. The map tab draws the track with the vehicles moving on it.
+func UI(client mqtt.Client, track *Track) {
 	// go randomPositions(client)
 
 	// Start the application
@@ -40,14 +32,47 @@ func UI(client mqtt.Client) {
 	w := a.NewWindow("Visual Car Section Tracker")
 	w.Resize(fyne.NewSize(600, 500))
 
+	selected := ""               // vehicle the targets are chosen for
+	targetOf := map[string]int{} // target tile per vehicle
+
 	absolute := map[int]*fyne.Animation{} // Used to store rectangle references according to the ID
 	preditction := map[int]*fyne.Animation{}
+	targetRects := map[int]*canvas.Rectangle{}
 	cells := []fyne.CanvasObject{}
-	var previousTarget *canvas.Rectangle = nil
-	for _, row := range gridSections {
-		for _, col := range row {
+	showTarget := func() {
+		for id, rect := range targetRects {
+			if selected != "" && targetOf[selected] == id {
+				rect.Show()
+			} else {
+				rect.Hide()
+			}
+		}
+	}
+	setTarget := func(piece int) {
+		if selected == "" || !track.IsTarget(piece) {
+			return
+		}
+		targetOf[selected] = piece
+		showTarget()
+
+		payload, err := json.Marshal(tilePayload{piece})
+		if err != nil {
+			return
+		}
+		client.Publish(vehicleTargetTopic.Format(topic.Values{"vehicle": selected}), 1, false, payload)
+	}
+	rows, cols := track.GridSize()
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			piece, ok := track.PieceAt(r, c)
+			if !ok {
+				cells = append(cells, layout.NewSpacer())
+				continue
+			}
+			col := piece.ID
+
 			// image
-			image := canvas.NewImageFromFile(fmt.Sprintf("assets/ID_%02d.png", col))
+			image := canvas.NewImageFromFile(track.TileImage(piece))
 			image.FillMode = canvas.ImageFillContain
 
 			// rectangle (to color the grid cell)
@@ -67,22 +92,11 @@ func UI(client mqtt.Client) {
 
 			absolute[col] = animation
 			preditction[col] = animation2
+			targetRects[col] = targetRect
 
-			// button, don't put one for the crossing, since it is not allowed to stop there.
-			if col != 0 {
-				button := widget.NewButton("", func() {
-					if previousTarget != nil {
-						previousTarget.Hide()
-					}
-					targetRect.Show()
-					previousTarget = targetRect
-
-					payload, err := json.Marshal(tilePayload{col})
-					if err != nil {
-						return
-					}
-					client.Publish(vehicleTargetTopic, 1, false, payload)
-				})
+			// button, don't put one on the pieces where it is not allowed to stop, e.g. the crossing.
+			if track.IsTarget(col) {
+				button := widget.NewButton("", func() { setTarget(col) })
 				cells = append(cells, container.New(layout.NewStackLayout(), button, image, rect, rect2, targetRect))
 			} else {
 				cells = append(cells, container.New(layout.NewStackLayout(), image, rect, rect2, targetRect))
@@ -91,7 +105,8 @@ func UI(client mqtt.Client) {
 		}
 	}
 
-	client.Subscribe(vehicleAbsolutePositionTopic, 1, func(c mqtt.Client, m mqtt.Message) {
+	// The grid shows the positions and predictions of every tracked vehicle.
+	client.Subscribe(vehicleAbsolutePositionTopic.Filter(), 1, func(c mqtt.Client, m mqtt.Message) {
 		fmt.Println("Received a received an absolute position.")
 		var data tilePayload
 		err := json.Unmarshal(m.Payload(), &data)
@@ -104,7 +119,7 @@ func UI(client mqtt.Client) {
 		}
 	})
 
-	client.Subscribe(vehiclePredictionTopic, 1, func(c mqtt.Client, m mqtt.Message) {
+	client.Subscribe(vehiclePredictionTopic.Filter(), 1, func(c mqtt.Client, m mqtt.Message) {
 		fmt.Println("Received a prediction.")
 		var data tilePayload
 		err := json.Unmarshal(m.Payload(), &data)
@@ -117,22 +132,55 @@ func UI(client mqtt.Client) {
 		}
 	})
 
-	grid := container.New(layout.NewGridLayout(5), cells...)
+	grid := container.New(layout.NewGridLayout(max(cols, 1)), cells...)
+	trackMap := NewTrackMap(track, setTarget)
+	trackMap.Watch(client)
+	banner := hyperdrive.EmergencyBanner(client)
 
-	vehicleIdEntry := widget.NewEntry()
-	form := &widget.Form{
-		Items: []*widget.FormItem{
-			{
-				Text:   "Car ID:",
-				Widget: vehicleIdEntry,
-			},
-		},
-		OnSubmit: func() {
-			payload, _ := json.Marshal(util.VehicleIdPayload{vehicleIdEntry.Text})
-			client.Publish(util.VehicleIDTopic, 1, false, payload)
-			w.SetContent(grid)
-		},
+	// Vehicles are added and removed at runtime, the targets are set for the selected one.
+	vehicleSelect := widget.NewSelect(nil, func(id string) {
+		selected = id
+		showTarget()
+	})
+	vehicleSelect.PlaceHolder = "(no vehicle)"
+
+	publishVehicle := func(id string, remove bool) {
+		payload, _ := json.Marshal(util.VehicleIdPayload{ID: id, Remove: remove})
+		client.Publish(util.VehicleIDTopic, 1, false, payload)
 	}
-	w.SetContent(form)
+
+	vehicleIdEntry := widget.NewEntry()
+	vehicleIdEntry.SetPlaceHolder("Car ID")
+	addButton := widget.NewButton("Add", func() {
+		id := strings.TrimSpace(vehicleIdEntry.Text)
+		if id == "" || slices.Contains(vehicleSelect.Options, id) {
+			return
+		}
+		publishVehicle(id, false)
+		vehicleSelect.Options = append(vehicleSelect.Options, id)
+		vehicleSelect.SetSelected(id)
+		vehicleIdEntry.SetText("")
+	})
+	removeButton := widget.NewButton("Remove", func() {
+		if selected == "" {
+			return
+		}
+		publishVehicle(selected, true)
+		trackMap.RemoveVehicle(selected)
+		delete(targetOf, selected)
+		vehicleSelect.Options = slices.DeleteFunc(vehicleSelect.Options, func(id string) bool { return id == selected })
+		vehicleSelect.ClearSelected()
+		if len(vehicleSelect.Options) > 0 {
+			vehicleSelect.SetSelected(vehicleSelect.Options[0])
+		}
+	})
+
+	toolbar := container.NewBorder(nil, nil, nil,
+		container.NewHBox(addButton, vehicleSelect, removeButton),
+		vehicleIdEntry,
+	)
+	w.SetContent(container.NewBorder(container.NewVBox(banner, toolbar), nil, nil, nil,
+		container.NewAppTabs(container.NewTabItem("Tiles", grid), container.NewTabItem("Map", trackMap)),
+	))
 	w.ShowAndRun()
 }
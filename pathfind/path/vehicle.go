@@ -2,33 +2,40 @@ package path
 
 import (
 	"encoding/json"
-	"fmt"
+	"hyperdrive/remote/hyperdrive"
 	"hyperdrive/remote/pathfind/instruct"
 	"hyperdrive/remote/pathfind/util"
+	"hyperdrive/remote/topic"
 	"log"
-	"slices"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/dominikbraun/graph"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+// Per-vehicle topics of the tracking, {vehicle} is the car ID.
 const (
-	vehicleTrackTopicFormat      = "Anki/Vehicles/U/%s/E/track"
-	vehicleAbsolutePositionTopic = util.RootTopic + "/vehicle/absolute-position"
-	vehiclePredictionTopic       = util.RootTopic + "/vehicle/prediction"
-	vehiclePositionTopic         = util.RootTopic + "/vehicle/position"
+	vehicleAbsolutePositionTopic topic.Template = util.RootTopic + "/vehicle/{vehicle}/absolute-position"
+	vehiclePredictionTopic       topic.Template = util.RootTopic + "/vehicle/{vehicle}/prediction"
+	vehiclePositionTopic         topic.Template = util.RootTopic + "/vehicle/{vehicle}/position"
+	vehicleBeliefTopic           topic.Template = util.RootTopic + "/vehicle/{vehicle}/belief"
+	// vehicleTargetTopic carries the target tile of a vehicle chosen in the UI.
+	vehicleTargetTopic topic.Template = util.RootTopic + "/vehicle/{vehicle}/target"
 )
 
-var TrackTypes = map[int]string{
-	13: "curve", 16: "curve", 15: "curve", 14: "curve",
-	4: "intersection", 1: "intersection", 5: "intersection", 2: "intersection",
-	9: "intersection", 6: "intersection", 12: "intersection", 18: "intersection", 19: "intersection", 3: "intersection",
+type tilePayload struct {
+	ID int `json:"id"`
 }
 
+const (
+	localizerInterval = 250 * time.Millisecond
+	// minConfidence is the probability the most likely node needs to be published as the position.
+	minConfidence = 0.4
+	// beliefSize is the number of nodes in the published belief.
+	beliefSize = 5
+)
+
 type trackPayload struct {
 	Timestamp uint64 `json:"timestamp"`
 	Value     struct {
@@ -42,149 +49,137 @@ type positionPayload struct {
 	ID string `json:"id"`
 }
 
-func getTrackShape(trackID int) string {
-	if shape, exists := TrackTypes[trackID]; exists {
-		return shape
-	}
-	return "straight"
+// beliefPayload is the belief of the localizer about the position of a vehicle.
+type beliefPayload struct {
+	Node       string             `json:"node"`       // most likely node
+	Confidence float64            `json:"confidence"` // its probability
+	Belief     map[string]float64 `json:"belief"`     // probability of the most likely nodes
 }
 
-func calculatePositionNode(trackID, lane int) string {
-	shape := getTrackShape(trackID)
-	var suffix string
-
-	switch shape {
-	case "curve":
-		suffix = "outer"
-		if lane < 9 {
-			suffix = "inner"
-		}
-	case "straight":
-		suffix = "top"
-		if lane < 9 {
-			suffix = "bottom"
-		}
-	case "intersection":
-		if lane < 5 {
-			suffix = "low"
-		} else if lane >= 5 && lane < 9 {
-			suffix = "high"
-		} else {
-			suffix = "bottom"
-		}
+// topBelief returns the n most likely nodes of a belief.
+func topBelief(belief map[string]float64, n int) map[string]float64 {
+	nodes := make([]string, 0, len(belief))
+	for node := range belief {
+		nodes = append(nodes, node)
 	}
-	return fmt.Sprintf("%02d.%s.%s", trackID, shape, suffix)
-}
-
-func getPredictionProbability(nodeID string) int {
-	switch {
-	case strings.Contains(nodeID, "curve.inner"):
-		return 1
-	case strings.Contains(nodeID, "intersection.low"), strings.Contains(nodeID, "intersection.high"):
-		return 2
-	case strings.Contains(nodeID, "intersection.bottom"):
-		return 3
-	case strings.Contains(nodeID, "curve.outer"):
-		return 4
-	case strings.Contains(nodeID, "straight"):
-		return 5
-	default:
-		return 0
+	sort.Slice(nodes, func(i, j int) bool { return belief[nodes[i]] > belief[nodes[j]] })
+	top := map[string]float64{}
+	for _, node := range nodes[:min(n, len(nodes))] {
+		top[node] = belief[node]
 	}
+	return top
 }
 
-func VehicleTracking(client mqtt.Client, trackGraph graph.Graph[string, string]) {
-	vehicleID := util.WaitForVehicleID(client)
+// VehicleTracking localizes one vehicle from its track events and commanded velocity, publishes
+// its belief and most likely node, and turns the next steps of the path calculation into
+// instructions, until done is closed.
+func VehicleTracking(client mqtt.Client, track *Track, vehicleID string, done <-chan struct{}) {
 	log.Printf("Starting tracking for Vehicle ID: %s", vehicleID)
+	values := topic.Values{"vehicle": vehicleID}
 
+	trackTopic := hyperdrive.VehicleTrackTopic.Format(values)
 	trackCh := make(chan trackPayload)
-	client.Subscribe(fmt.Sprintf(vehicleTrackTopicFormat, vehicleID), 1, func(c mqtt.Client, m mqtt.Message) {
+	client.Subscribe(trackTopic, 1, func(c mqtt.Client, m mqtt.Message) {
 		var data []trackPayload
 		if err := json.Unmarshal(m.Payload(), &data); err != nil {
 			log.Printf("Error unmarshalling track: %v", err)
 			return
 		}
 		if len(data) > 0 {
-			trackCh <- data[0]
+			select {
+			case trackCh <- data[0]:
+			case <-done:
+			}
 		}
 	})
 
-	nextStepCh := make(chan string)
-	client.Subscribe(nextStepTopic, 1, func(c mqtt.Client, m mqtt.Message) {
+	stepTopic := nextStepTopic.Format(values)
+	nextStepCh := make(chan nextStepPayload)
+	client.Subscribe(stepTopic, 1, func(c mqtt.Client, m mqtt.Message) {
 		var data nextStepPayload
 		if err := json.Unmarshal(m.Payload(), &data); err != nil {
 			log.Printf("Error unmarshalling next step: %v", err)
 			return
 		}
-		log.Println("[Vehicle] The next step is", data.NextStep)
-		nextStepCh <- data.NextStep
+		log.Println("[Vehicle]", vehicleID, "next step is", data.NextStep)
+		select {
+		case nextStepCh <- data:
+		case <-done:
+		}
 	})
+	defer client.Unsubscribe(trackTopic, stepTopic)
 
-	adjacency, err := trackGraph.AdjacencyMap()
+	speedTopic := hyperdrive.SpeedTopic.Format(values)
+	velocityCh := make(chan float64)
+	client.Subscribe(speedTopic, 1, func(c mqtt.Client, m mqtt.Message) {
+		var data hyperdrive.SpeedPayload
+		if err := json.Unmarshal(m.Payload(), &data); err != nil {
+			return
+		}
+		select {
+		case velocityCh <- float64(data.Velocity):
+		case <-done:
+		}
+	})
+	defer client.Unsubscribe(speedTopic)
+
+	adjacency, err := track.Graph.AdjacencyMap()
 	if err != nil {
 		log.Fatal("Unable to generate Adjacency map:", err)
 	}
 
-	history := make([]string, 0, 4)
-	predictionTimeout := 1000 * time.Millisecond
-	timer := time.NewTicker(predictionTimeout)
-	defer timer.Stop()
+	localizer := NewLocalizer(track, adjacency, instruct.VelocityValue)
+	ticker := time.NewTicker(localizerInterval)
+	defer ticker.Stop()
 
-	updateHistory := func(newNode string) {
-		if len(history) > 0 && history[len(history)-1] == newNode {
+	// position is the last published position, the most likely node once it is likely enough.
+	position := ""
+	publishEstimate := func(event bool) {
+		node, confidence, ok := localizer.Estimate()
+		if !ok {
 			return
 		}
-		if len(history) >= 4 {
-			history = history[1:]
+		util.SendJSON(client, vehicleBeliefTopic.Format(values), beliefPayload{node, confidence, topBelief(localizer.Belief(), beliefSize)})
+		if node == position || confidence < minConfidence {
+			return
 		}
-		history = append(history, newNode)
+		if !event && nodePiece(node) != nodePiece(position) {
+			util.SendJSON(client, vehiclePredictionTopic.Format(values), tilePayload{ID: nodePiece(node)})
+		}
+		position = node
+		util.SendJSON(client, vehiclePositionTopic.Format(values), positionPayload{position})
 	}
 
 	for {
 
 		select {
+		case <-done:
+			log.Println("Stopped tracking for Vehicle ID:", vehicleID)
+			return
+
 		case trackData := <-trackCh: // Getting data from vehicle
 			if trackData.Value.TrackID == 0 {
 				log.Println("Received invalid ID of 0")
 				continue
 			}
 
-			currentPositionNode := calculatePositionNode(trackData.Value.TrackID, trackData.Value.TrackLocation)
-			updateHistory(currentPositionNode)
-
-			fmt.Printf("Track Update. History: %v\n", history)
-
-			util.SendJSON(client, vehicleAbsolutePositionTopic, tilePayload{ID: trackData.Value.TrackID})
-			util.SendJSON(client, vehiclePositionTopic, positionPayload{history[len(history)-1]})
-			timer.Reset(predictionTimeout)
-
-		case <-timer.C: // prediction on timeout
-			if len(history) == 0 {
-				continue
-			}
-			fmt.Println("------ PREDICTION --------")
-
-			currentNode := history[len(history)-1]
-			predictedNode, ok := predictNextNode(currentNode, history, adjacency)
-
-			if !ok {
-				log.Println("Could not predict next node")
-				continue
-			}
+			node := track.PositionNode(trackData.Value.TrackID, trackData.Value.TrackLocation, trackData.Value.Direction)
+			localizer.Observe(node, time.Now())
 
-			// Extract ID from string (e.g., "02.curve" -> 2)
-			predictedID, _ := strconv.Atoi(predictedNode[:2])
+			util.SendJSON(client, vehicleAbsolutePositionTopic.Format(values), tilePayload{ID: trackData.Value.TrackID})
+			publishEstimate(true)
 
-			fmt.Printf("Predicted: %s. New History: %v\n", predictedNode, history)
+		case velocity := <-velocityCh:
+			localizer.SetVelocity(velocity, time.Now())
 
-			updateHistory(predictedNode)
-			util.SendJSON(client, vehiclePredictionTopic, tilePayload{ID: predictedID})
-			util.SendJSON(client, vehiclePositionTopic, positionPayload{history[len(history)-1]})
+		case now := <-ticker.C: // the particles drive on between the track events
+			localizer.Advance(now)
+			publishEstimate(false)
 
-		case nextStep := <-nextStepCh:
-			// fmt.Println("[Vehicle] nextStep:", nextStep, "currentPositionNode:", history[len(history)-1])
-			currentNode := history[len(history)-1]
-			if nextStep == "" || history[len(history)-1] == "" {
+		case step := <-nextStepCh:
+			nextStep := step.NextStep
+			currentNode := position
+			if nextStep == "" || currentNode == "" {
 				continue
 			}
 
@@ -201,50 +196,12 @@ func VehicleTracking(client mqtt.Client, trackGraph graph.Graph[string, string])
 				}
 			}
 
-			// if slices.Contains(history, nextStep) {
-			// 	// if the next step is in the history, it means we have to drive back.
-			// 	instruction.Forward = false
-			// } else {
-			instruction.Forward = true
-			// }
+			// A U-turn edge of the graph means the route continues in the other direction of travel.
+			instruction.Forward = !IsUTurn(currentNode, nextStep)
+			instruction.Wait = step.Wait
 
-			util.SendJSON(client, instruct.InstructionTopic, instruction)
+			util.SendJSON(client, instruct.InstructionTopic.Format(values), instruction)
 			log.Println("[Vehicle] To go to next step, going:", instruction)
 		}
 	}
 }
-
-// predictNextNode calculates the most likely next node based on history and graph.
-func predictNextNode(current string, history []string, adjacency map[string]map[string]graph.Edge[string]) (string, bool) {
-	neighbours, exists := adjacency[current]
-	if !exists || len(neighbours) == 0 {
-		return "", false
-	}
-
-	// Filter candidates
-	candidates := make([]string, 0, len(neighbours))
-	currentPrefix := current[:2] // Assumes ID format "XX....."
-
-	for k := range neighbours {
-		// not the same tile id (don't change lanes)
-		if len(k) >= 2 && k[:2] == currentPrefix {
-			continue
-		}
-		// don't go back
-		if slices.Contains(history, k) {
-			continue
-		}
-		candidates = append(candidates, k)
-	}
-
-	if len(candidates) == 0 {
-		return "", false
-	}
-
-	// Sort by probability (Ascending order based on original code logic)
-	sort.Slice(candidates, func(i, j int) bool {
-		return getPredictionProbability(candidates[i]) < getPredictionProbability(candidates[j])
-	})
-
-	return candidates[0], true
-}
@@ -12,21 +12,29 @@ const (
 	VehicleIDTopic = "/hobHq10yb9dKwxrdfhtT/vehicle/id"
 )
 
+// VehicleIdPayload adds a vehicle to the pathfinder, or removes it when Remove is set.
 type VehicleIdPayload struct {
-	ID string `json:"id"`
+	ID     string `json:"id"`
+	Remove bool   `json:"remove,omitempty"`
 }
 
-func WaitForVehicleID(client mqtt.Client) string {
-	ch := make(chan string)
-	client.Subscribe(VehicleIDTopic, 1, func(c mqtt.Client, m mqtt.Message) {
+// WatchVehicles calls onAdd and onRemove every time a vehicle is added to or removed from the pathfinder.
+func WatchVehicles(client mqtt.Client, onAdd func(id string), onRemove func(id string)) error {
+	token := client.Subscribe(VehicleIDTopic, 1, func(c mqtt.Client, m mqtt.Message) {
 		var data VehicleIdPayload
-		if err := json.Unmarshal(m.Payload(), &data); err == nil {
-			ch <- data.ID
+		if err := json.Unmarshal(m.Payload(), &data); err != nil || data.ID == "" {
+			return
+		}
+		if data.Remove {
+			onRemove(data.ID)
+		} else {
+			onAdd(data.ID)
 		}
 	})
-
-	defer client.Unsubscribe(VehicleIDTopic)
-	return <-ch
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
 }
 
 func SendJSON(client mqtt.Client, topic string, payload interface{}) {
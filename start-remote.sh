@@ -3,6 +3,7 @@
 set -xe
 
 go run . &
-go run ./emergency/main.go &
+go run ./emergency &
+go run ./occupancy &
 
-echo "Launched both programs."
\ No newline at end of file
+echo "Launched all programs."
@@ -0,0 +1,131 @@
+// Package topic provides MQTT topic templates with named placeholders.
+//
+// A template is a topic where whole levels can be placeholders, for example
+// "RemoteControl/U/E/vehicles/{vehicle}/{type}". The same template formats the
+// topics that are published, matches the incoming topics to extract the values,
+// and gives the wildcard filter to subscribe to.
+package topic
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Template is an MQTT topic where levels written as {name} are placeholders.
+// A placeholder always covers a whole level and matches exactly one level.
+type Template string
+
+// Values maps placeholder names to their values.
+type Values map[string]string
+
+// placeholder returns the name of the placeholder of a topic level, if it is one.
+func placeholder(level string) (string, bool) {
+	if len(level) > 2 && level[0] == '{' && level[len(level)-1] == '}' {
+		return level[1 : len(level)-1], true
+	}
+	return "", false
+}
+
+func (t Template) levels() []string {
+	return strings.Split(string(t), "/")
+}
+
+func (t Template) String() string {
+	return string(t)
+}
+
+// Validate checks that placeholders cover whole levels, have a name and are not repeated,
+// and that the required placeholders are present.
+func (t Template) Validate(required ...string) error {
+	seen := map[string]bool{}
+	for i, level := range t.levels() {
+		if name, ok := placeholder(level); ok {
+			if strings.ContainsAny(name, "{}/+#") {
+				return fmt.Errorf("topic %q: invalid placeholder %q", t, level)
+			}
+			if seen[name] {
+				return fmt.Errorf("topic %q: placeholder {%s} used twice", t, name)
+			}
+			seen[name] = true
+			continue
+		}
+		if strings.ContainsAny(level, "{}") {
+			return fmt.Errorf("topic %q: placeholder in %q must cover the whole level", t, level)
+		}
+		if strings.Contains(level, "%") {
+			return fmt.Errorf("topic %q: %q looks like a fmt verb, use a {name} placeholder", t, level)
+		}
+		if level == "#" && i != len(t.levels())-1 {
+			return fmt.Errorf("topic %q: # must be the last level", t)
+		}
+	}
+	for _, name := range required {
+		if !seen[name] {
+			return fmt.Errorf("topic %q: missing placeholder {%s}", t, name)
+		}
+	}
+	return nil
+}
+
+// Placeholders returns the names of the placeholders, in order.
+func (t Template) Placeholders() []string {
+	var names []string
+	for _, level := range t.levels() {
+		if name, ok := placeholder(level); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Format replaces the placeholders by their values. Placeholders without a value
+// become the + wildcard, so a partially formatted template is a valid filter.
+func (t Template) Format(values Values) string {
+	levels := t.levels()
+	for i, level := range levels {
+		if name, ok := placeholder(level); ok {
+			if value, ok := values[name]; ok {
+				levels[i] = value
+			} else {
+				levels[i] = "+"
+			}
+		}
+	}
+	return strings.Join(levels, "/")
+}
+
+// Filter returns the subscription filter matching every topic of the template.
+func (t Template) Filter() string {
+	return t.Format(nil)
+}
+
+// Match checks whether topic was produced by the template and extracts the placeholder values.
+// A trailing # level in the template matches any remaining levels.
+func (t Template) Match(topic string) (Values, bool) {
+	levels := t.levels()
+	parts := strings.Split(topic, "/")
+	values := Values{}
+
+	for i, level := range levels {
+		if level == "#" && i == len(levels)-1 {
+			return values, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		if name, ok := placeholder(level); ok {
+			if parts[i] == "" {
+				return nil, false
+			}
+			values[name] = parts[i]
+			continue
+		}
+		if level != "+" && level != parts[i] {
+			return nil, false
+		}
+	}
+	if len(parts) != len(levels) {
+		return nil, false
+	}
+	return values, true
+}
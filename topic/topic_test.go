@@ -0,0 +1,99 @@
+package topic
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		template Template
+		values   Values
+		want     string
+	}{
+		{"RemoteControl/U/E/vehicles/{vehicle}/speed", Values{"vehicle": "ab12"}, "RemoteControl/U/E/vehicles/ab12/speed"},
+		{"RemoteControl/U/E/vehicles/{vehicle}/{type}", Values{"vehicle": "ab12", "type": "lane"}, "RemoteControl/U/E/vehicles/ab12/lane"},
+		{"RemoteControl/U/E/vehicles/{vehicle}/{type}", Values{"vehicle": "ab12"}, "RemoteControl/U/E/vehicles/ab12/+"},
+		{"Anki/Vehicles/U/{vehicle}/E/track", nil, "Anki/Vehicles/U/+/E/track"},
+		{"Emergency/U/E/stop", Values{"vehicle": "ab12"}, "Emergency/U/E/stop"},
+		{"{root}/vehicle/{vehicle}", Values{"root": "Hyperdrive", "vehicle": "ab12"}, "Hyperdrive/vehicle/ab12"},
+	}
+	for _, tt := range tests {
+		if got := tt.template.Format(tt.values); got != tt.want {
+			t.Errorf("%q.Format(%v) = %q, want %q", tt.template, tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		template Template
+		want     string
+	}{
+		{"RemoteControl/U/E/vehicles/{vehicle}/{type}", "RemoteControl/U/E/vehicles/+/+"},
+		{"Anki/Vehicles/U/{vehicle}/S/DIT/{type}", "Anki/Vehicles/U/+/S/DIT/+"},
+		{"Emergency/U/E/stop", "Emergency/U/E/stop"},
+		{"Anki/Vehicles/U/{vehicle}/#", "Anki/Vehicles/U/+/#"},
+	}
+	for _, tt := range tests {
+		if got := tt.template.Filter(); got != tt.want {
+			t.Errorf("%q.Filter() = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		template Template
+		topic    string
+		want     Values // nil: no match
+	}{
+		{"RemoteControl/U/E/vehicles/{vehicle}/{type}", "RemoteControl/U/E/vehicles/ab12/lane", Values{"vehicle": "ab12", "type": "lane"}},
+		{"RemoteControl/U/E/vehicles/{vehicle}/speed", "RemoteControl/U/E/vehicles/ab12/speed", Values{"vehicle": "ab12"}},
+		{"RemoteControl/U/E/vehicles/{vehicle}/speed", "RemoteControl/U/E/vehicles/ab12/lane", nil},
+		{"RemoteControl/U/E/vehicles/{vehicle}/speed", "RemoteControl/U/E/vehicles/ab12/speed/extra", nil},
+		{"RemoteControl/U/E/vehicles/{vehicle}/speed", "RemoteControl/U/E/vehicles/ab12", nil},
+		{"RemoteControl/U/E/vehicles/{vehicle}/speed", "RemoteControl/U/E/vehicles//speed", nil},
+		{"Anki/Vehicles/U/{vehicle}/#", "Anki/Vehicles/U/ab12/E/track", Values{"vehicle": "ab12"}},
+		{"Anki/Vehicles/U/+/E/{event}", "Anki/Vehicles/U/ab12/E/track", Values{"event": "track"}},
+		{"Emergency/U/E/stop", "Emergency/U/E/stop", Values{}},
+	}
+	for _, tt := range tests {
+		got, ok := tt.template.Match(tt.topic)
+		if ok != (tt.want != nil) || !maps.Equal(got, tt.want) {
+			t.Errorf("%q.Match(%q) = %v, %v, want %v", tt.template, tt.topic, got, ok, tt.want)
+		}
+	}
+}
+
+// A formatted topic matches its template with the same values.
+func TestMatchFormat(t *testing.T) {
+	template := Template("Anki/Vehicles/U/{vehicle}/S/DIT/{type}")
+	values := Values{"vehicle": "ab12", "type": "speedSubscription"}
+	got, ok := template.Match(template.Format(values))
+	if !ok || !maps.Equal(got, values) {
+		t.Errorf("Match(Format(%v)) = %v, %v", values, got, ok)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		template Template
+		required []string
+		wantErr  bool
+	}{
+		{"RemoteControl/U/E/vehicles/{vehicle}/{type}", []string{"vehicle", "type"}, false},
+		{"RemoteControl/U/E/vehicles/{vehicle}/speed", []string{"vehicle"}, false},
+		{"RemoteControl/U/E/vehicles/{vehicle}/speed", []string{"type"}, true},
+		{"RemoteControl/U/E/vehicles/{vehicle}/{vehicle}", nil, true},
+		{"RemoteControl/U/E/vehicles/car-{vehicle}", nil, true},
+		{"RemoteControl/U/E/vehicles/%s/speed", nil, true},
+		{"Anki/#/track", nil, true},
+		{"Anki/Vehicles/U/{vehicle}/#", []string{"vehicle"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.template.Validate(tt.required...); (err != nil) != tt.wantErr {
+			t.Errorf("%q.Validate(%v) = %v, want error: %v", tt.template, tt.required, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Command track checks and maintains the track definitions of the pathfinder.
+//
+//	go run ./track lint [file]        report the mistakes of a track definition (default: assets/track.yml)
+//	go run ./track dot [file] [out]   write the track graph in the Graphviz DOT format (default: stdout)
+//	go run ./track scan -vehicle ID   drive a car around the track and write a draft track definition
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hyperdrive/remote/pathfind/path"
+	"log"
+	"os"
+)
+
+const defaultTrackFile = "assets/track.yml"
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: track <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  lint [file]        report the mistakes of a track definition (default:", defaultTrackFile+")")
+	fmt.Fprintln(os.Stderr, "  dot [file] [out]   write the track graph in the Graphviz DOT format (default: stdout)")
+	fmt.Fprintln(os.Stderr, "  scan -vehicle ID   drive a car around the track and write a draft track definition (see scan -h)")
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+
+	switch flag.Arg(0) {
+	case "lint":
+		os.Exit(lint(flag.Args()[1:]))
+	case "dot":
+		os.Exit(dot(flag.Args()[1:]))
+	case "scan":
+		os.Exit(scan(flag.Args()[1:]))
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown command", flag.Arg(0))
+		usage()
+	}
+}
+
+// lint prints the problems of the track definition as file:line: message and returns the exit code.
+func lint(args []string) int {
+	file := defaultTrackFile
+	if len(args) > 0 {
+		file = args[0]
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		log.Println("Could not read the track definition:", err)
+		return 2
+	}
+	problems, err := path.LintTrack(b)
+	if err != nil {
+		log.Println("Could not parse", file, ":", err)
+		return 2
+	}
+
+	for _, p := range problems {
+		fmt.Printf("%s:%s\n", file, p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems in %s\n", len(problems), file)
+		return 1
+	}
+	return 0
+}
+
+// dot writes the graph of the track definition as DOT to a file or stdout and returns the exit code.
+func dot(args []string) int {
+	file := defaultTrackFile
+	if len(args) > 0 {
+		file = args[0]
+	}
+
+	track, err := path.LoadTrackFile(file)
+	if err != nil {
+		log.Println("Could not load the track definition:", err)
+		return 2
+	}
+
+	if len(args) > 1 {
+		err = track.ExportDOT(args[1])
+	} else {
+		err = track.WriteDOT(os.Stdout)
+	}
+	if err != nil {
+		log.Println("Could not write the track graph:", err)
+		return 2
+	}
+	return 0
+}
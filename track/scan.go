@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hyperdrive/remote/hyperdrive"
+	"hyperdrive/remote/pathfind/path"
+	"hyperdrive/remote/topic"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+)
+
+type trackPayload struct {
+	Value struct {
+		TrackID       int    `json:"trackID"`
+		TrackLocation int    `json:"trackLocation"`
+		Direction     string `json:"direction"`
+	} `json:"value"`
+}
+
+// scan drives a car slowly around the track in each lane, records its track events and writes a
+// draft track definition, then prints the differences with the known one. It returns the exit code.
+func scan(args []string) int {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	broker := fs.String("broker", "10.42.0.1:1883", "MQTT broker URL")
+	vehicle := fs.String("vehicle", "", "ID of the connected car that drives the scan (required)")
+	velocity := fs.Float64("velocity", 250, "Velocity of the car while scanning")
+	acceleration := fs.Float64("acceleration", 200, "Acceleration of the car")
+	lanesFlag := fs.String("lanes", "-68,-23,23,68", "Comma separated lane offsets from the center, the car drives in each of them")
+	laneTime := fs.Duration("lane-time", 45*time.Second, "Driving time in each lane, long enough for a few laps")
+	trackFile := fs.String("track", defaultTrackFile, "Known track definition, for the shapes, the piece types and the diff")
+	out := fs.String("out", "assets/track-scan.yml", "Draft track definition to write")
+	minCount := fs.Int("min-count", 2, "Connections seen fewer times are left out of the draft, as misread events")
+	fs.Parse(args)
+
+	if *vehicle == "" {
+		log.Println("scan needs the ID of a car, see -vehicle")
+		return 2
+	}
+	var lanes []float32
+	for _, field := range strings.Split(*lanesFlag, ",") {
+		offset, err := strconv.ParseFloat(strings.TrimSpace(field), 32)
+		if err != nil {
+			log.Println("Invalid -lanes:", err)
+			return 2
+		}
+		lanes = append(lanes, float32(offset))
+	}
+	known, err := path.LoadTrackFile(*trackFile)
+	if err != nil {
+		log.Println("Could not load the known track definition:", err)
+		return 2
+	}
+
+	controllerID := "TrackScan-" + uuid.NewString()
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(*broker)
+	opts.SetClientID(controllerID)
+	hyperdrive.SetHeartbeatWill(opts, controllerID)
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		log.Println("Could not connect to broker:", token.Error())
+		return 2
+	}
+	defer client.Disconnect(250)
+
+	heartbeat := hyperdrive.NewHeartbeat(client, controllerID, hyperdrive.HeartbeatInterval)
+	heartbeat.Start()
+	defer heartbeat.Stop()
+	heartbeat.AddVehicle(*vehicle)
+	defer heartbeat.RemoveVehicle(*vehicle)
+
+	var mu sync.Mutex
+	result := path.NewScan(known)
+	trackTopic := hyperdrive.VehicleTrackTopic.Format(topic.Values{"vehicle": *vehicle})
+	if token := client.Subscribe(trackTopic, 1, func(c mqtt.Client, m mqtt.Message) {
+		var data []trackPayload
+		if err := json.Unmarshal(m.Payload(), &data); err != nil || len(data) == 0 {
+			return
+		}
+		mu.Lock()
+		result.Add(*vehicle, data[0].Value.TrackID, data[0].Value.TrackLocation, data[0].Value.Direction)
+		mu.Unlock()
+	}); token.Wait() && token.Error() != nil {
+		log.Println("Could not subscribe to", trackTopic, ":", token.Error())
+		return 2
+	}
+	defer client.Unsubscribe(trackTopic)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	d := driver{client, *vehicle, heartbeat.Controller()}
+	d.publish(hyperdrive.ConnectTopic, hyperdrive.ConnectPayload{Value: true, Controller: d.controller})
+	time.Sleep(2 * time.Second)
+	d.publish(hyperdrive.SpeedTopic, hyperdrive.SpeedPayload{Velocity: float32(*velocity), Acceleration: float32(*acceleration), Controller: d.controller})
+
+drive:
+	for _, offset := range lanes {
+		log.Printf("[Scan] Driving in lane %.0f for %s", offset, *laneTime)
+		d.publish(hyperdrive.LaneTopic, hyperdrive.LanePayload{
+			Velocity:         float32(*velocity),
+			Acceleration:     float32(*acceleration),
+			OffsetFromCenter: offset,
+			Controller:       d.controller,
+		})
+		select {
+		case <-time.After(*laneTime):
+		case <-interrupt:
+			log.Println("[Scan] Interrupted, writing what was recorded")
+			break drive
+		}
+	}
+	d.publish(hyperdrive.SpeedTopic, hyperdrive.SpeedPayload{Velocity: 0, Acceleration: float32(*acceleration), Controller: d.controller})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if result.Events() == 0 {
+		log.Println("[Scan] No track events recorded, is the car connected and driving?")
+		return 1
+	}
+	draft, err := result.Draft(*minCount)
+	if err != nil {
+		log.Println("Could not build the draft:", err)
+		return 2
+	}
+	if err := os.WriteFile(*out, draft, 0o644); err != nil {
+		log.Println("Could not write the draft:", err)
+		return 2
+	}
+	log.Printf("[Scan] %d track events, %d pieces, draft written to %s", result.Events(), len(result.Pieces()), *out)
+
+	edges, _ := result.Edges(*minCount)
+	scanned := make([]path.EdgePair, len(edges))
+	for i, e := range edges {
+		scanned[i] = e.EdgePair
+	}
+	missing, added := path.DiffEdges(known.Config.Edges, scanned)
+	fmt.Printf("--- %s\n+++ %s\n", *trackFile, *out)
+	for _, e := range missing {
+		fmt.Println("-", e)
+	}
+	for _, e := range added {
+		fmt.Println("+", e)
+	}
+	return 0
+}
+
+// driver sends the intents of the scanning car through the Remote topics.
+type driver struct {
+	client     mqtt.Client
+	vehicle    string
+	controller string
+}
+
+func (d driver) publish(t topic.Template, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("[Scan] Could not marshal", payload, ":", err)
+		return
+	}
+	if token := d.client.Publish(t.Format(topic.Values{"vehicle": d.vehicle}), 1, false, data); token.Wait() && token.Error() != nil {
+		log.Println("[Scan] Could not publish on", t, ":", token.Error())
+	}
+}